@@ -0,0 +1,103 @@
+package address
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/mr-tron/base58"
+)
+
+// PubKeyHashLength 公钥哈希长度，ripemd160的输出长度
+const PubKeyHashLength = 20
+
+// AddressLength 解码后地址的长度：版本号 + 公钥哈希 + 校验和
+const AddressLength = 1 + PubKeyHashLength + constcoe.ChecksumLength
+
+var (
+	ErrEmpty           = errors.New("address is empty")
+	ErrInvalidBase58   = errors.New("address is not valid base58")
+	ErrInvalidLength   = errors.New("address has invalid length")
+	ErrInvalidVersion  = errors.New("address has invalid version")
+	ErrInvalidChecksum = errors.New("address has invalid checksum")
+)
+
+// Error 地址校验失败的错误，可以通过errors.Is判断具体的错误类型
+type Error struct {
+	Address string
+	Err     error
+	Detail  string
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %v", e.Address, e.Err)
+	}
+	return fmt.Sprintf("%s: %v (%s)", e.Address, e.Err, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Info 解码后的地址信息
+type Info struct {
+	Address    string
+	Version    byte
+	PubKeyHash []byte
+	Checksum   []byte
+}
+
+// Encode 公钥哈希转为地址
+func Encode(pubKeyHash []byte) []byte {
+	networkVersionHash := append([]byte{chaincfg.ActiveParams().AddressVersion}, pubKeyHash...)
+	checksum := utils.Checksum(networkVersionHash)
+	finalHash := append(networkVersionHash, checksum...)
+	return utils.Base58Encode(finalHash)
+}
+
+// Parse 解码并校验地址，检查base58编码、长度、版本号和校验和
+func Parse(address []byte) (*Info, error) {
+	addr := string(address)
+	if len(address) == 0 {
+		return nil, &Error{Address: addr, Err: ErrEmpty}
+	}
+	decoded, err := base58.Decode(addr)
+	if err != nil {
+		return nil, &Error{Address: addr, Err: ErrInvalidBase58, Detail: err.Error()}
+	}
+	if len(decoded) != AddressLength {
+		return nil, &Error{Address: addr, Err: ErrInvalidLength, Detail: fmt.Sprintf("got %d bytes, want %d", len(decoded), AddressLength)}
+	}
+	if version := chaincfg.ActiveParams().AddressVersion; decoded[0] != version {
+		return nil, &Error{Address: addr, Err: ErrInvalidVersion, Detail: fmt.Sprintf("got %#02x, want %#02x for %s", decoded[0], version, chaincfg.ActiveParams().Name)}
+	}
+	payload := decoded[:len(decoded)-constcoe.ChecksumLength]
+	checksum := decoded[len(decoded)-constcoe.ChecksumLength:]
+	if !bytes.Equal(utils.Checksum(payload), checksum) {
+		return nil, &Error{Address: addr, Err: ErrInvalidChecksum}
+	}
+	return &Info{
+		Address:    addr,
+		Version:    decoded[0],
+		PubKeyHash: decoded[1 : len(decoded)-constcoe.ChecksumLength],
+		Checksum:   checksum,
+	}, nil
+}
+
+// Validate 校验地址是否合法
+func Validate(address []byte) error {
+	_, err := Parse(address)
+	return err
+}
+
+// Decode 地址转为公钥哈希
+func Decode(address []byte) ([]byte, error) {
+	info, err := Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	return info.PubKeyHash, nil
+}
@@ -0,0 +1,130 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+)
+
+// 地址索引记录每个地址收到的输出，输出被花费后仍然保留，区块体被裁剪之后也能查询
+// key为前缀、公钥哈希长度、公钥哈希、8字节的高度、交易ID和4字节的输出下标，值为金额
+// 同一个地址的输出按高度排列，可以按地址和高度前缀遍历
+
+// AddressOutput 地址索引中的一个输出
+type AddressOutput struct {
+	OutPoint
+	Value  int
+	Height int
+}
+
+func addrPrefix(pubKeyHash []byte) []byte {
+	key := append([]byte(constcoe.AddrIndexPrefix), byte(len(pubKeyHash)))
+	return append(key, pubKeyHash...)
+}
+
+func addrIndexKey(pubKeyHash []byte, height int, op OutPoint) []byte {
+	key := binary.BigEndian.AppendUint64(addrPrefix(pubKeyHash), uint64(height))
+	key = append(key, op.TxID...)
+	return binary.BigEndian.AppendUint32(key, uint32(op.OutIdx))
+}
+
+// parseAddrIndexKey 解析key中公钥哈希之后的部分
+func parseAddrIndexKey(prefix, key, value []byte) (AddressOutput, error) {
+	rest := key[len(prefix):]
+	if len(rest) < 8+4 {
+		return AddressOutput{}, fmt.Errorf("decode address index: bad key %x", key)
+	}
+	value64, n := binary.Varint(value)
+	if n <= 0 {
+		return AddressOutput{}, fmt.Errorf("decode address index: bad value %x", value)
+	}
+	idx := len(rest) - 4
+	return AddressOutput{
+		OutPoint: OutPoint{
+			TxID:   append([]byte(nil), rest[8:idx]...),
+			OutIdx: int(binary.BigEndian.Uint32(rest[idx:])),
+		},
+		Value:  int(value64),
+		Height: int(binary.BigEndian.Uint64(rest[:8])),
+	}, nil
+}
+
+func putAddressOutputs(txn storage.Tx, tx *transaction.Transaction, height int) error {
+	for idx, out := range tx.Outputs {
+		key := addrIndexKey(out.PubKeyHash, height, OutPoint{TxID: tx.ID, OutIdx: idx})
+		if err := txn.Set(key, binary.AppendVarint(nil, int64(out.Value))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteAddressOutputs(txn storage.Tx, tx *transaction.Transaction, height int) error {
+	for idx, out := range tx.Outputs {
+		if err := txn.Delete(addrIndexKey(out.PubKeyHash, height, OutPoint{TxID: tx.ID, OutIdx: idx})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildAddressIndex 由区块体重建地址索引，被裁剪的区块和快照之前的区块不在索引中
+func buildAddressIndex(db storage.ChainStore, tip []byte) error {
+	chain := &BlockChain{LastHash: tip, Database: db}
+	hashes, err := chain.chainHashes()
+	if err != nil {
+		return err
+	}
+	pruneHeight := 0
+	err = db.View(func(txn storage.Tx) error {
+		var err error
+		pruneHeight, err = getPruneHeight(txn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = db.DropPrefix([]byte(constcoe.AddrIndexPrefix)); err != nil {
+		return err
+	}
+	for height := pruneHeight; height < len(hashes); height++ {
+		err = db.Update(func(txn storage.Tx) error {
+			block, err := getBlock(txn, hashes[height])
+			if err != nil {
+				return err
+			}
+			for _, tx := range block.Transactions {
+				if err = putAddressOutputs(txn, tx, height); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("build address index at height %d: %w", height, err)
+		}
+	}
+	return nil
+}
+
+// AddressOutputs 返回地址收到的所有输出，包括已经花费的输出，key中高度在前，遍历的结果按高度排列
+func (bc *BlockChain) AddressOutputs(pubKeyHash []byte) ([]AddressOutput, error) {
+	outputs := make([]AddressOutput, 0)
+	prefix := addrPrefix(pubKeyHash)
+	err := bc.Database.View(func(txn storage.Tx) error {
+		return txn.ForEach(prefix, func(key, value []byte) error {
+			out, err := parseAddrIndexKey(prefix, key, value)
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, out)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
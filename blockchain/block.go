@@ -2,83 +2,165 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/clock"
 	"github.com/limitzhang87/goblockchain/merkletree"
 	"github.com/limitzhang87/goblockchain/transaction"
-	"github.com/limitzhang87/goblockchain/utils"
-	"time"
+	"github.com/limitzhang87/goblockchain/wire"
 )
 
+// BlockVersion 区块编码的版本
+const BlockVersion = 2
+
+// Block 区块，区块哈希只由区块头决定
 type Block struct {
-	Timestamp    int64
+	BlockHeader
 	Hash         []byte
-	PrevHash     []byte
-	Target       []byte
-	Nonce        int64
 	Transactions []*transaction.Transaction
-	MTree        *merkletree.MerkleTree
 }
 
 func GenesisBlock(address []byte) *Block {
 	tx := transaction.BaseTx(address)
-	genesis := CreateBlock([]byte("limitZhang is awesome!"), []*transaction.Transaction{tx})
+	genesis := CreateBlock([]byte(chaincfg.ActiveParams().GenesisMessage), []*transaction.Transaction{tx})
 	genesis.SetHash()
 	return genesis
 }
 
 func CreateBlock(prevHash []byte, txs []*transaction.Transaction) *Block {
+	block := NewBlockTemplate(prevHash, txs)
+	block.FindNonce()
+	return block
+}
+
+// NewBlockTemplate 创建还没有计算工作量的区块
+func NewBlockTemplate(prevHash []byte, txs []*transaction.Transaction) *Block {
 	block := &Block{
-		Timestamp:    time.Now().Unix(),
+		BlockHeader: BlockHeader{
+			Version:   HeaderVersion,
+			PrevHash:  prevHash,
+			Timestamp: clock.Now().Unix(),
+			Bits:      uint32(chaincfg.ActiveParams().Difficulty),
+			Nonce:     0,
+		},
 		Hash:         []byte{},
-		PrevHash:     prevHash,
-		Target:       []byte{},
-		Nonce:        0,
 		Transactions: txs,
-		MTree:        merkletree.CreateMerkleTree(txs),
 	}
-	block.Target = block.GetTarget()
-	block.Nonce = block.FindNonce()
-	block.SetHash()
+	block.updateMerkleRoot()
 	return block
 }
 
-func (b *Block) BackTransactionSummary() []byte {
-	txIDs := make([][]byte, 0, len(b.Transactions))
+// MerkleTree 按区块头版本的规则用交易创建merkle树，需要时再创建，不保存在区块中
+func (b *Block) MerkleTree() *merkletree.MerkleTree {
+	ids := make([][]byte, 0, len(b.Transactions))
 	for _, tx := range b.Transactions {
-		txIDs = append(txIDs, tx.ID)
+		ids = append(ids, tx.ID)
 	}
-	summary := bytes.Join(txIDs, []byte{})
-	return summary
+	return merkletree.New(b.merkleVersion(), ids)
+}
+
+// updateMerkleRoot 交易变化后重新计算merkle根
+func (b *Block) updateMerkleRoot() {
+	b.MerkleRoot = b.MerkleTree().Root()
+}
+
+// ValidateMerkleRoot 区块头中的merkle根要和交易一致
+func (b *Block) ValidateMerkleRoot() bool {
+	return bytes.Equal(b.MerkleRoot, b.MerkleTree().Root())
+}
+
+// Header 返回区块头的拷贝
+func (b *Block) Header() *BlockHeader {
+	header := b.BlockHeader
+	return &header
 }
 
 func (b *Block) SetHash() {
-	information := bytes.Join([][]byte{
-		utils.ToHexInt(b.Timestamp),
-		b.PrevHash,
-		b.Target,
-		utils.ToHexInt(b.Nonce),
-		b.BackTransactionSummary(),
-		b.MTree.Root.Data,
-	}, []byte{})
-	hash := sha256.Sum256(information)
-	b.Hash = hash[:]
-}
-
-func (b *Block) Serialize() []byte {
-	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	err := encoder.Encode(b)
-	utils.Handle(err)
-	return buf.Bytes()
-}
-
-func DeSerializeBlock(data []byte) *Block {
-	block := new(Block) // new 返回一个对象指针，并将指针指向一块内存
-	// var block *Block 不能使用这种语法，声明一个指针，结果没有给指针分配内存，导致指针指向为空，后面使用时会报错
-	buf := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buf)
-	err := decoder.Decode(block)
-	utils.Handle(err)
-	return block
+	b.Hash = b.BlockHash()
+}
+
+// Serialize 区块的二进制编码：区块头、区块哈希和交易
+// 旧区块的哈希不能由区块头计算出来，所以区块哈希也需要保存
+func (b *Block) Serialize() ([]byte, error) {
+	w := wire.NewWriter()
+	w.WriteUvarint(BlockVersion)
+	b.BlockHeader.Encode(w)
+	w.WriteBytes(b.Hash)
+	encodeTransactions(w, b.Transactions)
+	return w.Bytes(), nil
+}
+
+func DeSerializeBlock(data []byte) (*Block, error) {
+	if err := checkBlockDataSize(data); err != nil {
+		return nil, fmt.Errorf("decode block: %w", err)
+	}
+	r := wire.NewReader(data)
+	version := r.ReadUvarint()
+	if r.Err() == nil && version != BlockVersion {
+		return nil, fmt.Errorf("decode block: %w %d", wire.ErrUnknownVersion, version)
+	}
+	header := DecodeBlockHeader(r)
+	hash := r.ReadBytes()
+	txs := decodeTransactions(r)
+	if err := r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode block: %w", err)
+	}
+	return newBlock(header, hash, txs)
+}
+
+// newBlock 用区块头和交易组成区块
+func newBlock(header *BlockHeader, hash []byte, txs []*transaction.Transaction) (*Block, error) {
+	if err := header.check(); err != nil {
+		return nil, err
+	}
+	if len(txs) == 0 {
+		return nil, errors.New("decode block: block has no transaction")
+	}
+	return &Block{
+		BlockHeader:  *header,
+		Hash:         hash,
+		Transactions: txs,
+	}, nil
+}
+
+// serializeBody 区块体只包含交易，和区块头分开保存
+func (b *Block) serializeBody() []byte {
+	w := wire.NewWriter()
+	encodeTransactions(w, b.Transactions)
+	return w.Bytes()
+}
+
+func deserializeBody(data []byte) ([]*transaction.Transaction, error) {
+	if err := checkBlockDataSize(data); err != nil {
+		return nil, fmt.Errorf("decode block body: %w", err)
+	}
+	r := wire.NewReader(data)
+	txs := decodeTransactions(r)
+	if err := r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode block body: %w", err)
+	}
+	return txs, nil
+}
+
+// checkBlockDataSize 解码之前检查区块数据的大小，区块体比整个区块小，使用同样的限制
+func checkBlockDataSize(data []byte) error {
+	return wire.CheckSize(data, chaincfg.ActiveParams().MaxBlockSize)
+}
+
+func encodeTransactions(w *wire.Writer, txs []*transaction.Transaction) {
+	w.WriteUvarint(uint64(len(txs)))
+	for _, tx := range txs {
+		tx.Encode(w)
+	}
+}
+
+func decodeTransactions(r *wire.Reader) []*transaction.Transaction {
+	// 一笔交易至少4个字节：版本、ID长度、输入个数、输出个数
+	txCount := r.ReadCount(4)
+	txs := make([]*transaction.Transaction, 0, txCount)
+	for i := 0; i < txCount; i++ {
+		txs = append(txs, transaction.DecodeTransaction(r))
+	}
+	return txs
 }
@@ -6,16 +6,22 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/dgraph-io/badger"
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/config"
 	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
 	"github.com/limitzhang87/goblockchain/transaction"
 	"github.com/limitzhang87/goblockchain/utils"
-	"runtime"
+	"io"
+	"math"
 )
 
 type BlockChain struct {
 	LastHash []byte
-	Database *badger.DB
+	Database storage.ChainStore
+	Repaired bool      // 打开时链状态和最新区块不一致，已经从区块重建
+	Log      io.Writer // 输出不影响加入区块结果的错误，例如裁剪失败，为空时不输出
 }
 
 //// CreateBlockChain 创建区块链
@@ -26,171 +32,233 @@ type BlockChain struct {
 //}
 
 // InitBlockChain 创建区块链，首次创建并创建数据库
-func InitBlockChain(address []byte) *BlockChain {
-	var lashHash []byte
-	if utils.FileExists(constcoe.BCFile) {
-		fmt.Println("blockchain exist")
-		runtime.Goexit()
-	}
-
-	opts := badger.DefaultOptions(constcoe.BCFile)
-	opts.Logger = nil
+func InitBlockChain(address []byte) (*BlockChain, error) {
+	return initBlockChain(GenesisBlock(address))
+}
 
-	db, err := badger.Open(opts)
-	utils.Handle(err)
+// InitBlockChainWithGenesis 使用别的节点导出的创世区块创建区块链
+func InitBlockChainWithGenesis(genesis *Block) (*BlockChain, error) {
+	if !bytes.Equal(genesis.PrevHash, []byte(chaincfg.ActiveParams().GenesisMessage)) {
+		return nil, fmt.Errorf("%w: block %x is not a genesis block", ErrInvalidBlock, genesis.Hash)
+	}
+	if err := checkImportBlock(genesis); err != nil {
+		return nil, err
+	}
+	return initBlockChain(genesis)
+}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		genesis := GenesisBlock(address)
-		fmt.Println("genesis block create")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		utils.Handle(err)
+func initBlockChain(genesis *Block) (*BlockChain, error) {
+	db, err := createDatabase()
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(txn storage.Tx) error {
+		err := putBlock(txn, genesis)
+		if err != nil {
+			return err
+		}
 		err = txn.Set([]byte(constcoe.LHKey), genesis.Hash) // current block hash
-		utils.Handle(err)
+		if err != nil {
+			return err
+		}
 		err = txn.Set([]byte(constcoe.OgPrevHashKey), genesis.PrevHash) // genesis block key
-		utils.Handle(err)
-		lashHash = genesis.Hash
-		return nil
+		if err != nil {
+			return err
+		}
+		err = connectBlock(txn, genesis, 0)
+		if err != nil {
+			return err
+		}
+		return setSchema(txn)
 	})
-	utils.Handle(err)
-	blockchain := BlockChain{lashHash, db}
-	return &blockchain
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("store genesis block: %w", err)
+	}
+	blockchain := BlockChain{LastHash: genesis.Hash, Database: db}
+	return &blockchain, nil
+}
+
+// createDatabase 使用配置中的存储后端创建新的区块链数据库，数据库已经存在时返回ErrChainExists
+func createDatabase() (storage.ChainStore, error) {
+	cfg := config.Active()
+	if backend, ok := storage.Detect(cfg.BlocksDir); ok {
+		if backend != cfg.Backend {
+			return nil, fmt.Errorf("%w: found a %s database, the config uses %s", ErrChainExists, backend, cfg.Backend)
+		}
+		return nil, ErrChainExists
+	}
+	return storage.Open(cfg.Backend, cfg.BlocksDir)
 }
 
 // ContinueBlockChain 从数据库中读取区块信息创建区块链
-func ContinueBlockChain() *BlockChain {
+func ContinueBlockChain() (*BlockChain, error) {
 	// 判断区块链数据库是否存在
-	if !utils.FileExists(constcoe.BCFile) {
-		fmt.Println("blockchain does not exist, please create a new one")
-		runtime.Goexit()
+	cfg := config.Active()
+	if !storage.Exists(cfg.Backend, cfg.BlocksDir) {
+		if backend, ok := storage.Detect(cfg.BlocksDir); ok {
+			return nil, fmt.Errorf("%w: found a %s database, the config uses %s", ErrChainNotFound, backend, cfg.Backend)
+		}
+		return nil, ErrChainNotFound
 	}
 
 	var lashHash []byte
-	opts := badger.DefaultOptions(constcoe.BCFile)
-	opts.Logger = nil
-	db, err := badger.Open(opts)
-	utils.Handle(err)
-	err = db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(constcoe.LHKey))
-		if err != nil {
-			return err
-		}
-		err = item.Value(func(val []byte) error {
-			lashHash = val
-			return nil
-		})
+	db, err := storage.Open(cfg.Backend, cfg.BlocksDir)
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(txn storage.Tx) error {
+		var err error
+		lashHash, err = txn.Get([]byte(constcoe.LHKey))
+		return err
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		// 最新区块最后写入，没有最新区块说明创建区块链或者加载快照时中断了
+		err = fmt.Errorf("%w: the chain tip is missing, remove %s and create or load the chain again", ErrCorruptChainState, cfg.BlocksDir)
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("read chain tip: %w", err)
+	}
+	// 旧数据库中的区块使用gob编码，先转换为当前的编码
+	err = migrateChain(db, lashHash)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	// 检查最新区块和链状态是否一致，不一致时重建
+	repaired, err := recoverChainState(db, lashHash)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	blockchain := BlockChain{LastHash: lashHash, Database: db, Repaired: repaired}
+	return &blockchain, nil
+}
+
+// LoadBlockChain 使用已经打开并检查过的数据库创建区块链，从数据库中读取最新区块
+func LoadBlockChain(db storage.ChainStore) (*BlockChain, error) {
+	var lastHash []byte
+	err := db.View(func(txn storage.Tx) error {
+		var err error
+		lastHash, err = txn.Get([]byte(constcoe.LHKey))
 		return err
 	})
-	utils.Handle(err)
-	blockchain := BlockChain{lashHash, db}
-	return &blockchain
+	if err != nil {
+		return nil, fmt.Errorf("read chain tip: %w", err)
+	}
+	return &BlockChain{LastHash: lastHash, Database: db}, nil
 }
 
 // AddBlock 区块链添加区块
-func (bc *BlockChain) AddBlock(block *Block) {
+func (bc *BlockChain) AddBlock(block *Block) error {
 	//newBlock := CreateBlock(bc.Blocks[len(bc.Blocks)-1].Hash, txs)
 	//bc.Blocks = append(bc.Blocks, newBlock)
+	err := CheckBlockSanity(block)
+	if err != nil {
+		return err
+	}
 
-	var lastHash []byte
-	// 1. 验证内存中的lastHash是否等于区块链中的lh
-	err := bc.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(constcoe.LHKey))
-		if err != nil {
-			return err
-		}
-
-		err = item.Value(func(val []byte) error {
-			if !bytes.Equal(val, bc.LastHash) {
-				return errors.New("block hash does not match")
-			}
-			lastHash = val
-			return nil
-		})
-		if err != nil {
+	// 区块数据、最新区块、高度索引和UTXO集合在同一个事务中更新，中途失败或者进程退出时数据库不会有任何修改
+	var height int
+	err = bc.Database.Update(func(txn storage.Tx) error {
+		if err := connectTip(txn, bc.LastHash, block); err != nil {
 			return err
 		}
-		return nil
+		var err error
+		height, err = getTipHeight(txn)
+		return err
 	})
-
-	// 2. 判断传入的区块是否是上一个区块的hash
-	if !bytes.Equal(lastHash, block.PrevHash) {
-		fmt.Println("block hash does not match")
-		runtime.Goexit()
+	if err != nil {
+		return err
 	}
+	bc.LastHash = block.Hash
+	publish(Event{Type: BlockConnected, Block: block, Height: height})
 
-	// 3. 存储区块
-	serialized := block.Serialize()
-	err = bc.Database.Update(func(txn *badger.Txn) error {
-		curHash := block.Hash
-		err := txn.Set(curHash, serialized)
-		if err != nil {
-			return err
+	// 裁剪模式下删除旧的区块体，区块已经加入，裁剪失败只输出错误，下次加入区块时继续
+	if keep := config.Active().Prune; keep > 0 {
+		if _, err = bc.Prune(keep); err != nil && bc.Log != nil {
+			fmt.Fprintf(bc.Log, "Prune block bodies: %v\n", err)
 		}
-		err = txn.Set([]byte(constcoe.LHKey), curHash)
-		if err != nil {
-			return err
-		}
-		bc.LastHash = curHash
-		return nil
-	})
-	utils.Handle(err)
+	}
+	return nil
 }
 
 type Iterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Database    storage.ChainStore
 }
 
 func (bc *BlockChain) Iterator() *Iterator {
 	return &Iterator{bc.LastHash, bc.Database}
 }
 
-func (bcI *Iterator) Next() *Block {
-	lastHash := bcI.CurrentHash
+func (bcI *Iterator) Next() (*Block, error) {
 	var block *Block
-	err := bcI.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(lastHash)
-		if err != nil {
-			return err
-		}
-		err = item.Value(func(val []byte) error {
-			block = DeSerializeBlock(val)
-			return nil
-		})
+	err := bcI.Database.View(func(txn storage.Tx) error {
+		var err error
+		block, err = getBlock(txn, bcI.CurrentHash)
 		return err
 	})
-	utils.Handle(err)
+	if err != nil {
+		return nil, err
+	}
 	bcI.CurrentHash = block.PrevHash
-	return block
+	return block, nil
 }
 
-func (bc *BlockChain) BackOgPrevHash() []byte {
+func (bc *BlockChain) BackOgPrevHash() ([]byte, error) {
 	var ogPrevHash []byte
-	err := bc.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(constcoe.OgPrevHashKey))
-		if err != nil {
-			return err
-		}
-		err = item.Value(func(val []byte) error {
-			ogPrevHash = val
-			return nil
-		})
+	err := bc.Database.View(func(txn storage.Tx) error {
+		var err error
+		ogPrevHash, err = txn.Get([]byte(constcoe.OgPrevHashKey))
 		return err
 	})
-	utils.Handle(err)
-	return ogPrevHash
+	if err != nil {
+		return nil, fmt.Errorf("read genesis prev hash: %w", err)
+	}
+	return ogPrevHash, nil
+}
+
+// Height 当前区块链的高度，创世区块的高度为0
+func (bc *BlockChain) Height() (int, error) {
+	var height int
+	err := bc.Database.View(func(txn storage.Tx) error {
+		var err error
+		height, err = getTipHeight(txn)
+		return err
+	})
+	return height, err
+}
+
+// HashAtHeight 当前链中指定高度的区块哈希
+func (bc *BlockChain) HashAtHeight(height int) ([]byte, error) {
+	var hash []byte
+	err := bc.Database.View(func(txn storage.Tx) error {
+		var err error
+		hash, err = getHashAtHeight(txn, height)
+		return err
+	})
+	return hash, err
 }
 
 // FindUnspentTransactions 根据帐号找出未使用的交易
-func (bc *BlockChain) FindUnspentTransactions(address []byte) []*transaction.Transaction {
+func (bc *BlockChain) FindUnspentTransactions(address []byte) ([]*transaction.Transaction, error) {
 	unSpentTxs := make([]*transaction.Transaction, 0)
 	spentIds := make(map[string][]int) // 用于保存已经使用的交易输出 string是交易ID， []int交易下标
 
 	iter := bc.Iterator()
-	ogPrevHash := bc.BackOgPrevHash()
+	ogPrevHash, err := bc.BackOgPrevHash()
+	if err != nil {
+		return nil, err
+	}
 
 	// 1 遍历整个区块链,从最后一个区块开始
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 
@@ -234,63 +302,50 @@ func (bc *BlockChain) FindUnspentTransactions(address []byte) []*transaction.Tra
 		}
 	}
 
-	return unSpentTxs
+	return unSpentTxs, nil
 }
 
-// FindUTXOs 根据公钥查询UTXO
-func (bc *BlockChain) FindUTXOs(address []byte) (int, map[string]int) {
-	unspentOuts := make(map[string]int)
-	unspentTxs := bc.FindUnspentTransactions(address)
-	accumulated := 0
-
-Work:
-	for _, tx := range unspentTxs {
-		txID := hex.EncodeToString(tx.ID)
-		for outIdx, out := range tx.Outputs {
-			if out.ToAddressRight(address) {
-				accumulated += out.Value
-				unspentOuts[txID] = outIdx
-				continue Work // one transaction can only have one output referred to address
-			}
-		}
-	}
-	return accumulated, unspentOuts
+// FindUTXOs 根据公钥查询UTXO，返回的map中一个交易只记录一个输出下标
+func (bc *BlockChain) FindUTXOs(address []byte) (int, map[string]int, error) {
+	return bc.FindSpendableOutputs(address, math.MaxInt)
 }
 
-func (bc *BlockChain) FindSpendableOutputs(address []byte, amount int) (int, map[string]int) {
+// FindSpendableOutputs 找出金额足够amount的UTXO，返回的map中一个交易只记录一个输出下标
+func (bc *BlockChain) FindSpendableOutputs(address []byte, amount int) (int, map[string]int, error) {
 	unspentOuts := make(map[string]int)
-	unspentTxs := bc.FindUnspentTransactions(address)
+	utxos, err := bc.FindUTXOList(address)
+	if err != nil {
+		return 0, nil, err
+	}
 	accumulated := 0
-
-Work:
-	for _, tx := range unspentTxs {
-		txID := hex.EncodeToString(tx.ID)
-		for outIdx, out := range tx.Outputs {
-			if out.ToAddressRight(address) && accumulated < amount {
-				accumulated += out.Value
-				unspentOuts[txID] = outIdx
-				if accumulated >= amount {
-					break Work
-				}
-				continue Work // one transaction can only have one output referred to address
-			}
+	for _, utxo := range utxos {
+		if accumulated >= amount {
+			break
 		}
+		accumulated += utxo.Value()
+		unspentOuts[hex.EncodeToString(utxo.TxID)] = utxo.OutIdx
 	}
-	return accumulated, unspentOuts
+	return accumulated, unspentOuts, nil
 }
 
 // FindUnspentTransactions2 根据帐号找出未使用的交易
-func (bc *BlockChain) FindUnspentTransactions2(address []byte, amount int) (int, []*transaction.Transaction) {
+func (bc *BlockChain) FindUnspentTransactions2(address []byte, amount int) (int, []*transaction.Transaction, error) {
 	unSpentTxs := make([]*transaction.Transaction, 0)
 	spentIds := make(map[string][]int) // 用于保存已经使用的交易输出 string是交易ID， []int交易下标
 	value := 0
 
 	iter := bc.Iterator()
-	ogPreHash := bc.BackOgPrevHash()
+	ogPreHash, err := bc.BackOgPrevHash()
+	if err != nil {
+		return 0, nil, err
+	}
 
 all:
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return 0, nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
@@ -332,69 +387,197 @@ all:
 		}
 	}
 
-	return value, unSpentTxs
+	return value, unSpentTxs, nil
+}
+
+// CoinControl 控制交易使用哪些交易输出，以及交易的锁定时间
+type CoinControl struct {
+	Selector CoinSelector // 选币策略，为空时使用默认策略
+	UTXOs    []OutPoint   // 手动指定的交易输出，指定后不再使用选币策略
+	LockTime uint32       // 交易的锁定时间，为0时交易可以立即打包
+}
+
+// Payment 交易的一个收款方
+type Payment struct {
+	PubKeyHash []byte
+	Amount     int
 }
 
 // CreateTransaction 创建交易
 func (bc *BlockChain) CreateTransaction(fromPubKey, toPubKeyHash []byte, amount int, priKey ecdsa.PrivateKey) (*transaction.Transaction, error) {
+	return bc.CreateTransactionWithControl(fromPubKey, toPubKeyHash, amount, priKey, nil)
+}
+
+// CreateTransactionWithControl 使用指定的选币方式创建交易
+func (bc *BlockChain) CreateTransactionWithControl(fromPubKey, toPubKeyHash []byte, amount int, priKey ecdsa.PrivateKey, control *CoinControl) (*transaction.Transaction, error) {
+	tx, err := bc.BuildTransaction(fromPubKey, []Payment{{PubKeyHash: toPubKeyHash, Amount: amount}}, 0, control)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Sign(priKey)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// BuildTransaction 创建一笔支付给多个收款方的交易，剩余的金额扣除手续费后找零给from，返回的交易还没有签名
+func (bc *BlockChain) BuildTransaction(fromPubKey []byte, payments []Payment, fee int, control *CoinControl) (*transaction.Transaction, error) {
+	if len(payments) == 0 {
+		return nil, errors.New("no payment in transaction")
+	}
+	if fee < 0 {
+		return nil, errors.New("fee can not be negative")
+	}
 	input := make([]transaction.TxInput, 0)
-	output := make([]transaction.TxOutput, 0)
+	output := make([]transaction.TxOutput, 0, len(payments)+1)
 
-	// 获取余额
-	value, validOutputs := bc.FindSpendableOutputs(fromPubKey, amount)
-	if value < amount {
-		return nil, errors.New("not enough funds")
+	total := 0
+	for _, payment := range payments {
+		if payment.Amount <= 0 {
+			return nil, errors.New("payment amount must be positive")
+		}
+		total += payment.Amount
+		output = append(output, transaction.TxOutput{
+			Value:      payment.Amount,
+			PubKeyHash: payment.PubKeyHash,
+		})
+	}
+
+	// 选出需要使用的交易输出
+	selected, value, err := bc.SelectCoins(fromPubKey, total+fee, control)
+	if err != nil {
+		return nil, err
 	}
 
 	// 足够的金额给to, 剩余的给from
-	for idx, outIdx := range validOutputs {
-		idxByte, err := hex.DecodeString(idx)
-		utils.Handle(err)
+	for _, utxo := range selected {
 		input = append(input, transaction.TxInput{
-			TxID:   idxByte,
-			OutIdx: outIdx,
-			PubKey: fromPubKey,
-			Sig:    nil, // 等待下面数字签名
+			TxID:     utxo.TxID,
+			OutIdx:   utxo.OutIdx,
+			PubKey:   fromPubKey,
+			Sig:      nil, // 等待数字签名
+			Sequence: transaction.SequenceFinal,
 		})
 	}
 
-	output = append(output, transaction.TxOutput{
-		Value:      amount,
-		PubKeyHash: toPubKeyHash,
-	})
-	if value > amount {
+	if value > total+fee {
 		output = append(output, transaction.TxOutput{
-			Value:      value - amount,
+			Value:      value - total - fee,
 			PubKeyHash: utils.PublicKeyHash(fromPubKey),
 		})
 	}
 
 	tx := transaction.Transaction{
+		Version: transaction.TxVersion,
 		Inputs:  input,
 		Outputs: output,
 	}
+	if control != nil && control.LockTime != 0 {
+		tx.SetLockTime(control.LockTime)
+		return &tx, nil
+	}
 	tx.SetId()
-	tx.Sign(priKey)
 	return &tx, nil
 }
 
+// SelectCoins 根据选币方式选出足够支付amount的交易输出
+// 被锁定的输出、未成熟的挖矿奖励和交易池中的交易已经使用的输出不会被使用
+func (bc *BlockChain) SelectCoins(pubKey []byte, amount int, control *CoinControl) ([]UTXO, int, error) {
+	if control == nil {
+		control = &CoinControl{}
+	}
+	locked, err := LoadLockedOutputs()
+	if err != nil {
+		return nil, 0, err
+	}
+	pool, err := CreatePool()
+	if err != nil {
+		return nil, 0, err
+	}
+	inPool := pool.spentOutPoints()
+
+	available := make([]UTXO, 0)
+	byOutPoint := make(map[string]UTXO)
+	utxos, err := bc.FindUTXOList(pubKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	// 交易最早打包到下一个区块中
+	height, err := bc.Height()
+	if err != nil {
+		return nil, 0, err
+	}
+	spendHeight := height + 1
+	for _, utxo := range utxos {
+		byOutPoint[utxo.String()] = utxo
+		if !locked.IsLocked(utxo.OutPoint) && !inPool[utxo.String()] && utxo.Mature(spendHeight) {
+			available = append(available, utxo)
+		}
+	}
+
+	// 手动指定交易输出
+	if len(control.UTXOs) > 0 {
+		selected := make([]UTXO, 0, len(control.UTXOs))
+		used := make(map[string]bool)
+		value := 0
+		for _, op := range control.UTXOs {
+			utxo, ok := byOutPoint[op.String()]
+			if !ok {
+				return nil, 0, fmt.Errorf("output %s is not an unspent output of this wallet", op)
+			}
+			if locked.IsLocked(op) {
+				return nil, 0, fmt.Errorf("output %s is locked", op)
+			}
+			if inPool[op.String()] {
+				return nil, 0, fmt.Errorf("output %s is spent by a transaction in the pool", op)
+			}
+			if !utxo.Mature(spendHeight) {
+				return nil, 0, fmt.Errorf("%w: output %s is mined at height %d", ErrImmatureCoinbase, op, utxo.Height)
+			}
+			if used[op.String()] {
+				return nil, 0, fmt.Errorf("output %s is specified more than once", op)
+			}
+			used[op.String()] = true
+			selected = append(selected, utxo)
+			value += utxo.Value()
+		}
+		if value < amount {
+			return nil, 0, fmt.Errorf("%w: selected %d, need %d", ErrInsufficientFunds, value, amount)
+		}
+		return selected, value, nil
+	}
+
+	selector := control.Selector
+	if selector == nil {
+		selector, err = GetCoinSelector("")
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return selector.Select(available, amount)
+}
+
 // PrintTx 打印交易信息
 func PrintTx(txs []*transaction.Transaction) {
 	for i, tx := range txs {
 		fmt.Printf("\tTransaction Index:%d\n", i)
 		fmt.Println("\tTransaction ID: ", hex.EncodeToString(tx.ID))
+		if tx.LockTime != 0 {
+			fmt.Println("\tLockTime: ", tx.LockTime)
+		}
 		fmt.Println("\tInput:")
 		for _, in := range tx.Inputs {
 			fmt.Printf("\t\tTxID:%s\n", hex.EncodeToString(in.TxID))
 			fmt.Printf("\t\tOutIdx:%d\n", in.OutIdx)
 			fmt.Printf("\t\tPubKey:%x\n", in.PubKey)
-			fmt.Printf("\t\tAddress:%s\n", string(utils.PubHash2Address(utils.PublicKeyHash(in.PubKey))))
+			fmt.Printf("\t\tAddress:%s\n", string(address.Encode(utils.PublicKeyHash(in.PubKey))))
 		}
 		fmt.Println("\tOutput:")
 		for _, out := range tx.Outputs {
 			fmt.Printf("\t\tPubKeyHash:%s\n", hex.EncodeToString(out.PubKeyHash))
 			fmt.Printf("\t\tValue:%d\n", out.Value)
-			fmt.Printf("\t\tAddress:%s\n", string(utils.PubHash2Address(out.PubKeyHash)))
+			fmt.Printf("\t\tAddress:%s\n", string(address.Encode(out.PubKeyHash)))
 		}
 	}
 	fmt.Println("===========================")
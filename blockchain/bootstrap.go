@@ -0,0 +1,215 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/wire"
+	"io"
+)
+
+// 区块链导出文件由多条记录组成，每条记录前面写入varint长度
+// 第一条记录是文件头：bootstrapMagic、版本号和网络名字，后面的记录依次是按高度排列的区块：高度和区块编码
+const (
+	bootstrapMagic   = "gbcboot"
+	bootstrapVersion = 1
+	// bootstrapOverhead 区块记录中除了区块编码以外的字节数上限
+	bootstrapOverhead = 64
+)
+
+// BootstrapWriter 写入区块链导出文件
+type BootstrapWriter struct {
+	w *bufio.Writer
+}
+
+// NewBootstrapWriter 写入文件头，文件只能导入到同一个网络
+func NewBootstrapWriter(w io.Writer) (*BootstrapWriter, error) {
+	bw := &BootstrapWriter{w: bufio.NewWriter(w)}
+	header := wire.NewWriter()
+	header.WriteBytes([]byte(bootstrapMagic))
+	header.WriteUvarint(bootstrapVersion)
+	header.WriteBytes([]byte(chaincfg.ActiveParams().Name))
+	return bw, writeRecord(bw.w, header.Bytes())
+}
+
+// WriteBlock 写入一个区块，区块要按高度顺序写入
+func (bw *BootstrapWriter) WriteBlock(height int, block *Block) error {
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+	w := wire.NewWriter()
+	w.WriteUvarint(uint64(height))
+	w.WriteBytes(data)
+	return writeRecord(bw.w, w.Bytes())
+}
+
+// writeRecord 写入varint长度和记录内容，区块链导出文件和UTXO快照文件都由这样的记录组成
+func writeRecord(w *bufio.Writer, data []byte) error {
+	_, err := w.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Flush 把缓存的数据写入文件
+func (bw *BootstrapWriter) Flush() error {
+	return bw.w.Flush()
+}
+
+// BootstrapReader 读取区块链导出文件
+type BootstrapReader struct {
+	r *bufio.Reader
+}
+
+// NewBootstrapReader 读取并检查文件头
+func NewBootstrapReader(r io.Reader) (*BootstrapReader, error) {
+	br := &BootstrapReader{r: bufio.NewReader(r)}
+	data, err := readRecord(br.r, bootstrapOverhead, "bootstrap file")
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode bootstrap file: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := wire.NewReader(data)
+	magic := header.ReadBytes()
+	if header.Err() == nil && string(magic) != bootstrapMagic {
+		return nil, errors.New("decode bootstrap file: not a bootstrap file")
+	}
+	header.ReadVersion(bootstrapVersion)
+	network := header.ReadBytes()
+	if err = header.Finish(); err != nil {
+		return nil, fmt.Errorf("decode bootstrap file: %w", err)
+	}
+	if name := chaincfg.ActiveParams().Name; string(network) != name {
+		return nil, fmt.Errorf("bootstrap file is exported from %s, not %s", network, name)
+	}
+	return br, nil
+}
+
+// Next 读取下一个区块和它的高度，文件结束时返回io.EOF
+func (br *BootstrapReader) Next() (int, *Block, error) {
+	data, err := readRecord(br.r, chaincfg.ActiveParams().MaxBlockSize+bootstrapOverhead, "bootstrap file")
+	if err != nil {
+		return 0, nil, err
+	}
+	r := wire.NewReader(data)
+	height := r.ReadUvarint()
+	blockData := r.ReadBytes()
+	if err = r.Finish(); err != nil {
+		return 0, nil, fmt.Errorf("decode bootstrap record: %w", err)
+	}
+	block, err := DeSerializeBlock(blockData)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(height), block, nil
+}
+
+// readRecord 读取一条记录，长度超过max时失败，不会按文件中的长度分配过大的内存
+// 在记录之间结束时返回io.EOF，记录不完整时返回io.ErrUnexpectedEOF，file是错误信息中的文件类型
+func readRecord(r *bufio.Reader, max int, file string) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("decode %s: %w", file, err)
+	}
+	if length > uint64(max) {
+		return nil, fmt.Errorf("decode %s: %w: record is %d bytes, at most %d", file, wire.ErrTooLarge, length, max)
+	}
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", file, io.ErrUnexpectedEOF)
+	}
+	return data, nil
+}
+
+// ExportChain 按高度把[from, to]之间的区块写入w，to小于0时导出到最新区块，返回导出的区块个数
+func (bc *BlockChain) ExportChain(w io.Writer, from, to int) (int, error) {
+	hashes, err := bc.chainHashes()
+	if err != nil {
+		return 0, err
+	}
+	if to < 0 || to >= len(hashes) {
+		to = len(hashes) - 1
+	}
+	if from < 0 || from > to {
+		return 0, fmt.Errorf("invalid height range [%d, %d], chain height is %d", from, to, len(hashes)-1)
+	}
+	bw, err := NewBootstrapWriter(w)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for height := from; height <= to; height++ {
+		block, err := bc.GetBlock(hashes[height])
+		if err != nil {
+			return count, err
+		}
+		if err = bw.WriteBlock(height, block); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, bw.Flush()
+}
+
+// chainHashes 按高度排列的区块哈希，下标就是区块高度
+func (bc *BlockChain) chainHashes() ([][]byte, error) {
+	ogPrevHash, err := bc.BackOgPrevHash()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([][]byte, 0)
+	iter := bc.HeaderIterator()
+	for {
+		hash, header, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+		if bytes.Equal(header.PrevHash, ogPrevHash) {
+			break
+		}
+	}
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return hashes, nil
+}
+
+// checkImportBlock 导入的区块不是自己挖出来的，加入区块链之前检查区块哈希、工作量证明和签名
+func checkImportBlock(block *Block) error {
+	if len(block.Hash) == 0 {
+		return fmt.Errorf("%w: block has no hash", ErrInvalidBlock)
+	}
+	return verifyBlock(block, block.Hash, VerifyLevelSignature, false)
+}
+
+// ImportBlock 通过AddBlock把区块加入区块链，已经在数据库中的区块直接跳过，返回区块是否新加入
+// 导入中断后重新导入同一个文件，前面已经导入的区块会被跳过
+func (bc *BlockChain) ImportBlock(block *Block) (bool, error) {
+	if _, err := bc.GetHeader(block.Hash); err == nil {
+		return false, nil
+	} else if !errors.Is(err, ErrBlockNotFound) {
+		return false, err
+	}
+	if bytes.Equal(block.PrevHash, []byte(chaincfg.ActiveParams().GenesisMessage)) {
+		return false, fmt.Errorf("%w: genesis block %x is not the genesis block of this chain", ErrTipMismatch, block.Hash)
+	}
+	if err := checkImportBlock(block); err != nil {
+		return false, err
+	}
+	if err := bc.AddBlock(block); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,368 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wire"
+)
+
+// 数据库中除了区块以外还保存UTXO集合和高度索引，加入区块时在同一个事务中更新
+// 查询余额和验证交易不用遍历区块体，区块体被裁剪之后也能继续使用
+
+func utxoKey(op OutPoint) []byte {
+	key := append([]byte(constcoe.UTXOPrefix), op.TxID...)
+	return binary.BigEndian.AppendUint32(key, uint32(op.OutIdx))
+}
+
+// parseUTXOKey 从key中解析交易输出的位置
+func parseUTXOKey(key []byte) (OutPoint, error) {
+	if len(key) < len(constcoe.UTXOPrefix)+4 {
+		return OutPoint{}, fmt.Errorf("decode utxo: bad key %x", key)
+	}
+	idx := len(key) - 4
+	return OutPoint{
+		TxID:   append([]byte(nil), key[len(constcoe.UTXOPrefix):idx]...),
+		OutIdx: int(binary.BigEndian.Uint32(key[idx:])),
+	}, nil
+}
+
+func heightKey(height int) []byte {
+	return binary.BigEndian.AppendUint64([]byte(constcoe.HeightPrefix), uint64(height))
+}
+
+// encodeUTXO 按金额、公钥哈希、区块高度、是否挖矿奖励的顺序写入，不包含交易输出的位置
+func encodeUTXO(w *wire.Writer, utxo UTXO) {
+	w.WriteVarint(int64(utxo.Output.Value))
+	w.WriteBytes(utxo.Output.PubKeyHash)
+	w.WriteUvarint(uint64(utxo.Height))
+	coinbase := uint64(0)
+	if utxo.Coinbase {
+		coinbase = 1
+	}
+	w.WriteUvarint(coinbase)
+}
+
+// decodeUTXO 从r中读取encodeUTXO写入的字段，错误通过r.Err返回
+func decodeUTXO(r *wire.Reader, op OutPoint) UTXO {
+	utxo := UTXO{OutPoint: op}
+	utxo.Output.Value = int(r.ReadVarint())
+	utxo.Output.PubKeyHash = r.ReadBytes()
+	utxo.Height = int(r.ReadUvarint())
+	utxo.Coinbase = r.ReadUvarint() != 0
+	return utxo
+}
+
+func putUTXO(txn storage.Tx, utxo UTXO) error {
+	w := wire.NewWriter()
+	encodeUTXO(w, utxo)
+	return txn.Set(utxoKey(utxo.OutPoint), w.Bytes())
+}
+
+func deserializeUTXO(key, data []byte) (UTXO, error) {
+	op, err := parseUTXOKey(key)
+	if err != nil {
+		return UTXO{}, err
+	}
+	r := wire.NewReader(data)
+	utxo := decodeUTXO(r, op)
+	if err = r.Finish(); err != nil {
+		return UTXO{}, fmt.Errorf("decode utxo %s: %w", op, err)
+	}
+	return utxo, nil
+}
+
+// forEachUTXO 按key的顺序遍历数据库中的UTXO集合
+func forEachUTXO(txn storage.Tx, fn func(key []byte, utxo UTXO) error) error {
+	return txn.ForEach([]byte(constcoe.UTXOPrefix), func(key, data []byte) error {
+		utxo, err := deserializeUTXO(key, data)
+		if err != nil {
+			return err
+		}
+		return fn(key, utxo)
+	})
+}
+
+// readInt 读取保存为uvarint的整数，key不存在时返回def
+func readInt(txn storage.Tx, key string, def int) (int, error) {
+	val, err := txn.Get([]byte(key))
+	if errors.Is(err, storage.ErrNotFound) {
+		return def, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	value, n := binary.Uvarint(val)
+	if n <= 0 {
+		return 0, fmt.Errorf("decode %s: bad value %x", key, val)
+	}
+	return int(value), nil
+}
+
+func setInt(txn storage.Tx, key string, value int) error {
+	return txn.Set([]byte(key), binary.AppendUvarint(nil, uint64(value)))
+}
+
+func getTipHeight(txn storage.Tx) (int, error) {
+	height, err := readInt(txn, constcoe.TipHeightKey, -1)
+	if err == nil && height < 0 {
+		err = fmt.Errorf("%w: tip height is missing", ErrUnsupportedSchema)
+	}
+	return height, err
+}
+
+// getPruneHeight 区块体没有被裁剪的最低高度，没有裁剪过时为0
+func getPruneHeight(txn storage.Tx) (int, error) {
+	return readInt(txn, constcoe.PruneHeightKey, 0)
+}
+
+// getHashAtHeight 根据高度索引读取区块哈希
+func getHashAtHeight(txn storage.Tx, height int) ([]byte, error) {
+	hash, err := txn.Get(heightKey(height))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: no block at height %d", ErrBlockNotFound, height)
+	}
+	return hash, err
+}
+
+// connectTip 把区块连接到最新区块tip之后，连接区块需要的所有写入都在txn中完成
+// 之后增加的索引也要在这里更新，这样提交的事务要么包含整个区块，要么没有任何修改
+func connectTip(txn storage.Tx, tip []byte, block *Block) error {
+	// 1. 验证内存中的最新区块是否等于数据库中的最新区块
+	lastHash, err := txn.Get([]byte(constcoe.LHKey))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(lastHash, tip) {
+		return fmt.Errorf("%w: database tip %x, memory tip %x", ErrTipMismatch, lastHash, tip)
+	}
+
+	// 2. 判断传入的区块是否是上一个区块的hash
+	if !bytes.Equal(lastHash, block.PrevHash) {
+		return fmt.Errorf("%w: block prev hash %x, tip %x", ErrTipMismatch, block.PrevHash, lastHash)
+	}
+
+	// 3. 验证工作量证明和区块时间戳，被标记为无效的区块不能再加入
+	if err = checkPoW(txn, block); err != nil {
+		return err
+	}
+	if err = checkBlockTime(txn, block); err != nil {
+		return err
+	}
+	if err = checkNotInvalid(txn, block.Hash); err != nil {
+		return err
+	}
+
+	// 4. 检查交易并更新UTXO集合和高度索引
+	height, err := getTipHeight(txn)
+	if err != nil {
+		return err
+	}
+	if err = connectBlock(txn, block, height+1); err != nil {
+		return err
+	}
+
+	// 5. 存储区块，最后移动最新区块
+	if err = putBlock(txn, block); err != nil {
+		return err
+	}
+	return txn.Set([]byte(constcoe.LHKey), block.Hash)
+}
+
+// connectBlock 用区块更新UTXO集合、高度索引和地址索引，交易的检查见connectTransactions
+// 花费的UTXO按花费的顺序写入区块的撤销数据，断开区块时用来恢复
+func connectBlock(txn storage.Tx, block *Block, height int) error {
+	median, err := medianTimePast(txn, block.PrevHash)
+	if err != nil {
+		return err
+	}
+	spent, err := connectTransactions(dbView{txn}, block, height, median)
+	if err != nil {
+		return err
+	}
+	if err = putUndo(txn, block.Hash, spent); err != nil {
+		return err
+	}
+	if err = txn.Set(heightKey(height), block.Hash); err != nil {
+		return err
+	}
+	return setInt(txn, constcoe.TipHeightKey, height)
+}
+
+// utxoView 连接区块时使用的UTXO集合，数据库中的UTXO集合和验证区块链时在内存中重放的UTXO集合各实现一个
+type utxoView interface {
+	// spend 删除并返回op处的UTXO，不存在时ok为false
+	spend(op OutPoint) (utxo UTXO, ok bool, err error)
+	// add 加入交易的所有输出
+	add(tx *transaction.Transaction, height int) error
+}
+
+// connectTransactions 按顺序检查并连接区块中的交易，返回花费的UTXO，所有来源的区块都要通过这些检查
+// 0. 交易ID和签名有效，迁移时记录的旧区块中的旧版本交易无法验证，旧区块在connectTip中已经检查过
+// 1. 交易要已经过了锁定时间，median是上一个区块的MedianTimePast
+// 2. 交易输入必须是未花费的输出，属于交易输入的公钥，花费的挖矿奖励要已经成熟，同一个区块中后面的交易可以使用前面交易的输出
+// 3. 输入金额不能小于输出金额，差额为手续费
+// 4. 挖矿奖励不能超过区块补贴加上手续费，创世区块的金额是链的初始资金，不受出块奖励限制
+func connectTransactions(view utxoView, block *Block, height int, median int64) ([]UTXO, error) {
+	spent := make([]UTXO, 0)
+	fees, reward := 0, 0
+	for _, tx := range block.Transactions {
+		if tx.Version != transaction.LegacyVersion || block.Version != LegacyHeaderVersion {
+			if err := checkTransactionSignature(tx); err != nil {
+				return nil, err
+			}
+		}
+		if tx.IsBase() {
+			for _, out := range tx.Outputs {
+				reward += out.Value
+			}
+			if err := view.add(tx, height); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !tx.IsFinal(height, median) {
+			return nil, fmt.Errorf("%w: transaction %x lock time %d, block height %d, median time %d", ErrNonFinalTransaction, tx.ID, tx.LockTime, height, median)
+		}
+		inAmount, outAmount := 0, 0
+		for _, in := range tx.Inputs {
+			op := OutPoint{TxID: in.TxID, OutIdx: in.OutIdx}
+			utxo, ok, err := view.spend(op)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("%w: transaction %x spends missing or spent output %s", ErrInvalidTransaction, tx.ID, op)
+			}
+			if !bytes.Equal(utxo.Output.PubKeyHash, utils.PublicKeyHash(in.PubKey)) {
+				return nil, fmt.Errorf("%w: transaction %x spends output %s of another address", ErrInvalidTransaction, tx.ID, op)
+			}
+			if !utxo.Mature(height) {
+				return nil, fmt.Errorf("%w: transaction %x spends %s mined at height %d in block at height %d", ErrImmatureCoinbase, tx.ID, op, utxo.Height, height)
+			}
+			inAmount += utxo.Value()
+			spent = append(spent, utxo)
+		}
+		for _, out := range tx.Outputs {
+			outAmount += out.Value
+		}
+		if inAmount < outAmount {
+			return nil, fmt.Errorf("%w: transaction %x inAmount %d < outAmount %d", ErrInvalidTransaction, tx.ID, inAmount, outAmount)
+		}
+		fees += inAmount - outAmount
+		if err := view.add(tx, height); err != nil {
+			return nil, err
+		}
+	}
+	subsidy := chaincfg.ActiveParams().Subsidy(height)
+	if height > 0 && reward > subsidy+fees {
+		return nil, fmt.Errorf("%w: block %x reward %d is more than subsidy %d plus fees %d", ErrInvalidBlock, block.Hash, reward, subsidy, fees)
+	}
+	return spent, nil
+}
+
+// dbView 数据库中的UTXO集合，交易输出同时写入地址索引
+type dbView struct {
+	txn storage.Tx
+}
+
+func (v dbView) spend(op OutPoint) (UTXO, bool, error) {
+	utxo, ok, err := getUTXO(v.txn, op)
+	if err != nil || !ok {
+		return utxo, ok, err
+	}
+	return utxo, true, v.txn.Delete(utxoKey(op))
+}
+
+// getUTXO 读取op处的UTXO，不存在时ok为false
+func getUTXO(txn storage.Tx, op OutPoint) (UTXO, bool, error) {
+	data, err := txn.Get(utxoKey(op))
+	if errors.Is(err, storage.ErrNotFound) {
+		return UTXO{}, false, nil
+	}
+	if err != nil {
+		return UTXO{}, false, err
+	}
+	utxo, err := deserializeUTXO(utxoKey(op), data)
+	if err != nil {
+		return UTXO{}, false, err
+	}
+	return utxo, true, nil
+}
+
+func (v dbView) add(tx *transaction.Transaction, height int) error {
+	if err := putOutputs(v.txn, tx, height); err != nil {
+		return err
+	}
+	return putAddressOutputs(v.txn, tx, height)
+}
+
+func putOutputs(txn storage.Tx, tx *transaction.Transaction, height int) error {
+	for idx, out := range tx.Outputs {
+		utxo := UTXO{OutPoint: OutPoint{TxID: tx.ID, OutIdx: idx}, Output: out, Height: height, Coinbase: tx.IsBase()}
+		if err := putUTXO(txn, utxo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildChainState 清空UTXO集合、高度索引、撤销数据和地址索引，按高度重新连接tip之前的所有区块
+// 需要全部区块体，每个区块使用一个事务，中断后重新执行会从头开始
+func rebuildChainState(db storage.ChainStore, tip []byte) error {
+	chain := &BlockChain{LastHash: tip, Database: db}
+	pruneHeight := 0
+	err := db.View(func(txn storage.Tx) error {
+		var err error
+		pruneHeight, err = getPruneHeight(txn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if pruneHeight > 0 {
+		return fmt.Errorf("%w: rebuilding the utxo set needs the blocks below height %d", ErrBlockPruned, pruneHeight)
+	}
+	hashes, err := chain.chainHashes()
+	if err != nil {
+		return err
+	}
+	if err = db.DropPrefix([]byte(constcoe.UTXOPrefix), []byte(constcoe.HeightPrefix), []byte(constcoe.UndoPrefix), []byte(constcoe.AddrIndexPrefix)); err != nil {
+		return err
+	}
+	for height, hash := range hashes {
+		err = db.Update(func(txn storage.Tx) error {
+			block, err := getBlock(txn, hash)
+			if err != nil {
+				return err
+			}
+			return connectBlock(txn, block, height)
+		})
+		if err != nil {
+			return fmt.Errorf("rebuild utxo set at height %d: %w", height, err)
+		}
+	}
+	return nil
+}
+
+// Reindex 由区块体重建UTXO集合和高度索引，裁剪过的区块链无法重建
+func (bc *BlockChain) Reindex() error {
+	return reindexChainState(bc.Database, bc.LastHash)
+}
+
+// PruneHeight 区块体没有被裁剪的最低高度，没有裁剪过时为0
+func (bc *BlockChain) PruneHeight() (int, error) {
+	var height int
+	err := bc.Database.View(func(txn storage.Tx) error {
+		var err error
+		height, err = getPruneHeight(txn)
+		return err
+	})
+	return height, err
+}
@@ -0,0 +1,149 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+const (
+	CoinSelectLargestFirst  = "largest"
+	CoinSelectSmallestFirst = "smallest"
+	CoinSelectBranchBound   = "bnb"
+	CoinSelectRandom        = "random"
+
+	// bnbMaxTries 分支定界搜索的最大尝试次数，防止输出过多时搜索时间过长
+	bnbMaxTries = 100000
+)
+
+// CoinSelector 选币策略，从可用的交易输出中选出足够支付amount的一组输出
+type CoinSelector interface {
+	Select(utxos []UTXO, amount int) ([]UTXO, int, error)
+}
+
+// CoinSelectorFunc 允许直接使用函数作为选币策略
+type CoinSelectorFunc func(utxos []UTXO, amount int) ([]UTXO, int, error)
+
+func (f CoinSelectorFunc) Select(utxos []UTXO, amount int) ([]UTXO, int, error) {
+	return f(utxos, amount)
+}
+
+var coinSelectors = map[string]CoinSelector{
+	CoinSelectLargestFirst:  CoinSelectorFunc(SelectLargestFirst),
+	CoinSelectSmallestFirst: CoinSelectorFunc(SelectSmallestFirst),
+	CoinSelectBranchBound:   CoinSelectorFunc(SelectBranchAndBound),
+	CoinSelectRandom:        CoinSelectorFunc(SelectRandom),
+}
+
+// RegisterCoinSelector 注册自定义的选币策略
+func RegisterCoinSelector(name string, selector CoinSelector) {
+	coinSelectors[name] = selector
+}
+
+// GetCoinSelector 根据名字获取选币策略，名字为空时使用默认的largest策略
+func GetCoinSelector(name string) (CoinSelector, error) {
+	if name == "" {
+		name = CoinSelectLargestFirst
+	}
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy %q", name)
+	}
+	return selector, nil
+}
+
+// SelectLargestFirst 优先使用金额大的输出，使用的输入最少
+func SelectLargestFirst(utxos []UTXO, amount int) ([]UTXO, int, error) {
+	sorted := sortUTXOs(utxos, func(a, b UTXO) bool { return a.Value() > b.Value() })
+	return accumulate(sorted, amount)
+}
+
+// SelectSmallestFirst 优先使用金额小的输出，用于合并零散的小额输出
+func SelectSmallestFirst(utxos []UTXO, amount int) ([]UTXO, int, error) {
+	sorted := sortUTXOs(utxos, func(a, b UTXO) bool { return a.Value() < b.Value() })
+	return accumulate(sorted, amount)
+}
+
+// SelectRandom 随机顺序选择输出，避免暴露钱包中输出的排列规律
+func SelectRandom(utxos []UTXO, amount int) ([]UTXO, int, error) {
+	shuffled := make([]UTXO, len(utxos))
+	copy(shuffled, utxos)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return accumulate(shuffled, amount)
+}
+
+// SelectBranchAndBound 分支定界搜索一组金额刚好等于amount的输出，这样交易不需要找零输出
+// 找不到精确匹配时退回到largest策略
+func SelectBranchAndBound(utxos []UTXO, amount int) ([]UTXO, int, error) {
+	if amount <= 0 {
+		return nil, 0, errors.New("amount must be positive")
+	}
+	sorted := sortUTXOs(utxos, func(a, b UTXO) bool { return a.Value() > b.Value() })
+
+	// remaining[i] 表示从第i个开始剩余输出的总金额，用于剪枝
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value()
+	}
+
+	tries := 0
+	chosen := make([]bool, len(sorted))
+	var search func(idx, sum int) bool
+	search = func(idx, sum int) bool {
+		tries++
+		if sum == amount {
+			return true
+		}
+		if sum > amount || idx >= len(sorted) || sum+remaining[idx] < amount || tries > bnbMaxTries {
+			return false
+		}
+		// 先尝试包含当前输出，再尝试不包含
+		chosen[idx] = true
+		if search(idx+1, sum+sorted[idx].Value()) {
+			return true
+		}
+		chosen[idx] = false
+		return search(idx+1, sum)
+	}
+
+	if search(0, 0) {
+		selected := make([]UTXO, 0)
+		for i, ok := range chosen {
+			if ok {
+				selected = append(selected, sorted[i])
+			}
+		}
+		return selected, amount, nil
+	}
+	return accumulate(sorted, amount)
+}
+
+// sortUTXOs 返回排序后的副本，不修改传入的切片
+func sortUTXOs(utxos []UTXO, less func(a, b UTXO) bool) []UTXO {
+	sorted := make([]UTXO, len(utxos))
+	copy(sorted, utxos)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// accumulate 按顺序累加输出直到金额足够
+func accumulate(utxos []UTXO, amount int) ([]UTXO, int, error) {
+	if amount <= 0 {
+		return nil, 0, errors.New("amount must be positive")
+	}
+	selected := make([]UTXO, 0)
+	value := 0
+	for _, u := range utxos {
+		selected = append(selected, u)
+		value += u.Value()
+		if value >= amount {
+			return selected, value, nil
+		}
+	}
+	return nil, value, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, value, amount)
+}
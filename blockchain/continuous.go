@@ -0,0 +1,220 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/storage"
+	"time"
+)
+
+const (
+	defaultPollInterval = time.Second
+	openChainRetries    = 10
+)
+
+// ContinuousMiner 持续挖矿：用交易池创建区块模板，挖矿，提交区块，然后马上开始下一个区块
+// 区块链只在创建模板和提交区块时打开，挖矿期间其他进程仍然可以发送交易
+// 交易池有变化或者同一进程中连接了其他区块时重新创建模板，提交时发现链上已经有了新区块也会重新开始
+type ContinuousMiner struct {
+	Miner            *Miner
+	RewardPubKeyHash []byte
+	Interval         time.Duration                        // 两个区块之间的最小间隔，为0时不等待
+	Blocks           int                                  // 挖出多少个区块后停止，小于等于0时一直挖矿
+	PollInterval     time.Duration                        // 检查交易池的间隔，为0时为1秒
+	OpenChain        func() (*BlockChain, error)          // 打开区块链，为空时使用ContinueBlockChain
+	OnBlock          func(block *Block, stats *MineStats) // 每挖出一个区块调用一次
+	OnRestart        func(reason string)                  // 每次重新开始挖矿时调用
+}
+
+// Run 持续挖矿直到挖够Blocks个区块或者ctx被取消，ctx被取消不算错误，返回挖出的区块数
+func (cm *ContinuousMiner) Run(ctx context.Context) (int, error) {
+	mined := 0
+	var lastBlock time.Time
+	for cm.Blocks <= 0 || mined < cm.Blocks {
+		if cm.Interval > 0 && !lastBlock.IsZero() {
+			if !sleepContext(ctx, cm.Interval-time.Since(lastBlock)) {
+				return mined, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return mined, nil
+		}
+
+		// 先记录交易池的状态并订阅区块事件再创建模板，之后的变化都能被发现
+		state, err := StatPool()
+		if err != nil {
+			return mined, err
+		}
+		blocks := Subscribe(16, BlockConnected)
+		block, err := cm.template(ctx)
+		if errors.Is(err, ErrEmptyPool) {
+			blocks.Unsubscribe()
+			cm.waitChange(ctx, state, nil, nil)
+			continue
+		}
+		if err != nil {
+			blocks.Unsubscribe()
+			return mined, err
+		}
+
+		roundCtx, cancel := context.WithCancel(ctx)
+		changed := make(chan string, 1)
+		go func() {
+			changed <- cm.waitChange(roundCtx, state, blocks, block.PrevHash)
+			cancel()
+		}()
+		stats, err := cm.Miner.Mine(roundCtx, block)
+		cancel()
+		reason := <-changed
+		blocks.Unsubscribe()
+		if errors.Is(err, ErrMiningCanceled) {
+			if ctx.Err() != nil {
+				return mined, nil
+			}
+			cm.restart(reason)
+			continue
+		}
+		if err != nil {
+			return mined, err
+		}
+
+		err = cm.submit(ctx, block)
+		if errors.Is(err, ErrTipMismatch) {
+			cm.restart("chain tip changed")
+			continue
+		}
+		if err != nil {
+			return mined, err
+		}
+		mined++
+		lastBlock = time.Now()
+		if cm.OnBlock != nil {
+			cm.OnBlock(block, stats)
+		}
+	}
+	return mined, nil
+}
+
+// template 打开区块链，用交易池中的有效交易创建区块模板，无效交易从交易池中删除
+func (cm *ContinuousMiner) template(ctx context.Context) (*Block, error) {
+	bc, err := cm.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = bc.Database.Close()
+	}()
+
+	pool, err := CreatePool()
+	if err != nil {
+		return nil, err
+	}
+	block, invalid, err := bc.BuildBlockTemplate(pool.Txs, cm.RewardPubKeyHash)
+	if len(invalid) != 0 {
+		if removeErr := RemoveTransactions(invalid); removeErr != nil {
+			return nil, removeErr
+		}
+	}
+	return block, err
+}
+
+// submit 打开区块链提交区块，链上的最新区块已经变化时返回ErrTipMismatch
+// 区块加入之后从交易池删除交易失败只输出到区块链的Log，下一个模板会丢弃这些已经花费的交易
+func (cm *ContinuousMiner) submit(ctx context.Context, block *Block) error {
+	if !block.ValidatePoW() {
+		return ErrInvalidPoW
+	}
+	bc, err := cm.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = bc.Database.Close()
+	}()
+
+	err = bc.AddBlock(block)
+	if err != nil {
+		return err
+	}
+	if err = RemoveTransactions(block.Transactions); err != nil && bc.Log != nil {
+		fmt.Fprintf(bc.Log, "Remove mined transactions from the pool: %v\n", err)
+	}
+	return nil
+}
+
+// open 打开区块链，其他进程正在使用数据库时等待一会再重试，其他错误直接返回
+func (cm *ContinuousMiner) open(ctx context.Context) (*BlockChain, error) {
+	openChain := cm.OpenChain
+	if openChain == nil {
+		openChain = ContinueBlockChain
+	}
+	var err error
+	for i := 0; i < openChainRetries; i++ {
+		var bc *BlockChain
+		bc, err = openChain()
+		if !errors.Is(err, storage.ErrLocked) {
+			return bc, err
+		}
+		if !sleepContext(ctx, cm.pollInterval()) {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// waitChange 等待交易池文件发生变化、连接了tip之后的区块或者ctx被取消，返回重新开始的原因
+// blocks为空时只等待交易池变化，ctx被取消时返回空字符串
+func (cm *ContinuousMiner) waitChange(ctx context.Context, state PoolState, blocks *Subscription, tip []byte) string {
+	ticker := time.NewTicker(cm.pollInterval())
+	defer ticker.Stop()
+	var connected <-chan Event
+	if blocks != nil {
+		connected = blocks.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ""
+		case e := <-connected:
+			// 订阅之后创建模板之前连接的区块已经是模板的上一个区块
+			if !bytes.Equal(e.Hash(), tip) {
+				return "chain tip changed"
+			}
+		case <-ticker.C:
+			current, err := StatPool()
+			if err != nil || current.Size != state.Size || !current.ModTime.Equal(state.ModTime) {
+				return "transaction pool changed"
+			}
+		}
+	}
+}
+
+func (cm *ContinuousMiner) pollInterval() time.Duration {
+	if cm.PollInterval <= 0 {
+		return defaultPollInterval
+	}
+	return cm.PollInterval
+}
+
+func (cm *ContinuousMiner) restart(reason string) {
+	if cm.OnRestart != nil {
+		cm.OnRestart(reason)
+	}
+}
+
+// sleepContext 等待d时间，ctx被取消时返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,30 @@
+package blockchain
+
+import "errors"
+
+var (
+	ErrChainExists         = errors.New("blockchain already exists")
+	ErrChainNotFound       = errors.New("blockchain does not exist, please create a new one")
+	ErrTipMismatch         = errors.New("block does not match the chain tip")
+	ErrBlockNotFound       = errors.New("block not found")
+	ErrInvalidPoW          = errors.New("block has invalid proof of work")
+	ErrMiningCanceled      = errors.New("mining canceled")
+	ErrInvalidTransaction  = errors.New("invalid transaction")
+	ErrInsufficientFunds   = errors.New("not enough funds")
+	ErrEmptyPool           = errors.New("transaction pool is empty")
+	ErrUnsupportedSchema   = errors.New("unsupported database schema")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrInvalidProof        = errors.New("invalid merkle proof")
+	ErrInvalidMerkleRoot   = errors.New("merkle root does not match the transactions")
+	ErrInvalidBlock        = errors.New("invalid block")
+	ErrInvalidTimestamp    = errors.New("invalid block timestamp")
+	ErrPoolFull            = errors.New("transaction pool is full")
+	ErrTransactionInPool   = errors.New("transaction already in pool")
+	ErrNonFinalTransaction = errors.New("transaction is locked by lock time")
+	ErrImmatureCoinbase    = errors.New("coinbase output is not mature")
+	ErrBlockPruned         = errors.New("block body has been pruned")
+	ErrCorruptChainState   = errors.New("chain state does not match the chain tip")
+	ErrNoUndoData          = errors.New("block has no undo data")
+	ErrNotInvalidated      = errors.New("block is not marked invalid")
+	ErrUnknownEvent        = errors.New("unknown event type")
+)
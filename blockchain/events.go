@@ -0,0 +1,149 @@
+package blockchain
+
+import (
+	"fmt"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 区块链和交易池的事件在修改写入数据库或交易池文件之后发布给进程内的订阅者
+// 发布时不等待订阅者，订阅者的缓冲区满了时丢弃事件并计数，慢的订阅者不会拖慢挖矿和加入区块
+
+// EventType 事件类型
+type EventType int
+
+const (
+	BlockConnected    EventType = iota + 1 // 区块接到最新区块之后
+	BlockDisconnected                      // 最新区块被断开
+	TxAccepted                             // 交易加入交易池
+	TxRemoved                              // 交易离开交易池，被打包进区块或者被丢弃
+)
+
+var eventNames = map[EventType]string{
+	BlockConnected:    "blockconnected",
+	BlockDisconnected: "blockdisconnected",
+	TxAccepted:        "txaccepted",
+	TxRemoved:         "txremoved",
+}
+
+// EventTypes 所有的事件类型
+func EventTypes() []EventType {
+	return []EventType{BlockConnected, BlockDisconnected, TxAccepted, TxRemoved}
+}
+
+func (t EventType) String() string {
+	if name, ok := eventNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("event(%d)", int(t))
+}
+
+// ParseEventType 根据名称返回事件类型，名称不区分大小写
+func ParseEventType(name string) (EventType, error) {
+	for t, n := range eventNames {
+		if strings.EqualFold(n, name) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownEvent, name)
+}
+
+// Event 区块事件中Block和Height有值，交易事件中Tx有值
+type Event struct {
+	Type   EventType
+	Block  *Block
+	Height int // 连接后或断开前区块的高度
+	Tx     *transaction.Transaction
+}
+
+// IsBlock 是否是区块事件
+func (e Event) IsBlock() bool {
+	return e.Type == BlockConnected || e.Type == BlockDisconnected
+}
+
+// Hash 区块哈希或者交易ID
+func (e Event) Hash() []byte {
+	if e.IsBlock() {
+		return e.Block.Hash
+	}
+	return e.Tx.ID
+}
+
+// Serialize 区块或交易的编码
+func (e Event) Serialize() ([]byte, error) {
+	if e.IsBlock() {
+		return e.Block.Serialize()
+	}
+	return e.Tx.Serialize(), nil
+}
+
+// Subscription 订阅的事件从C中读取，Unsubscribe之后C被关闭
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	types   map[EventType]bool
+	dropped atomic.Uint64
+}
+
+// Dropped 缓冲区满了被丢弃的事件个数
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe 取消订阅并关闭C，可以调用多次
+func (s *Subscription) Unsubscribe() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if _, ok := bus.subs[s]; ok {
+		delete(bus.subs, s)
+		close(s.c)
+	}
+}
+
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+var bus = &eventBus{subs: make(map[*Subscription]struct{})}
+
+// Subscribe 订阅事件，buffer是缓冲的事件个数，不传types时订阅所有事件
+func Subscribe(buffer int, types ...EventType) *Subscription {
+	if len(types) == 0 {
+		types = EventTypes()
+	}
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c, types: make(map[EventType]bool, len(types))}
+	for _, t := range types {
+		s.types[t] = true
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.subs[s] = struct{}{}
+	return s
+}
+
+func publish(e Event) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	for s := range bus.subs {
+		if !s.types[e.Type] {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// publishTxs 发布交易池的变化
+func publishTxs(t EventType, txs []*transaction.Transaction) {
+	for _, tx := range txs {
+		publish(Event{Type: t, Tx: tx})
+	}
+}
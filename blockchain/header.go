@@ -0,0 +1,126 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/merkletree"
+	"github.com/limitzhang87/goblockchain/wire"
+	"math/big"
+)
+
+const (
+	// LegacyHeaderVersion 从旧数据库迁移过来的区块，区块哈希按旧规则计算，保留原来的哈希
+	// 只有迁移时记录在数据库中的区块可以使用这个版本，其他来源的这个版本的区块都不可信
+	LegacyHeaderVersion = 0
+	// LegacyMerkleHeaderVersion 区块哈希只由区块头决定，merkle根按旧规则计算
+	LegacyMerkleHeaderVersion = 1
+	// HeaderVersion 当前区块头版本，merkle根按merkletree.Version的规则计算
+	HeaderVersion = 2
+
+	maxBits = 256
+)
+
+// BlockHeader 区块头，轻节点和同步只需要区块头
+type BlockHeader struct {
+	Version    uint32
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Bits       uint32 // 难度，区块哈希前面至少有Bits个0比特
+	Nonce      int64
+}
+
+// Encode 按版本、上一个区块哈希、merkle根、时间戳、难度、nonce的顺序写入区块头
+// nonce固定8个字节并且放在最后，挖矿时只需要修改最后8个字节
+func (h *BlockHeader) Encode(w *wire.Writer) {
+	h.encodeWithoutNonce(w)
+	w.WriteUint64(uint64(h.Nonce))
+}
+
+func (h *BlockHeader) encodeWithoutNonce(w *wire.Writer) {
+	w.WriteUvarint(uint64(h.Version))
+	w.WriteBytes(h.PrevHash)
+	w.WriteBytes(h.MerkleRoot)
+	w.WriteVarint(h.Timestamp)
+	w.WriteUvarint(uint64(h.Bits))
+}
+
+func (h *BlockHeader) Serialize() []byte {
+	w := wire.NewWriter()
+	h.Encode(w)
+	return w.Bytes()
+}
+
+// DecodeBlockHeader 从r中读取区块头，错误通过r.Err返回
+func DecodeBlockHeader(r *wire.Reader) *BlockHeader {
+	h := &BlockHeader{}
+	h.Version = uint32(r.ReadVersion(HeaderVersion))
+	h.PrevHash = r.ReadBytes()
+	h.MerkleRoot = r.ReadBytes()
+	h.Timestamp = r.ReadVarint()
+	h.Bits = uint32(r.ReadUvarint())
+	h.Nonce = int64(r.ReadUint64())
+	return h
+}
+
+func DeserializeBlockHeader(data []byte) (*BlockHeader, error) {
+	r := wire.NewReader(data)
+	h := DecodeBlockHeader(r)
+	if err := r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode block header: %w", err)
+	}
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// check 检查解码出来的区块头字段是否在有效范围内
+func (h *BlockHeader) check() error {
+	if h.Bits > maxBits {
+		return fmt.Errorf("decode block header: bits %d out of range", h.Bits)
+	}
+	return nil
+}
+
+// merkleVersion 区块头版本对应的merkle树规则
+func (h *BlockHeader) merkleVersion() int {
+	if h.Version < HeaderVersion {
+		return merkletree.LegacyVersion
+	}
+	return merkletree.Version
+}
+
+// BlockHash 区块哈希，区块头编码的sha256
+func (h *BlockHeader) BlockHash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
+
+// Target 哈希需要小于的目标值
+func (h *BlockHeader) Target() *big.Int {
+	target := big.NewInt(1)
+	return target.Lsh(target, uint(maxBits-min(h.Bits, maxBits)))
+}
+
+// ValidatePoW 验证区块头的工作量，难度要等于当前网络的难度
+// 旧区块的工作量证明包含交易ID等区块头以外的数据，无法由区块头验证，总是返回false
+func (h *BlockHeader) ValidatePoW() bool {
+	if h.Version == LegacyHeaderVersion || h.Bits != uint32(chaincfg.ActiveParams().Difficulty) {
+		return false
+	}
+	var intHash big.Int
+	intHash.SetBytes(h.BlockHash())
+	return intHash.Cmp(h.Target()) == -1
+}
+
+// bitsFromTarget 根据旧区块中保存的目标值计算难度
+func bitsFromTarget(target []byte) uint32 {
+	var intTarget big.Int
+	intTarget.SetBytes(target)
+	if intTarget.Sign() == 0 {
+		return maxBits
+	}
+	return uint32(maxBits + 1 - intTarget.BitLen())
+}
@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+)
+
+// 被标记为无效的区块不能再加入区块链，标记中保存标记时的最新区块，重新考虑时把断开的区块接回来
+
+func invalidKey(hash []byte) []byte {
+	return append([]byte(constcoe.InvalidPrefix), hash...)
+}
+
+// checkNotInvalid 区块被标记为无效时返回ErrInvalidBlock
+func checkNotInvalid(txn storage.Tx, hash []byte) error {
+	_, err := txn.Get(invalidKey(hash))
+	if err == nil {
+		return fmt.Errorf("%w: block %x is marked invalid", ErrInvalidBlock, hash)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// blockDepth 区块在当前链中距离最新区块的区块数，最新区块为0，不在当前链中时返回ErrBlockNotFound
+func (bc *BlockChain) blockDepth(hash []byte) (int, error) {
+	ogPrevHash, err := bc.BackOgPrevHash()
+	if err != nil {
+		return 0, err
+	}
+	iter := bc.HeaderIterator()
+	for depth := 0; ; depth++ {
+		current, header, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(current, hash) {
+			return depth, nil
+		}
+		if bytes.Equal(header.PrevHash, ogPrevHash) {
+			return 0, fmt.Errorf("%w: %x is not in the active chain", ErrBlockNotFound, hash)
+		}
+	}
+}
+
+// InvalidateBlock 把当前链中的区块标记为无效，断开这个区块和之后的所有区块，返回断开的区块个数
+// 先写入标记再逐个断开，中断后再次执行会继续断开
+func (bc *BlockChain) InvalidateBlock(hash []byte) (int, error) {
+	depth, err := bc.blockDepth(hash)
+	if err != nil {
+		return 0, err
+	}
+	err = bc.Database.Update(func(txn storage.Tx) error {
+		if err := checkNotInvalid(txn, hash); err != nil {
+			// 已经标记过，保留第一次标记时的最新区块
+			return nil
+		}
+		return txn.Set(invalidKey(hash), bc.LastHash)
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i <= depth; i++ {
+		if _, err = bc.DisconnectTip(); err != nil {
+			return i, err
+		}
+	}
+	return depth + 1, nil
+}
+
+// ReconsiderBlock 删除区块的无效标记，标记时断开的区块还能接到最新区块之后时重新连接，返回连接的区块个数
+// 之后又加入了其他区块时只删除标记，遇到其他被标记为无效的区块时停止
+func (bc *BlockChain) ReconsiderBlock(hash []byte) (int, error) {
+	var oldTip []byte
+	err := bc.Database.Update(func(txn storage.Tx) error {
+		var err error
+		oldTip, err = txn.Get(invalidKey(hash))
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("%w: %x", ErrNotInvalidated, hash)
+		}
+		if err != nil {
+			return err
+		}
+		return txn.Delete(invalidKey(hash))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// 从标记时的最新区块往前找到当前的最新区块
+	branch := make([][]byte, 0)
+	iter := &HeaderIterator{CurrentHash: oldTip, Database: bc.Database}
+	for !bytes.Equal(iter.CurrentHash, bc.LastHash) {
+		current, _, err := iter.Next()
+		if errors.Is(err, ErrBlockNotFound) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		branch = append(branch, current)
+	}
+
+	connected := 0
+	for i := len(branch) - 1; i >= 0; i-- {
+		var block *Block
+		err = bc.Database.View(func(txn storage.Tx) error {
+			if err := checkNotInvalid(txn, branch[i]); err != nil {
+				return err
+			}
+			var err error
+			block, err = getBlock(txn, branch[i])
+			return err
+		})
+		if errors.Is(err, ErrInvalidBlock) {
+			break
+		}
+		if err == nil {
+			err = bc.AddBlock(block)
+		}
+		if err != nil {
+			return connected, err
+		}
+		connected++
+		if err = RemoveTransactions(block.Transactions); err != nil {
+			return connected, fmt.Errorf("remove transactions from the pool: %w", err)
+		}
+	}
+	return connected, nil
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/clock"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"sort"
+)
+
+// MedianTimePast 从hash开始往前MedianTimeBlocks个区块时间戳的中位数，不足时使用全部区块
+// 下一个区块的时间戳要大于这个值，单个矿工修改时间戳不能让链上的时间倒退
+func (bc *BlockChain) MedianTimePast(hash []byte) (int64, error) {
+	var median int64
+	err := bc.Database.View(func(txn storage.Tx) error {
+		var err error
+		median, err = medianTimePast(txn, hash)
+		return err
+	})
+	return median, err
+}
+
+func medianTimePast(txn storage.Tx, hash []byte) (int64, error) {
+	ogPrevHash, err := txn.Get([]byte(constcoe.OgPrevHashKey))
+	if err != nil {
+		return 0, fmt.Errorf("read genesis prev hash: %w", err)
+	}
+
+	count := chaincfg.ActiveParams().MedianTimeBlocks
+	timestamps := make([]int64, 0, count)
+	for len(timestamps) < count && !bytes.Equal(hash, ogPrevHash) {
+		header, err := getHeader(txn, hash)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Timestamp)
+		hash = header.PrevHash
+	}
+	return medianTime(timestamps), nil
+}
+
+// medianTime 时间戳的中位数，个数为偶数时取较大的一个
+func medianTime(timestamps []int64) int64 {
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// checkBlockTime 区块时间戳要大于上一个区块的MedianTimePast，并且不能比当前时间晚MaxFutureBlockTime秒以上
+func checkBlockTime(txn storage.Tx, block *Block) error {
+	median, err := medianTimePast(txn, block.PrevHash)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp <= median {
+		return fmt.Errorf("%w: block %x time %d is not after median time past %d", ErrInvalidTimestamp, block.Hash, block.Timestamp, median)
+	}
+	maxTime := clock.Now().Unix() + chaincfg.ActiveParams().MaxFutureBlockTime
+	if block.Timestamp > maxTime {
+		return fmt.Errorf("%w: block %x time %d is too far in the future, at most %d", ErrInvalidTimestamp, block.Hash, block.Timestamp, maxTime)
+	}
+	return nil
+}
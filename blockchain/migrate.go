@@ -0,0 +1,246 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/merkletree"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/wire"
+)
+
+// SchemaVersion 数据库版本
+// 0: 区块使用gob编码，key为区块哈希
+// 1: 区块使用wire编码，key为区块哈希
+// 2: 区块头和区块体分开保存
+// 3: 保存UTXO集合和高度索引，区块体可以被裁剪
+// 4: 保存地址索引
+// 5: 记录迁移的旧区块，只有这些区块可以使用LegacyHeaderVersion
+const SchemaVersion = 5
+
+// stateRebuildVersion 重建链状态之前写入的版本，中断后再次打开时会重建UTXO集合、高度索引和地址索引
+const stateRebuildVersion = 2
+
+// legacyBlock 旧版本的区块，区块哈希由区块头以外的数据计算
+type legacyBlock struct {
+	Timestamp    int64
+	Hash         []byte
+	PrevHash     []byte
+	Target       []byte
+	Nonce        int64
+	ExtraNonce   int64
+	Transactions []*transaction.Transaction
+}
+
+// toBlock 转换为LegacyHeaderVersion的区块，保留原来的区块哈希
+func (lb *legacyBlock) toBlock() (*Block, error) {
+	if len(lb.Transactions) == 0 {
+		return nil, errors.New("decode legacy block: block has no transaction")
+	}
+	tree := merkletree.CreateLegacyMerkleTree(lb.Transactions)
+	return &Block{
+		BlockHeader: BlockHeader{
+			Version:    LegacyHeaderVersion,
+			PrevHash:   lb.PrevHash,
+			MerkleRoot: tree.Root(),
+			Timestamp:  lb.Timestamp,
+			Bits:       bitsFromTarget(lb.Target),
+			Nonce:      lb.Nonce,
+		},
+		Hash:         lb.Hash,
+		Transactions: lb.Transactions,
+	}, nil
+}
+
+// deSerializeGobBlock 解码版本0的区块，交易没有Version字段，解码后是LegacyVersion
+func deSerializeGobBlock(data []byte) (*Block, error) {
+	var legacy legacyBlock
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy)
+	if err != nil {
+		return nil, fmt.Errorf("decode legacy block: %w", err)
+	}
+	return legacy.toBlock()
+}
+
+// deSerializeWireV1Block 解码版本1的区块
+func deSerializeWireV1Block(data []byte) (*Block, error) {
+	r := wire.NewReader(data)
+	if version := r.ReadUvarint(); r.Err() == nil && version != 1 {
+		return nil, fmt.Errorf("decode legacy block: %w %d", wire.ErrUnknownVersion, version)
+	}
+	legacy := legacyBlock{
+		Timestamp:  r.ReadVarint(),
+		Hash:       r.ReadBytes(),
+		PrevHash:   r.ReadBytes(),
+		Target:     r.ReadBytes(),
+		Nonce:      r.ReadVarint(),
+		ExtraNonce: r.ReadVarint(),
+	}
+	legacy.Transactions = decodeTransactions(r)
+	if err := r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode legacy block: %w", err)
+	}
+	return legacy.toBlock()
+}
+
+// readSchema 读取数据库版本，没有版本号的是最早的数据库
+func readSchema(db storage.ChainStore) (uint64, error) {
+	var version uint64
+	err := db.View(func(txn storage.Tx) error {
+		val, err := txn.Get([]byte(constcoe.SchemaKey))
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var n int
+		version, n = binary.Uvarint(val)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad version %x", ErrUnsupportedSchema, val)
+		}
+		return nil
+	})
+	return version, err
+}
+
+func setSchema(txn storage.Tx) error {
+	return setSchemaVersion(txn, SchemaVersion)
+}
+
+func setSchemaVersion(txn storage.Tx, version uint64) error {
+	return txn.Set([]byte(constcoe.SchemaKey), binary.AppendUvarint(nil, version))
+}
+
+// migrateChain 把旧数据库升级到当前版本，全部完成后写入版本号
+// 1. 以区块哈希为key的区块逐个转换为区块头和区块体，区块哈希和交易ID都保持不变，中断后再次打开会跳过已经转换的区块
+// 2. 记录数据库中所有LegacyHeaderVersion的区块
+// 3. 由区块体重建UTXO集合、高度索引和地址索引，中断后再次打开会重新重建，版本3只建立地址索引，版本4不需要重建
+func migrateChain(db storage.ChainStore, tip []byte) error {
+	version, err := readSchema(db)
+	if err != nil {
+		return err
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: version %d is newer than %d", ErrUnsupportedSchema, version, SchemaVersion)
+	}
+	if version < 2 {
+		if err = migrateBlocks(db, tip); err != nil {
+			return err
+		}
+	}
+	if err = markMigratedBlocks(db); err != nil {
+		return err
+	}
+	switch version {
+	case 4:
+	case 3:
+		// 版本3只缺少地址索引，裁剪过的区块链也可以升级
+		err = buildAddressIndex(db, tip)
+	default:
+		err = rebuildChainState(db, tip)
+	}
+	if err != nil {
+		return err
+	}
+	return db.Update(setSchema)
+}
+
+func migratedKey(hash []byte) []byte {
+	return append([]byte(constcoe.MigratedPrefix), hash...)
+}
+
+// markMigratedBlocks 记录数据库中所有LegacyHeaderVersion的区块，包括被标记为无效的分支上的区块
+// 这些区块的哈希无法由区块头验证，只能在迁移时信任，之后从其他来源收到的这个版本的区块都会被拒绝
+func markMigratedBlocks(db storage.ChainStore) error {
+	hashes := make([][]byte, 0)
+	prefix := []byte(constcoe.HeaderPrefix)
+	err := db.View(func(txn storage.Tx) error {
+		return txn.ForEach(prefix, func(key, value []byte) error {
+			header, err := DeserializeBlockHeader(value)
+			if err != nil {
+				return fmt.Errorf("block %x: %w", key[len(prefix):], err)
+			}
+			if header.Version == LegacyHeaderVersion {
+				hashes = append(hashes, append([]byte(nil), key[len(prefix):]...))
+			}
+			return nil
+		})
+	})
+	if err != nil || len(hashes) == 0 {
+		return err
+	}
+	return db.Update(func(txn storage.Tx) error {
+		for _, hash := range hashes {
+			if err := txn.Set(migratedKey(hash), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// isMigratedBlock 区块是否是迁移时记录的旧区块
+func isMigratedBlock(txn storage.Tx, hash []byte) (bool, error) {
+	_, err := txn.Get(migratedKey(hash))
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// migrateBlocks 把以区块哈希为key的区块转换为区块头和区块体
+func migrateBlocks(db storage.ChainStore, tip []byte) error {
+	chain := &BlockChain{LastHash: tip, Database: db}
+	ogPrevHash, err := chain.BackOgPrevHash()
+	if err != nil {
+		return err
+	}
+	hash := tip
+	for {
+		var prevHash []byte
+		err = db.Update(func(txn storage.Tx) error {
+			data, err := txn.Get(hash)
+			if errors.Is(err, storage.ErrNotFound) {
+				// 已经转换过的区块
+				header, err := getHeader(txn, hash)
+				if err != nil {
+					return err
+				}
+				prevHash = header.PrevHash
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			block, err := deSerializeWireV1Block(data)
+			if err != nil {
+				block, err = deSerializeGobBlock(data)
+			}
+			if err != nil {
+				return err
+			}
+			prevHash = block.PrevHash
+			err = putBlock(txn, block)
+			if err != nil {
+				return err
+			}
+			return txn.Delete(hash)
+		})
+		if err != nil {
+			return fmt.Errorf("migrate block %x: %w", hash, err)
+		}
+		if bytes.Equal(prevHash, ogPrevHash) {
+			break
+		}
+		hash = prevHash
+	}
+	return nil
+}
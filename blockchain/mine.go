@@ -2,84 +2,188 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/storage"
 	"github.com/limitzhang87/goblockchain/transaction"
 	"github.com/limitzhang87/goblockchain/utils"
-	"log"
 )
 
-func (bc *BlockChain) RunMine() {
-	pool := CreatePool()
-	if !bc.VerityTransaction(pool.Txs) {
-		log.Println("falls in transactions verification")
-		err := RemovePoolFile()
-		utils.Handle(err)
-		return
+// RunMine 将交易池中的交易打包成区块并加入区块链，ctx被取消时停止挖矿
+// rewardPubKeyHash不为空时区块中加入挖矿奖励交易，此时交易池为空也可以挖矿
+// 只在创建模板和提交区块时打开区块链，挖矿期间其他命令可以使用区块链，最新区块变化时返回ErrTipMismatch
+func RunMine(ctx context.Context, openChain func() (*BlockChain, error), miner *Miner, rewardPubKeyHash []byte) (*MineStats, error) {
+	cm := &ContinuousMiner{OpenChain: openChain, Miner: miner, RewardPubKeyHash: rewardPubKeyHash}
+	block, err := cm.template(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	block := CreateBlock(bc.LastHash, pool.Txs)
-	if !block.ValidatePoW() {
-		fmt.Println("Block has invalid nonce!")
-		return
-	}
-	bc.AddBlock(block)
-	err := RemovePoolFile()
-	utils.Handle(err)
+	stats, err := miner.Mine(ctx, block)
+	if err != nil {
+		return stats, err
+	}
+	return stats, cm.submit(ctx, block)
 }
 
-// VerityTransaction 验证交易是否有效
-func (bc *BlockChain) VerityTransaction(txs []*transaction.Transaction) bool {
-	// 1. 交易输入不能重复使用
-	// 2. 交易输入要有效
-	// 3. 输入金额要等于输出金额
+// blockReserve 创建区块模板时为区块头、区块哈希和挖矿奖励交易预留的字节数
+const blockReserve = 1000
 
-	inAmount, outAmount := 0, 0
-	spentInput := make(map[string]int)
+// BuildBlockTemplate 用交易池中的交易创建区块模板，同时返回被丢弃的无效交易
+// rewardPubKeyHash不为空时第一笔交易为挖矿奖励，金额为区块补贴加上手续费
+// 放不进区块的交易留在交易池中等待下一个区块
+func (bc *BlockChain) BuildBlockTemplate(txs []*transaction.Transaction, rewardPubKeyHash []byte) (*Block, []*transaction.Transaction, error) {
+	verifier, err := bc.newTxVerifier()
+	if err != nil {
+		return nil, nil, err
+	}
+	valid := make([]*transaction.Transaction, 0, len(txs)+1)
+	invalid := make([]*transaction.Transaction, 0)
+	fees := 0
+	space := chaincfg.ActiveParams().MaxBlockSize - blockReserve
 	for _, tx := range txs {
-		if len(tx.Inputs) == 0 {
-			fmt.Println("Inputs is empty!")
-			return false
+		size := len(tx.Serialize())
+		if size > space {
+			continue
 		}
-		pubKey := tx.Inputs[0].PubKey
-		unspentTx := bc.FindUnspentTransactions(pubKey)
-
-		// 1. 交易输入不能重复使用
-		for _, input := range tx.Inputs {
-			inTxId := hex.EncodeToString(input.TxID)
-
-			// 交易输入重复使用，直接返回失败
-			if txId, ok := spentInput[inTxId]; ok && txId == input.OutIdx {
-				fmt.Println("input had already spent")
-				return false
-			}
-			v, ok := bc.isInputRight(unspentTx, input)
-			if !ok {
-				fmt.Println("input not right")
-				return false
-			}
-			inAmount += v
-			spentInput[inTxId] = input.OutIdx
+		fee, err := verifier.verify(tx)
+		if errors.Is(err, ErrInvalidTransaction) {
+			invalid = append(invalid, tx)
+			continue
+		}
+		// 还没到锁定时间或者使用了未成熟的挖矿奖励，留在交易池中等待之后的区块
+		if errors.Is(err, ErrNonFinalTransaction) || errors.Is(err, ErrImmatureCoinbase) {
+			continue
 		}
+		if err != nil {
+			return nil, nil, err
+		}
+		valid = append(valid, tx)
+		fees += fee
+		space -= size
+	}
 
-		for _, output := range tx.Outputs {
-			outAmount += output.Value
+	if len(rewardPubKeyHash) == 0 {
+		if len(valid) == 0 {
+			return nil, invalid, ErrEmptyPool
 		}
+		block, err := bc.newBlockTemplate(valid)
+		return block, invalid, err
 	}
 
-	if inAmount != outAmount {
-		fmt.Println("inAmount != outAmount")
-		return false
+	reward := chaincfg.ActiveParams().Subsidy(verifier.height) + fees
+	coinbase := transaction.CoinbaseTx(rewardPubKeyHash, reward, verifier.height)
+	valid = append([]*transaction.Transaction{coinbase}, valid...)
+	block, err := bc.newBlockTemplate(valid)
+	return block, invalid, err
+}
+
+// newBlockTemplate 在最新的区块后面创建区块模板，时间戳至少比MedianTimePast大1
+func (bc *BlockChain) newBlockTemplate(txs []*transaction.Transaction) (*Block, error) {
+	median, err := bc.MedianTimePast(bc.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	block := NewBlockTemplate(bc.LastHash, txs)
+	if block.Timestamp <= median {
+		block.Timestamp = median + 1
 	}
-	return true
+	return block, nil
 }
 
-// isInputRight 判断交易输入是否有效，是否是当前用于在块中的未花费交易输出
-func (bc *BlockChain) isInputRight(txs []*transaction.Transaction, input transaction.TxInput) (int, bool) {
+// VerityTransaction 验证交易是否有效
+func (bc *BlockChain) VerityTransaction(txs []*transaction.Transaction) error {
+	verifier, err := bc.newTxVerifier()
+	if err != nil {
+		return err
+	}
 	for _, tx := range txs {
-		if bytes.Equal(tx.ID, input.TxID) && input.OutIdx < len(tx.Outputs) {
-			return tx.Outputs[input.OutIdx].Value, true
+		_, err := verifier.verify(tx)
+		if err != nil {
+			return err
 		}
 	}
-	return 0, false
+	return nil
+}
+
+// txVerifier 逐笔验证交易，记录前面的交易已经使用的交易输入
+// 交易按照打包到下一个区块中验证，锁定时间和最新区块的MedianTimePast比较
+type txVerifier struct {
+	bc         *BlockChain
+	spent      map[string]bool
+	height     int
+	medianTime int64
+}
+
+func (bc *BlockChain) newTxVerifier() (*txVerifier, error) {
+	height, err := bc.Height()
+	if err != nil {
+		return nil, err
+	}
+	median, err := bc.MedianTimePast(bc.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	return &txVerifier{bc: bc, spent: make(map[string]bool), height: height + 1, medianTime: median}, nil
+}
+
+// verify 验证一笔交易，返回交易的手续费
+// 0. 交易的大小、个数和金额在共识限制之内，ID和签名有效，并且已经过了锁定时间
+// 1. 交易输入不能重复使用
+// 2. 交易输入要有效，属于这个输入的公钥，挖矿奖励要已经成熟
+// 3. 输入金额不能小于输出金额，差额为手续费
+func (v *txVerifier) verify(tx *transaction.Transaction) (int, error) {
+	if tx.IsBase() {
+		return 0, fmt.Errorf("%w: base transaction %x is not allowed in pool", ErrInvalidTransaction, tx.ID)
+	}
+	if err := CheckTransactionSanity(tx); err != nil {
+		return 0, err
+	}
+	if err := checkTransactionSignature(tx); err != nil {
+		return 0, err
+	}
+	if !tx.IsFinal(v.height, v.medianTime) {
+		return 0, fmt.Errorf("%w: transaction %x lock time %d, block height %d, median time %d", ErrNonFinalTransaction, tx.ID, tx.LockTime, v.height, v.medianTime)
+	}
+
+	inAmount, outAmount := 0, 0
+	used := make(map[string]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		op := OutPoint{TxID: input.TxID, OutIdx: input.OutIdx}
+
+		// 交易输入重复使用，直接返回失败
+		if v.spent[op.String()] || used[op.String()] {
+			return 0, fmt.Errorf("%w: input %s had already spent", ErrInvalidTransaction, op)
+		}
+		var utxo UTXO
+		var ok bool
+		err := v.bc.Database.View(func(txn storage.Tx) error {
+			var err error
+			utxo, ok, err = getUTXO(txn, op)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		if !ok || !bytes.Equal(utxo.Output.PubKeyHash, utils.PublicKeyHash(input.PubKey)) {
+			return 0, fmt.Errorf("%w: input %s not right", ErrInvalidTransaction, op)
+		}
+		if !utxo.Mature(v.height) {
+			return 0, fmt.Errorf("%w: input %s is mined at height %d, spent at height %d", ErrImmatureCoinbase, op, utxo.Height, v.height)
+		}
+		inAmount += utxo.Value()
+		used[op.String()] = true
+	}
+
+	for _, output := range tx.Outputs {
+		outAmount += output.Value
+	}
+	if inAmount < outAmount {
+		return 0, fmt.Errorf("%w: inAmount %d < outAmount %d", ErrInvalidTransaction, inAmount, outAmount)
+	}
+	for op := range used {
+		v.spent[op] = true
+	}
+	return inAmount - outAmount, nil
 }
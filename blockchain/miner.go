@@ -0,0 +1,175 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/limitzhang87/goblockchain/clock"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkInterval 每计算多少次哈希检查一次是否需要停止
+const checkInterval = 1 << 12
+
+// Miner 多协程挖矿，将nonce空间平均分给每个协程
+// nonce空间用完后会更新时间戳，如果时间戳没有变化则增加挖矿奖励交易的ExtraNonce，然后重新开始
+type Miner struct {
+	Workers  int          // 挖矿协程数，小于等于0时使用CPU核数
+	MaxNonce int64        // nonce的上限，为0时使用math.MaxInt64
+	Now      func() int64 // 获取当前时间戳，为空时使用clock.Now
+}
+
+// MineStats 一次挖矿的统计信息
+type MineStats struct {
+	Hashes   uint64
+	Rounds   int // 更新时间戳或ExtraNonce的次数加1
+	Duration time.Duration
+}
+
+// HashRate 每秒计算的哈希次数
+func (s *MineStats) HashRate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Duration.Seconds()
+}
+
+// NewMiner 创建使用workers个协程的矿工
+func NewMiner(workers int) *Miner {
+	return &Miner{Workers: workers}
+}
+
+func (m *Miner) workers() int {
+	if m.Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return m.Workers
+}
+
+func (m *Miner) maxNonce() int64 {
+	if m.MaxNonce <= 0 {
+		return math.MaxInt64
+	}
+	return m.MaxNonce
+}
+
+func (m *Miner) now() int64 {
+	if m.Now == nil {
+		return clock.Now().Unix()
+	}
+	return m.Now()
+}
+
+// Mine 为区块寻找满足难度的nonce，找到后设置区块的Nonce、Timestamp、merkle根和Hash
+// ctx被取消时返回ErrMiningCanceled，区块保持未完成的状态
+func (m *Miner) Mine(ctx context.Context, b *Block) (*MineStats, error) {
+	start := time.Now()
+	stats := &MineStats{}
+	target := b.Target()
+	var extraNonce int64
+
+	for {
+		stats.Rounds++
+		nonce, found, hashes := m.mineRound(ctx, b, target)
+		stats.Hashes += hashes
+		if found {
+			b.Nonce = nonce
+			b.SetHash()
+			stats.Duration = time.Since(start)
+			return stats, nil
+		}
+		if ctx.Err() != nil {
+			stats.Duration = time.Since(start)
+			return stats, ErrMiningCanceled
+		}
+
+		// nonce空间用完，更新时间戳，时间戳没变时修改挖矿奖励交易的ExtraNonce
+		// 没有挖矿奖励交易时只能等待时间戳变化
+		if now := m.now(); now > b.Timestamp {
+			b.Timestamp = now
+		} else if extraNonce++; b.Transactions[0].SetExtraNonce(extraNonce) {
+			b.updateMerkleRoot()
+		} else if !m.waitNextSecond(ctx, b.Timestamp) {
+			stats.Duration = time.Since(start)
+			return stats, ErrMiningCanceled
+		}
+	}
+}
+
+// waitNextSecond 等待当前时间超过timestamp，ctx被取消时返回false
+func (m *Miner) waitNextSecond(ctx context.Context, timestamp int64) bool {
+	for m.now() <= timestamp {
+		if !sleepContext(ctx, 100*time.Millisecond) {
+			return false
+		}
+	}
+	return true
+}
+
+// mineRound 使用多个协程搜索整个nonce空间
+func (m *Miner) mineRound(ctx context.Context, b *Block, target *big.Int) (int64, bool, uint64) {
+	workers := m.workers()
+	maxNonce := m.maxNonce()
+	if int64(workers) > maxNonce {
+		workers = int(maxNonce)
+	}
+	step := maxNonce / int64(workers)
+
+	prefix := b.powPrefix()
+	var (
+		wg       sync.WaitGroup
+		done     atomic.Bool
+		hashes   atomic.Uint64
+		once     sync.Once
+		resNonce int64
+	)
+	for i := 0; i < workers; i++ {
+		from := int64(i) * step
+		to := from + step
+		if i == workers-1 {
+			to = maxNonce
+		}
+		wg.Add(1)
+		go func(from, to int64) {
+			defer wg.Done()
+			nonce, ok, count := searchNonce(ctx, &done, prefix, target, from, to)
+			hashes.Add(count)
+			if ok {
+				once.Do(func() {
+					resNonce = nonce
+					done.Store(true)
+				})
+			}
+		}(from, to)
+	}
+	wg.Wait()
+	return resNonce, done.Load(), hashes.Load()
+}
+
+// searchNonce 在[from, to)中搜索nonce，nonce是区块头编码的最后8个字节
+func searchNonce(ctx context.Context, done *atomic.Bool, prefix []byte, target *big.Int, from, to int64) (int64, bool, uint64) {
+	data := make([]byte, len(prefix)+8)
+	copy(data, prefix)
+	nonceBytes := data[len(prefix):]
+
+	var intHash big.Int
+	var count uint64
+	for nonce := from; nonce < to; nonce++ {
+		if count%checkInterval == 0 && (done.Load() || ctx.Err() != nil) {
+			return 0, false, count
+		}
+		binary.BigEndian.PutUint64(nonceBytes, uint64(nonce))
+		hash := sha256.Sum256(data)
+		count++
+		intHash.SetBytes(hash[:])
+		if intHash.Cmp(target) == -1 {
+			return nonce, true, count
+		}
+	}
+	return 0, false, count
+}
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/merkletree"
+)
+
+// InclusionProof 证明交易在某个区块中，可以交给没有完整区块链的第三方，对方只需要区块头就能验证
+type InclusionProof struct {
+	BlockHash []byte
+	Proof     *merkletree.MerkleProof
+}
+
+type inclusionProofJSON struct {
+	BlockHash string                  `json:"blockHash"`
+	Proof     *merkletree.MerkleProof `json:"proof"`
+}
+
+func (p *InclusionProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(inclusionProofJSON{BlockHash: hex.EncodeToString(p.BlockHash), Proof: p.Proof})
+}
+
+func (p *InclusionProof) UnmarshalJSON(data []byte) error {
+	var v inclusionProofJSON
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	blockHash, err := hex.DecodeString(v.BlockHash)
+	if err != nil {
+		return fmt.Errorf("decode block hash: %w", err)
+	}
+	if v.Proof == nil {
+		return fmt.Errorf("%w: missing proof", ErrInvalidProof)
+	}
+	*p = InclusionProof{BlockHash: blockHash, Proof: v.Proof}
+	return nil
+}
+
+// FindTransaction 从最新的区块往回查找交易，返回所在区块和交易在区块中的索引
+func (bc *BlockChain) FindTransaction(txID []byte) (*Block, int, error) {
+	ogPrevHash, err := bc.BackOgPrevHash()
+	if err != nil {
+		return nil, 0, err
+	}
+	iter := bc.Iterator()
+	for {
+		block, err := iter.Next()
+		if errors.Is(err, ErrBlockPruned) {
+			return nil, 0, fmt.Errorf("%w: %x is not in the blocks kept by this pruned node", ErrTransactionNotFound, txID)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if idx := block.txIndex(txID); idx >= 0 {
+			return block, idx, nil
+		}
+		if bytes.Equal(block.PrevHash, ogPrevHash) {
+			return nil, 0, fmt.Errorf("%w: %x", ErrTransactionNotFound, txID)
+		}
+	}
+}
+
+// ProveTransactions 生成交易的证明，多个交易必须在同一个区块中
+func (bc *BlockChain) ProveTransactions(txIDs [][]byte) (*InclusionProof, error) {
+	if len(txIDs) == 0 {
+		return nil, fmt.Errorf("%w: no transaction", ErrTransactionNotFound)
+	}
+	block, idx, err := bc.FindTransaction(txIDs[0])
+	if err != nil {
+		return nil, err
+	}
+	indices := []int{idx}
+	for _, txID := range txIDs[1:] {
+		idx = block.txIndex(txID)
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: %x is not in block %x", ErrTransactionNotFound, txID, block.Hash)
+		}
+		indices = append(indices, idx)
+	}
+	proof, err := block.MerkleTree().Proof(indices...)
+	if err != nil {
+		return nil, err
+	}
+	return &InclusionProof{BlockHash: block.Hash, Proof: proof}, nil
+}
+
+// VerifyInclusionProof 使用本地的区块头验证证明
+func (bc *BlockChain) VerifyInclusionProof(p *InclusionProof) error {
+	header, err := bc.GetHeader(p.BlockHash)
+	if err != nil {
+		return err
+	}
+	if !p.Proof.Verify(header.MerkleRoot) {
+		return fmt.Errorf("%w: block %x", ErrInvalidProof, p.BlockHash)
+	}
+	return nil
+}
+
+func (b *Block) txIndex(txID []byte) int {
+	for idx, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			return idx
+		}
+	}
+	return -1
+}
@@ -2,61 +2,47 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"github.com/limitzhang87/goblockchain/constcoe"
-	"github.com/limitzhang87/goblockchain/utils"
-	"math"
-	"math/big"
+	"context"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/wire"
 )
 
-func (b *Block) GetTarget() []byte {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-constcoe.Difficult))
-	return target.Bytes()
+// powPrefix 区块头编码中nonce前面的部分
+func (b *Block) powPrefix() []byte {
+	w := wire.NewWriter()
+	b.BlockHeader.encodeWithoutNonce(w)
+	return w.Bytes()
 }
 
-func (b *Block) GetBase4Nonce(nonce int64) []byte {
-	data := bytes.Join([][]byte{
-		utils.ToHexInt(b.Timestamp),
-		b.PrevHash,
-		b.Target,
-		utils.ToHexInt(nonce),
-		b.BackTransactionSummary(),
-	}, []byte{})
-	return data
+// FindNonce 使用全部CPU寻找nonce，nonce空间用完时会修改区块的Timestamp或挖矿奖励交易的ExtraNonce
+func (b *Block) FindNonce() int64 {
+	_, _ = NewMiner(0).Mine(context.Background(), b) // 不会被取消，也就不会返回错误
+	return b.Nonce
 }
 
-func (b *Block) FindNonce() int64 {
-	var intHash big.Int
-	var intTarget big.Int
-	var hash [32]byte
-	var nonce int64
-	nonce = 0
-	intTarget.SetBytes(b.Target)
+// ValidatePoW 验证工作量，区块哈希要和区块头一致
+func (b *Block) ValidatePoW() bool {
+	return bytes.Equal(b.Hash, b.BlockHash()) && b.BlockHeader.ValidatePoW()
+}
 
-	for nonce < math.MaxInt64 {
-		data := b.GetBase4Nonce(nonce)
-		hash = sha256.Sum256(data)
-		intHash.SetBytes(hash[:])
-		if intHash.Cmp(&intTarget) == -1 {
-			break
+// checkPoW 验证数据库中的区块的工作量，迁移时记录的旧区块在迁移前已经验证过，直接信任
+func checkPoW(txn storage.Tx, block *Block) error {
+	if block.Version == LegacyHeaderVersion {
+		migrated, err := isMigratedBlock(txn, block.Hash)
+		if err != nil || migrated {
+			return err
 		}
-		nonce++
 	}
-	return nonce
+	if !block.ValidatePoW() {
+		return fmt.Errorf("%w: block %x", ErrInvalidPoW, block.Hash)
+	}
+	return nil
 }
 
-// ValidatePoW 验证工作量
-func (b *Block) ValidatePoW() bool {
-	var intHash big.Int
-	var intTarget big.Int
-	var hash [32]byte
-	intTarget.SetBytes(b.Target)
-	data := b.GetBase4Nonce(b.Nonce)
-	hash = sha256.Sum256(data)
-	intHash.SetBytes(hash[:])
-	if intHash.Cmp(&intTarget) == -1 {
-		return true
-	}
-	return false
+// CheckPoW 和ValidatePoW一样，但是信任迁移时记录的旧区块
+func (bc *BlockChain) CheckPoW(block *Block) error {
+	return bc.Database.View(func(txn storage.Tx) error {
+		return checkPoW(txn, block)
+	})
 }
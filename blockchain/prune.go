@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"fmt"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+)
+
+// pruneBatch 裁剪时每个事务最多删除的区块体个数，避免事务过大
+const pruneBatch = 1000
+
+// Prune 删除最新的keep个区块以外的区块体和撤销数据，保留区块头、高度索引和UTXO集合，返回删除的区块体个数
+// 每批删除和裁剪高度在同一个事务中更新，中断后再次执行会从上次的裁剪高度继续
+func (bc *BlockChain) Prune(keep int) (int, error) {
+	if keep <= 0 {
+		return 0, fmt.Errorf("prune must keep at least one block, not %d", keep)
+	}
+	pruned := 0
+	for {
+		done, batch := false, 0
+		err := bc.Database.Update(func(txn storage.Tx) error {
+			tip, err := getTipHeight(txn)
+			if err != nil {
+				return err
+			}
+			from, err := getPruneHeight(txn)
+			if err != nil {
+				return err
+			}
+			to := min(tip-keep+1, from+pruneBatch)
+			if from >= to {
+				done = true
+				return nil
+			}
+			for height := from; height < to; height++ {
+				hash, err := getHashAtHeight(txn, height)
+				if err != nil {
+					return err
+				}
+				if err = txn.Delete(bodyKey(hash)); err != nil {
+					return err
+				}
+				if err = txn.Delete(undoKey(hash)); err != nil {
+					return err
+				}
+				batch++
+			}
+			return setInt(txn, constcoe.PruneHeightKey, to)
+		})
+		if err != nil || done {
+			return pruned, err
+		}
+		pruned += batch
+	}
+}
@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/storage"
+)
+
+// checkChainState 检查最新区块、高度索引和区块体是否一致
+// 连接区块的写入在同一个事务中，正常情况下不会不一致，不一致说明数据库被外部修改或者由不完整的旧版本写入
+func checkChainState(txn storage.Tx, tip []byte) error {
+	height, err := getTipHeight(txn)
+	if err != nil {
+		return err
+	}
+	hash, err := getHashAtHeight(txn, height)
+	if errors.Is(err, ErrBlockNotFound) {
+		return fmt.Errorf("%w: no block at tip height %d", ErrCorruptChainState, height)
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, tip) {
+		return fmt.Errorf("%w: block at tip height %d is %x, tip is %x", ErrCorruptChainState, height, hash, tip)
+	}
+	_, err = getHashAtHeight(txn, height+1)
+	if err == nil {
+		return fmt.Errorf("%w: height index goes beyond tip height %d", ErrCorruptChainState, height)
+	}
+	if !errors.Is(err, ErrBlockNotFound) {
+		return err
+	}
+	pruneHeight, err := getPruneHeight(txn)
+	if err != nil {
+		return err
+	}
+	if height >= pruneHeight {
+		if _, err = getValue(txn, bodyKey(tip), tip); err != nil {
+			return fmt.Errorf("%w: tip body: %v", ErrCorruptChainState, err)
+		}
+	}
+	return nil
+}
+
+// recoverChainState 打开区块链时检查最新区块，高度索引和UTXO集合不一致时由区块体重建，返回是否重建过
+// 最新区块本身缺失时无法修复
+func recoverChainState(db storage.ChainStore, tip []byte) (bool, error) {
+	var stateErr error
+	err := db.View(func(txn storage.Tx) error {
+		if _, err := getHeader(txn, tip); err != nil {
+			return fmt.Errorf("%w: tip header: %v", ErrCorruptChainState, err)
+		}
+		stateErr = checkChainState(txn, tip)
+		if errors.Is(stateErr, ErrCorruptChainState) {
+			return nil
+		}
+		return stateErr
+	})
+	if err != nil || stateErr == nil {
+		return false, err
+	}
+	if err = reindexChainState(db, tip); err != nil {
+		return false, fmt.Errorf("%v, repair: %w", stateErr, err)
+	}
+	return true, nil
+}
+
+// reindexChainState 由区块体重建UTXO集合和高度索引
+func reindexChainState(db storage.ChainStore, tip []byte) error {
+	// 重建完成之前把数据库标记为旧版本，中断后再次打开时会重新重建
+	err := db.Update(func(txn storage.Tx) error {
+		return setSchemaVersion(txn, stateRebuildVersion)
+	})
+	if err != nil {
+		return err
+	}
+	if err = rebuildChainState(db, tip); err != nil {
+		return err
+	}
+	return db.Update(setSchema)
+}
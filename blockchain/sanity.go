@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"math"
+)
+
+// CheckTransactionSanity 不依赖区块链状态的交易检查：大小、输入输出个数和金额
+func CheckTransactionSanity(tx *transaction.Transaction) error {
+	params := chaincfg.ActiveParams()
+	if len(tx.Inputs) == 0 {
+		return fmt.Errorf("%w: inputs is empty", ErrInvalidTransaction)
+	}
+	if len(tx.Outputs) == 0 {
+		return fmt.Errorf("%w: outputs is empty", ErrInvalidTransaction)
+	}
+	if len(tx.Inputs) > params.MaxTxInputs {
+		return fmt.Errorf("%w: %d inputs, at most %d", ErrInvalidTransaction, len(tx.Inputs), params.MaxTxInputs)
+	}
+	if len(tx.Outputs) > params.MaxTxOutputs {
+		return fmt.Errorf("%w: %d outputs, at most %d", ErrInvalidTransaction, len(tx.Outputs), params.MaxTxOutputs)
+	}
+	if size := len(tx.Serialize()); size > params.MaxTxSize {
+		return fmt.Errorf("%w: size %d, at most %d", ErrInvalidTransaction, size, params.MaxTxSize)
+	}
+
+	// 挖矿奖励交易的金额可以为0，其他交易的输出不能小于DustThreshold
+	minValue := params.DustThreshold
+	if tx.IsBase() {
+		minValue = 0
+	}
+	total := 0
+	for idx, out := range tx.Outputs {
+		if out.Value < minValue {
+			return fmt.Errorf("%w: output %d value %d is below %d", ErrInvalidTransaction, idx, out.Value, minValue)
+		}
+		if out.Value > math.MaxInt-total {
+			return fmt.Errorf("%w: total output value overflows", ErrInvalidTransaction)
+		}
+		total += out.Value
+	}
+
+	if tx.IsBase() {
+		return nil
+	}
+	used := make(map[string]bool, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		op := OutPoint{TxID: in.TxID, OutIdx: in.OutIdx}.String()
+		if in.OutIdx < 0 {
+			return fmt.Errorf("%w: input %s has negative index", ErrInvalidTransaction, op)
+		}
+		if used[op] {
+			return fmt.Errorf("%w: input %s is used twice", ErrInvalidTransaction, op)
+		}
+		used[op] = true
+	}
+	return nil
+}
+
+// checkTransactionSignature 检查交易ID和交易内容一致，普通交易每个输入的签名都有效
+// 旧版本交易的ID和签名无法重新计算，只能出现在迁移时记录的旧区块中，调用方在检查之前跳过
+func checkTransactionSignature(tx *transaction.Transaction) error {
+	if tx.Version == transaction.LegacyVersion {
+		return fmt.Errorf("%w: legacy transaction %x can not be verified", ErrInvalidTransaction, tx.ID)
+	}
+	if !tx.VerifyID() {
+		return fmt.Errorf("%w: transaction %x id does not match its content", ErrInvalidTransaction, tx.ID)
+	}
+	if !tx.IsBase() && !tx.Verity() {
+		return fmt.Errorf("%w: transaction %x has invalid signature", ErrInvalidTransaction, tx.ID)
+	}
+	return nil
+}
+
+// CheckBlockSanity 不依赖区块链状态的区块检查：大小、交易和merkle根
+// 只有第一笔交易可以是挖矿奖励交易，同一笔交易不能出现两次
+func CheckBlockSanity(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("%w: block %x has no transaction", ErrInvalidBlock, block.Hash)
+	}
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+	if maxSize := chaincfg.ActiveParams().MaxBlockSize; len(data) > maxSize {
+		return fmt.Errorf("%w: block %x size %d, at most %d", ErrInvalidBlock, block.Hash, len(data), maxSize)
+	}
+
+	seen := make(map[string]bool, len(block.Transactions))
+	for idx, tx := range block.Transactions {
+		if idx > 0 && tx.IsBase() {
+			return fmt.Errorf("%w: transaction %d of block %x is a base transaction", ErrInvalidBlock, idx, block.Hash)
+		}
+		if seen[string(tx.ID)] {
+			return fmt.Errorf("%w: transaction %x appears twice in block %x", ErrInvalidBlock, tx.ID, block.Hash)
+		}
+		seen[string(tx.ID)] = true
+		if err = CheckTransactionSanity(tx); err != nil {
+			return fmt.Errorf("%w: transaction %x: %w", ErrInvalidBlock, tx.ID, err)
+		}
+	}
+
+	if !block.ValidateMerkleRoot() {
+		return fmt.Errorf("%w: block %x", ErrInvalidMerkleRoot, block.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/wire"
+	"hash"
+	"io"
+	"math"
+)
+
+// UTXO快照文件使用和区块链导出文件相同的记录格式，记录依次是：
+// 1. 文件头：snapshotMagic、版本号、网络名字、最新区块哈希、高度和UTXO个数
+// 2. 从创世区块到最新区块的区块哈希和区块头，一共高度加1条
+// 3. 按数据库中key的顺序排列的UTXO：交易ID、输出下标和encodeUTXO的字段
+// 4. 快照哈希：最新区块哈希、高度和所有UTXO记录的sha256
+// 快照哈希可以通过其他途径和可信的节点核对，区块头由工作量证明和最新区块哈希保证
+const (
+	snapshotMagic   = "gbcutxo"
+	snapshotVersion = 1
+	// snapshotRecordMax 快照文件中一条记录的字节数上限
+	snapshotRecordMax = 4096
+)
+
+// SnapshotInfo UTXO快照的内容摘要
+type SnapshotInfo struct {
+	TipHash []byte
+	Height  int
+	UTXOs   int
+	Hash    []byte // 快照哈希
+}
+
+// newSnapshotHasher 快照哈希从最新区块哈希和高度开始计算
+func newSnapshotHasher(tipHash []byte, height int) hash.Hash {
+	h := sha256.New()
+	w := wire.NewWriter()
+	w.WriteBytes(tipHash)
+	w.WriteUvarint(uint64(height))
+	h.Write(w.Bytes())
+	return h
+}
+
+// DumpUTXOSet 把区块头和UTXO集合写入快照文件，在同一个只读事务中读取，数据保持一致
+func (bc *BlockChain) DumpUTXOSet(w io.Writer) (*SnapshotInfo, error) {
+	bw := bufio.NewWriter(w)
+	info := &SnapshotInfo{}
+	err := bc.Database.View(func(txn storage.Tx) error {
+		tip, err := getValue(txn, []byte(constcoe.LHKey), nil)
+		if err != nil {
+			return err
+		}
+		height, err := getTipHeight(txn)
+		if err != nil {
+			return err
+		}
+		count := 0
+		err = forEachUTXO(txn, func(_ []byte, _ UTXO) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		info.TipHash, info.Height, info.UTXOs = tip, height, count
+
+		header := wire.NewWriter()
+		header.WriteBytes([]byte(snapshotMagic))
+		header.WriteUvarint(snapshotVersion)
+		header.WriteBytes([]byte(chaincfg.ActiveParams().Name))
+		header.WriteBytes(tip)
+		header.WriteUvarint(uint64(height))
+		header.WriteUvarint(uint64(count))
+		if err = writeRecord(bw, header.Bytes()); err != nil {
+			return err
+		}
+
+		for h := 0; h <= height; h++ {
+			blockHash, err := getHashAtHeight(txn, h)
+			if err != nil {
+				return err
+			}
+			data, err := getValue(txn, headerKey(blockHash), blockHash)
+			if err != nil {
+				return err
+			}
+			record := wire.NewWriter()
+			record.WriteBytes(blockHash)
+			record.WriteBytes(data)
+			if err = writeRecord(bw, record.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		hasher := newSnapshotHasher(tip, height)
+		err = forEachUTXO(txn, func(_ []byte, utxo UTXO) error {
+			record := wire.NewWriter()
+			record.WriteBytes(utxo.TxID)
+			record.WriteUvarint(uint64(utxo.OutIdx))
+			encodeUTXO(record, utxo)
+			hasher.Write(record.Bytes())
+			return writeRecord(bw, record.Bytes())
+		})
+		if err != nil {
+			return err
+		}
+		info.Hash = hasher.Sum(nil)
+		return writeRecord(bw, info.Hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, bw.Flush()
+}
+
+// LoadUTXOSnapshot 使用快照文件创建区块链，只能在没有区块链的数据目录中使用
+// 检查区块头的链接、哈希和工作量证明以及快照哈希，wantHash不为空时快照哈希必须等于wantHash
+// 加载后只有区块头和UTXO集合，没有区块体，之后的区块正常加入，失败时删除创建的数据库
+func LoadUTXOSnapshot(r io.Reader, wantHash []byte) (*BlockChain, *SnapshotInfo, error) {
+	db, err := createDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := loadSnapshot(db, bufio.NewReader(r), wantHash)
+	if err != nil {
+		_ = db.Close()
+		_ = storage.Remove(config.Active().Backend, config.Active().BlocksDir)
+		return nil, nil, err
+	}
+	return &BlockChain{LastHash: info.TipHash, Database: db}, info, nil
+}
+
+func loadSnapshot(db storage.ChainStore, r *bufio.Reader, wantHash []byte) (*SnapshotInfo, error) {
+	next := func() ([]byte, error) {
+		data, err := readRecord(r, snapshotRecordMax, "utxo snapshot")
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("decode utxo snapshot: %w", io.ErrUnexpectedEOF)
+		}
+		return data, err
+	}
+	data, err := next()
+	if err != nil {
+		return nil, err
+	}
+	header := wire.NewReader(data)
+	magic := header.ReadBytes()
+	if header.Err() == nil && string(magic) != snapshotMagic {
+		return nil, errors.New("decode utxo snapshot: not a utxo snapshot")
+	}
+	header.ReadVersion(snapshotVersion)
+	network := header.ReadBytes()
+	info := &SnapshotInfo{
+		TipHash: header.ReadBytes(),
+		Height:  int(header.ReadUvarint()),
+		UTXOs:   int(header.ReadUvarint()),
+	}
+	if err = header.Finish(); err != nil {
+		return nil, fmt.Errorf("decode utxo snapshot: %w", err)
+	}
+	if name := chaincfg.ActiveParams().Name; string(network) != name {
+		return nil, fmt.Errorf("utxo snapshot is dumped from %s, not %s", network, name)
+	}
+
+	batch := db.NewBatch()
+	defer batch.Cancel()
+
+	// 区块头从创世区块开始，每个区块头都要链接到上一个区块
+	prevHash := []byte(chaincfg.ActiveParams().GenesisMessage)
+	for height := 0; height <= info.Height; height++ {
+		if data, err = next(); err != nil {
+			return nil, err
+		}
+		record := wire.NewReader(data)
+		blockHash := record.ReadBytes()
+		headerData := record.ReadBytes()
+		if err = record.Finish(); err != nil {
+			return nil, fmt.Errorf("decode utxo snapshot header at height %d: %w", height, err)
+		}
+		blockHeader, err := DeserializeBlockHeader(headerData)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(blockHeader.PrevHash, prevHash) {
+			return nil, fmt.Errorf("%w: block %x at height %d does not link to %x", ErrInvalidBlock, blockHash, height, prevHash)
+		}
+		if !bytes.Equal(blockHeader.BlockHash(), blockHash) {
+			return nil, fmt.Errorf("%w: block %x hashes to %x", ErrInvalidBlock, blockHash, blockHeader.BlockHash())
+		}
+		if !blockHeader.ValidatePoW() {
+			return nil, fmt.Errorf("%w: block %x", ErrInvalidPoW, blockHash)
+		}
+		if err = batch.Set(headerKey(blockHash), headerData); err != nil {
+			return nil, err
+		}
+		if err = batch.Set(heightKey(height), blockHash); err != nil {
+			return nil, err
+		}
+		prevHash = blockHash
+	}
+	if !bytes.Equal(prevHash, info.TipHash) {
+		return nil, fmt.Errorf("%w: snapshot tip is %x, headers end at %x", ErrInvalidBlock, info.TipHash, prevHash)
+	}
+
+	// UTXO按key的顺序写入，key必须严格递增，这样也不会有重复的UTXO
+	hasher := newSnapshotHasher(info.TipHash, info.Height)
+	var lastKey []byte
+	for i := 0; i < info.UTXOs; i++ {
+		if data, err = next(); err != nil {
+			return nil, err
+		}
+		record := wire.NewReader(data)
+		txID := record.ReadBytes()
+		outIdx := record.ReadUvarint()
+		op := OutPoint{TxID: txID, OutIdx: int(outIdx)}
+		utxo := decodeUTXO(record, op)
+		if err = record.Finish(); err != nil {
+			return nil, fmt.Errorf("decode utxo snapshot output %d: %w", i, err)
+		}
+		if outIdx > math.MaxUint32 {
+			return nil, fmt.Errorf("decode utxo snapshot: output index %d out of range", outIdx)
+		}
+		key := utxoKey(op)
+		if bytes.Compare(key, lastKey) <= 0 {
+			return nil, fmt.Errorf("decode utxo snapshot: output %s is out of order", op)
+		}
+		lastKey = key
+		hasher.Write(data)
+		value := wire.NewWriter()
+		encodeUTXO(value, utxo)
+		if err = batch.Set(key, value.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	if data, err = next(); err != nil {
+		return nil, err
+	}
+	info.Hash = hasher.Sum(nil)
+	if !bytes.Equal(data, info.Hash) {
+		return nil, fmt.Errorf("utxo snapshot hash is %x, but the content hashes to %x", data, info.Hash)
+	}
+	if len(wantHash) != 0 && !bytes.Equal(wantHash, info.Hash) {
+		return nil, fmt.Errorf("utxo snapshot hash is %x, not the trusted hash %x", info.Hash, wantHash)
+	}
+	if _, err = readRecord(r, snapshotRecordMax, "utxo snapshot"); !errors.Is(err, io.EOF) {
+		return nil, errors.New("decode utxo snapshot: unexpected data after the snapshot hash")
+	}
+	if err = batch.Flush(); err != nil {
+		return nil, err
+	}
+
+	// 最后写入最新区块，之前中断的数据库没有最新区块，无法打开
+	err = db.Update(func(txn storage.Tx) error {
+		err := txn.Set([]byte(constcoe.OgPrevHashKey), []byte(chaincfg.ActiveParams().GenesisMessage))
+		if err != nil {
+			return err
+		}
+		if err = setInt(txn, constcoe.TipHeightKey, info.Height); err != nil {
+			return err
+		}
+		// 快照中没有任何区块体
+		if err = setInt(txn, constcoe.PruneHeightKey, info.Height+1); err != nil {
+			return err
+		}
+		if err = setSchema(txn); err != nil {
+			return err
+		}
+		return txn.Set([]byte(constcoe.LHKey), info.TipHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
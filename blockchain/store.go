@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+)
+
+// 区块头和区块体分开保存，只需要区块头的时候不用读取交易
+
+func headerKey(hash []byte) []byte {
+	return append([]byte(constcoe.HeaderPrefix), hash...)
+}
+
+func bodyKey(hash []byte) []byte {
+	return append([]byte(constcoe.BodyPrefix), hash...)
+}
+
+// putBlock 保存区块头和区块体
+func putBlock(txn storage.Tx, block *Block) error {
+	err := txn.Set(headerKey(block.Hash), block.BlockHeader.Serialize())
+	if err != nil {
+		return err
+	}
+	return txn.Set(bodyKey(block.Hash), block.serializeBody())
+}
+
+// getValue 读取key的值，key不存在时返回ErrBlockNotFound
+func getValue(txn storage.Tx, key, hash []byte) ([]byte, error) {
+	value, err := txn.Get(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+	return value, err
+}
+
+func getHeader(txn storage.Tx, hash []byte) (*BlockHeader, error) {
+	data, err := getValue(txn, headerKey(hash), hash)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlockHeader(data)
+}
+
+func getBlock(txn storage.Tx, hash []byte) (*Block, error) {
+	header, err := getHeader(txn, hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := getValue(txn, bodyKey(hash), hash)
+	if errors.Is(err, ErrBlockNotFound) {
+		// 区块头还在，区块体已经被裁剪
+		return nil, fmt.Errorf("%w: %x", ErrBlockPruned, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	txs, err := deserializeBody(data)
+	if err != nil {
+		return nil, err
+	}
+	return newBlock(header, hash, txs)
+}
+
+// GetBlock 根据区块哈希读取区块
+func (bc *BlockChain) GetBlock(hash []byte) (*Block, error) {
+	var block *Block
+	err := bc.Database.View(func(txn storage.Tx) error {
+		var err error
+		block, err = getBlock(txn, hash)
+		return err
+	})
+	return block, err
+}
+
+// GetHeader 根据区块哈希读取区块头
+func (bc *BlockChain) GetHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+	err := bc.Database.View(func(txn storage.Tx) error {
+		var err error
+		header, err = getHeader(txn, hash)
+		return err
+	})
+	return header, err
+}
+
+// HeaderIterator 从最新的区块开始只遍历区块头
+type HeaderIterator struct {
+	CurrentHash []byte
+	Database    storage.ChainStore
+}
+
+func (bc *BlockChain) HeaderIterator() *HeaderIterator {
+	return &HeaderIterator{bc.LastHash, bc.Database}
+}
+
+// Next 返回当前区块的哈希和区块头，然后移动到上一个区块
+func (hi *HeaderIterator) Next() ([]byte, *BlockHeader, error) {
+	hash := hi.CurrentHash
+	var header *BlockHeader
+	err := hi.Database.View(func(txn storage.Tx) error {
+		var err error
+		header, err = getHeader(txn, hash)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	hi.CurrentHash = header.PrevHash
+	return hash, header, nil
+}
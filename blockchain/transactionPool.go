@@ -3,58 +3,258 @@ package blockchain
 import (
 	"bytes"
 	"encoding/gob"
-	"github.com/limitzhang87/goblockchain/constcoe"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/config"
 	"github.com/limitzhang87/goblockchain/transaction"
 	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wire"
 	"os"
+	"time"
+)
+
+// 交易池文件的编码：poolMagic、版本号和交易，不以poolMagic开头的是使用gob编码的旧文件
+const (
+	poolMagic   = "gbcpool"
+	poolVersion = 1
+	// poolFileOverhead 交易池文件中除了交易以外的字节数上限
+	poolFileOverhead = 64
 )
 
 type TransactionPool struct {
 	Txs []*transaction.Transaction
 }
 
-func (p *TransactionPool) AddTransaction(tx *transaction.Transaction) {
+// AddTransaction 交易加入交易池，交易不满足共识限制、签名无效或者交易池已满时返回错误
+func (p *TransactionPool) AddTransaction(tx *transaction.Transaction) error {
+	err := CheckTransactionSanity(tx)
+	if err != nil {
+		return err
+	}
+	if tx.IsBase() {
+		return fmt.Errorf("%w: base transaction %x is not allowed in pool", ErrInvalidTransaction, tx.ID)
+	}
+	if err = checkTransactionSignature(tx); err != nil {
+		return err
+	}
+	cfg := config.Active()
+	if len(p.Txs) >= cfg.MaxPoolTxs {
+		return fmt.Errorf("%w: %d transactions", ErrPoolFull, len(p.Txs))
+	}
+	size := len(tx.Serialize())
+	for _, poolTx := range p.Txs {
+		if bytes.Equal(poolTx.ID, tx.ID) {
+			return fmt.Errorf("%w: %x", ErrTransactionInPool, tx.ID)
+		}
+		size += len(poolTx.Serialize())
+	}
+	if size > cfg.MaxPoolSize {
+		return fmt.Errorf("%w: %d bytes", ErrPoolFull, size)
+	}
 	p.Txs = append(p.Txs, tx)
+	return nil
 }
 
-func (p *TransactionPool) SaveFile() {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(p)
-	utils.Handle(err)
-	err = os.WriteFile(constcoe.TransactionPoolFile, buffer.Bytes(), 0644)
-	utils.Handle(err)
+// spentOutPoints 交易池中的交易使用的所有交易输出
+func (p *TransactionPool) spentOutPoints() map[string]bool {
+	spent := make(map[string]bool)
+	for _, tx := range p.Txs {
+		for _, in := range tx.Inputs {
+			spent[OutPoint{TxID: in.TxID, OutIdx: in.OutIdx}.String()] = true
+		}
+	}
+	return spent
+}
+
+func (p *TransactionPool) SaveFile() error {
+	w := wire.NewWriter()
+	w.WriteBytes([]byte(poolMagic))
+	w.WriteUvarint(poolVersion)
+	encodeTransactions(w, p.Txs)
+	// 先写临时文件再改名，挖矿时读取交易池不会读到写了一半的文件
+	// 区块数据不在磁盘上时数据目录可能还不存在
+	err := os.MkdirAll(config.Active().DataDir, 0755)
+	if err != nil {
+		return err
+	}
+	filename := config.Active().PoolFile
+	tmpFile := filename + ".tmp"
+	err = os.WriteFile(tmpFile, w.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filename)
 }
 
 func (p *TransactionPool) LoadFile() error {
 	// 文件不存在直接退出
-	if !utils.FileExists(constcoe.TransactionPoolFile) {
+	filename := config.Active().PoolFile
+	if !utils.FileExists(filename) {
 		return nil
 	}
 
-	fileContent, err := os.ReadFile(constcoe.TransactionPoolFile)
+	// 文件大小不超过交易池的限制，解码时不会分配过多的内存
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if maxSize := int64(config.Active().MaxPoolSize) + poolFileOverhead; info.Size() > maxSize {
+		return fmt.Errorf("%w: pool file is %d bytes, at most %d", wire.ErrTooLarge, info.Size(), maxSize)
+	}
+	fileContent, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
 
-	var pool TransactionPool
-	decoder := gob.NewDecoder(bytes.NewBuffer(fileContent))
-	err = decoder.Decode(&pool)
+	txs, err := decodePool(fileContent)
 	if err != nil {
 		return err
 	}
-	p.Txs = pool.Txs
+	p.Txs = txs
+	return nil
+}
+
+func decodePool(data []byte) ([]*transaction.Transaction, error) {
+	r := wire.NewReader(data)
+	if magic := r.ReadBytes(); r.Err() != nil || string(magic) != poolMagic {
+		var pool TransactionPool
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&pool)
+		if err != nil {
+			return nil, err
+		}
+		return pool.Txs, nil
+	}
+	r.ReadVersion(poolVersion)
+	txs := decodeTransactions(r)
+	if err := r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode transaction pool: %w", err)
+	}
+	return txs, nil
+}
+
+// AcceptTransaction 交易加入交易池文件并发布TxAccepted事件
+func AcceptTransaction(tx *transaction.Transaction) error {
+	pool, err := CreatePool()
+	if err != nil {
+		return err
+	}
+	if err = pool.AddTransaction(tx); err != nil {
+		return err
+	}
+	if err = pool.SaveFile(); err != nil {
+		return err
+	}
+	publish(Event{Type: TxAccepted, Tx: tx})
 	return nil
 }
 
-func CreatePool() *TransactionPool {
+func CreatePool() (*TransactionPool, error) {
 	pool := &TransactionPool{}
 	err := pool.LoadFile()
-	utils.Handle(err)
-	return pool
+	if err != nil {
+		return nil, fmt.Errorf("load transaction pool: %w", err)
+	}
+	return pool, nil
 }
 
 func RemovePoolFile() error {
-	err := os.Remove(constcoe.TransactionPoolFile)
+	err := os.Remove(config.Active().PoolFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	return err
 }
+
+// RemoveTransactions 从交易池中删除已经打包进区块的交易，交易池为空时删除文件
+func RemoveTransactions(txs []*transaction.Transaction) error {
+	pool, err := CreatePool()
+	if err != nil {
+		return err
+	}
+	packed := make(map[string]bool, len(txs))
+	for _, tx := range txs {
+		packed[hex.EncodeToString(tx.ID)] = true
+	}
+	kept := make([]*transaction.Transaction, 0, len(pool.Txs))
+	removed := make([]*transaction.Transaction, 0, len(txs))
+	for _, tx := range pool.Txs {
+		if packed[hex.EncodeToString(tx.ID)] {
+			removed = append(removed, tx)
+		} else {
+			kept = append(kept, tx)
+		}
+	}
+	if len(kept) == 0 {
+		err = RemovePoolFile()
+	} else {
+		pool.Txs = kept
+		err = pool.SaveFile()
+	}
+	if err != nil {
+		return err
+	}
+	publishTxs(TxRemoved, removed)
+	return nil
+}
+
+// ReturnTransactions 把断开的区块中的交易放回交易池，挖矿奖励交易不放回
+// 断开的交易比交易池中的交易早，放在前面，交易池放不下或者不满足交易池限制的交易被丢弃
+func ReturnTransactions(txs []*transaction.Transaction) error {
+	pool, err := CreatePool()
+	if err != nil {
+		return err
+	}
+	returned := &TransactionPool{}
+	all := make([]*transaction.Transaction, 0, len(txs)+len(pool.Txs))
+	for _, tx := range append(append(all, txs...), pool.Txs...) {
+		if !tx.IsBase() {
+			_ = returned.AddTransaction(tx)
+		}
+	}
+	if len(returned.Txs) == 0 {
+		err = RemovePoolFile()
+	} else {
+		err = returned.SaveFile()
+	}
+	if err != nil {
+		return err
+	}
+	// 放回的交易是新加入的，原来在交易池中但放不下的交易被删除
+	publishTxs(TxAccepted, diffTxs(returned.Txs, pool.Txs))
+	publishTxs(TxRemoved, diffTxs(pool.Txs, returned.Txs))
+	return nil
+}
+
+// diffTxs 返回在txs中但不在other中的交易
+func diffTxs(txs, other []*transaction.Transaction) []*transaction.Transaction {
+	ids := make(map[string]bool, len(other))
+	for _, tx := range other {
+		ids[hex.EncodeToString(tx.ID)] = true
+	}
+	diff := make([]*transaction.Transaction, 0)
+	for _, tx := range txs {
+		if !ids[hex.EncodeToString(tx.ID)] {
+			diff = append(diff, tx)
+		}
+	}
+	return diff
+}
+
+// PoolState 交易池文件的修改时间和大小，用来判断交易池是否有变化
+type PoolState struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// StatPool 返回交易池文件的状态，文件不存在时返回零值
+func StatPool() (PoolState, error) {
+	info, err := os.Stat(config.Active().PoolFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return PoolState{}, nil
+	}
+	if err != nil {
+		return PoolState{}, err
+	}
+	return PoolState{ModTime: info.ModTime(), Size: info.Size()}, nil
+}
@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/wire"
+)
+
+// 撤销数据保存区块花费的UTXO，断开最新区块时删除区块创建的输出并恢复花费的输出
+// 撤销数据和区块体一起被裁剪，从快照加载的区块没有撤销数据
+
+func undoKey(hash []byte) []byte {
+	return append([]byte(constcoe.UndoPrefix), hash...)
+}
+
+// putUndo 按花费的顺序写入UTXO，每个UTXO包括输出的位置
+func putUndo(txn storage.Tx, hash []byte, spent []UTXO) error {
+	w := wire.NewWriter()
+	w.WriteUvarint(uint64(len(spent)))
+	for _, utxo := range spent {
+		w.WriteBytes(utxo.TxID)
+		w.WriteUvarint(uint64(utxo.OutIdx))
+		encodeUTXO(w, utxo)
+	}
+	return txn.Set(undoKey(hash), w.Bytes())
+}
+
+func getUndo(txn storage.Tx, hash []byte) ([]UTXO, error) {
+	data, err := txn.Get(undoKey(hash))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %x", ErrNoUndoData, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := wire.NewReader(data)
+	// 每个UTXO至少有交易ID、输出下标、金额、公钥哈希、高度和挖矿奖励标记6个字节
+	spent := make([]UTXO, r.ReadCount(6))
+	for i := range spent {
+		op := OutPoint{TxID: r.ReadBytes(), OutIdx: int(r.ReadUvarint())}
+		spent[i] = decodeUTXO(r, op)
+	}
+	if err = r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode undo data of block %x: %w", hash, err)
+	}
+	return spent, nil
+}
+
+// disconnectBlock 是connectBlock的逆操作，交易按相反的顺序断开
+// 区块创建的输出必须都还在UTXO集合中，否则说明UTXO集合和区块不一致
+func disconnectBlock(txn storage.Tx, block *Block, height int) error {
+	spent, err := getUndo(txn, block.Hash)
+	if err != nil {
+		return err
+	}
+	inputs := 0
+	for _, tx := range block.Transactions {
+		if !tx.IsBase() {
+			inputs += len(tx.Inputs)
+		}
+	}
+	if inputs != len(spent) {
+		return fmt.Errorf("%w: block %x spends %d outputs, undo data has %d", ErrCorruptChainState, block.Hash, inputs, len(spent))
+	}
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		for idx := range tx.Outputs {
+			key := utxoKey(OutPoint{TxID: tx.ID, OutIdx: idx})
+			if _, err = txn.Get(key); err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					err = fmt.Errorf("%w: output %x:%d of block %x is missing", ErrCorruptChainState, tx.ID, idx, block.Hash)
+				}
+				return err
+			}
+			if err = txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		if err = deleteAddressOutputs(txn, tx, height); err != nil {
+			return err
+		}
+	}
+	for _, utxo := range spent {
+		if err = putUTXO(txn, utxo); err != nil {
+			return err
+		}
+	}
+	if err = txn.Delete(undoKey(block.Hash)); err != nil {
+		return err
+	}
+	if err = txn.Delete(heightKey(height)); err != nil {
+		return err
+	}
+	return setInt(txn, constcoe.TipHeightKey, height-1)
+}
+
+// DisconnectTip 断开最新区块，恢复区块花费的UTXO，区块中除挖矿奖励以外的交易放回交易池
+// 区块头和区块体保留在数据库中，返回断开的区块
+func (bc *BlockChain) DisconnectTip() (*Block, error) {
+	var block *Block
+	var height int
+	err := bc.Database.Update(func(txn storage.Tx) error {
+		lastHash, err := txn.Get([]byte(constcoe.LHKey))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(lastHash, bc.LastHash) {
+			return fmt.Errorf("%w: database tip %x, memory tip %x", ErrTipMismatch, lastHash, bc.LastHash)
+		}
+		height, err = getTipHeight(txn)
+		if err != nil {
+			return err
+		}
+		if height == 0 {
+			return fmt.Errorf("%w: cannot disconnect the genesis block", ErrInvalidBlock)
+		}
+		block, err = getBlock(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		if err = disconnectBlock(txn, block, height); err != nil {
+			return err
+		}
+		return txn.Set([]byte(constcoe.LHKey), block.PrevHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	bc.LastHash = block.PrevHash
+	publish(Event{Type: BlockDisconnected, Block: block, Height: height})
+
+	if err = ReturnTransactions(block.Transactions); err != nil {
+		return block, fmt.Errorf("return transactions to the pool: %w", err)
+	}
+	return block, nil
+}
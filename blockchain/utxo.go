@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"strconv"
+	"strings"
+)
+
+// OutPoint 交易输出的位置，由交易ID和输出下标组成
+type OutPoint struct {
+	TxID   []byte
+	OutIdx int
+}
+
+// String 格式化为 txid:idx
+func (op OutPoint) String() string {
+	return hex.EncodeToString(op.TxID) + ":" + strconv.Itoa(op.OutIdx)
+}
+
+// ParseOutPoint 解析 txid:idx 格式的字符串
+func ParseOutPoint(s string) (OutPoint, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return OutPoint{}, fmt.Errorf("invalid outpoint %q, want txid:idx", s)
+	}
+	txID, err := hex.DecodeString(parts[0])
+	if err != nil || len(txID) == 0 {
+		return OutPoint{}, fmt.Errorf("invalid txid in outpoint %q", s)
+	}
+	idx, err := strconv.Atoi(parts[1])
+	if err != nil || idx < 0 {
+		return OutPoint{}, fmt.Errorf("invalid output index in outpoint %q", s)
+	}
+	return OutPoint{TxID: txID, OutIdx: idx}, nil
+}
+
+// ParseOutPoints 解析逗号分隔的 txid:idx 列表
+func ParseOutPoints(s string) ([]OutPoint, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, errors.New("empty outpoint list")
+	}
+	points := make([]OutPoint, 0)
+	for _, item := range strings.Split(s, ",") {
+		op, err := ParseOutPoint(item)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, op)
+	}
+	return points, nil
+}
+
+// UTXO 未花费的交易输出
+type UTXO struct {
+	OutPoint
+	Output   transaction.TxOutput
+	Height   int  // 交易所在区块的高度
+	Coinbase bool // 是否是挖矿奖励交易的输出
+}
+
+// Value 交易输出的金额
+func (u UTXO) Value() int {
+	return u.Output.Value
+}
+
+// Mature 交易输出能否被高度为spendHeight的区块中的交易使用
+// 挖矿奖励要等待CoinbaseMaturity个区块，创世区块的金额是链的初始资金，可以直接使用
+func (u UTXO) Mature(spendHeight int) bool {
+	if !u.Coinbase || u.Height == 0 {
+		return true
+	}
+	return spendHeight-u.Height >= chaincfg.ActiveParams().CoinbaseMaturity
+}
+
+// FindUTXOList 根据公钥在UTXO集合中找出所有未花费的交易输出，每一个输出单独列出
+func (bc *BlockChain) FindUTXOList(pubKey []byte) ([]UTXO, error) {
+	utxos := make([]UTXO, 0)
+	pubKeyHash := utils.PublicKeyHash(pubKey)
+	err := bc.Database.View(func(txn storage.Tx) error {
+		return forEachUTXO(txn, func(_ []byte, utxo UTXO) error {
+			if bytes.Equal(utxo.Output.PubKeyHash, pubKeyHash) {
+				utxos = append(utxos, utxo)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
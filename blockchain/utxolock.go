@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/utils"
+	"os"
+	"sort"
+)
+
+// LockedOutputs 被锁定的交易输出，锁定后的输出不会被选币策略选中
+type LockedOutputs map[string]bool
+
+// LoadLockedOutputs 从文件中加载被锁定的交易输出
+func LoadLockedOutputs() (LockedOutputs, error) {
+	locked := make(LockedOutputs)
+	filename := config.Active().LockedUTXOFile
+	if !utils.FileExists(filename) {
+		return locked, nil
+	}
+	fileContent, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := gob.NewDecoder(bytes.NewBuffer(fileContent))
+	err = decoder.Decode(&locked)
+	if err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
+
+// SaveFile 保存被锁定的交易输出
+func (l LockedOutputs) SaveFile() error {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(l)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(config.Active().DataDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(config.Active().LockedUTXOFile, buffer.Bytes(), 0644)
+}
+
+func (l LockedOutputs) Lock(op OutPoint) {
+	l[op.String()] = true
+}
+
+func (l LockedOutputs) Unlock(op OutPoint) {
+	delete(l, op.String())
+}
+
+func (l LockedOutputs) IsLocked(op OutPoint) bool {
+	return l[op.String()]
+}
+
+// List 返回排序后的锁定列表
+func (l LockedOutputs) List() []string {
+	list := make([]string, 0, len(l))
+	for op := range l {
+		list = append(list, op)
+	}
+	sort.Strings(list)
+	return list
+}
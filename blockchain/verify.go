@@ -0,0 +1,289 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+)
+
+// 验证区块链的级别，每个级别包含前面级别的检查
+const (
+	// VerifyLevelRead 读取并解码区块
+	VerifyLevelRead = iota
+	// VerifyLevelBlock 区块哈希、工作量证明、merkle根和不依赖上下文的区块检查
+	VerifyLevelBlock
+	// VerifyLevelSignature 交易ID和交易签名
+	VerifyLevelSignature
+	// VerifyLevelUTXO 从创世区块重放UTXO集合：交易输入存在、未花费并且属于交易的公钥，金额、挖矿奖励、锁定时间和挖矿奖励成熟度正确
+	// 区块时间戳是后来加入的规则，只在区块加入区块链时检查，旧的链不会因此被认为损坏
+	VerifyLevelUTXO
+
+	DefaultVerifyLevel = VerifyLevelUTXO
+)
+
+// VerifyResult 验证区块链的结果，BadHash和UTXOErr都为空表示没有发现问题
+type VerifyResult struct {
+	Height    int    // 最新区块的高度，区块头无法读取时为-1
+	Level     int    // 实际使用的验证级别，裁剪过的区块链无法重放UTXO集合
+	Checked   int    // 做了区块检查的区块个数
+	BadHash   []byte // 高度最低的有问题的区块
+	BadHeight int    // 区块头无法读取时为-1
+	Err       error  // 有问题的原因
+	GoodHash  []byte // 有问题的区块的上一个区块，回退时作为新的最新区块，为空表示无法回退
+	UTXOErr   error  // 区块都没有问题，但数据库中的UTXO集合和重放的结果不一致，可以通过Reindex重建
+}
+
+// OK 没有发现问题
+func (r *VerifyResult) OK() bool {
+	return r.BadHash == nil && r.UTXOErr == nil
+}
+
+// VerifyChain 按高度从创世区块开始重新验证数据库中的区块链，报告第一个有问题的区块
+// depth不为0时只对最新的depth个区块做区块和签名检查，UTXO集合总是从创世区块开始重放，重放的结果和数据库中的UTXO集合比较
+// 区块体被裁剪的区块只能检查区块头链接，并且最多检查到VerifyLevelSignature
+// 返回的error表示验证过程本身失败，区块的问题通过VerifyResult返回
+func (bc *BlockChain) VerifyChain(depth, level int) (*VerifyResult, error) {
+	if level < VerifyLevelRead || level > VerifyLevelUTXO {
+		return nil, fmt.Errorf("verify level %d out of range [%d, %d]", level, VerifyLevelRead, VerifyLevelUTXO)
+	}
+	if depth < 0 {
+		return nil, fmt.Errorf("verify depth %d can not be negative", depth)
+	}
+	ogPrevHash, err := bc.BackOgPrevHash()
+	if err != nil {
+		return nil, err
+	}
+
+	// 先只读取区块头得到整条链，区块头无法读取时不知道区块的高度，也无法回退
+	hashes := make([][]byte, 0)
+	iter := bc.HeaderIterator()
+	for {
+		hash, header, err := iter.Next()
+		if err != nil {
+			return &VerifyResult{Height: -1, BadHash: iter.CurrentHash, BadHeight: -1, Err: err}, nil
+		}
+		hashes = append(hashes, hash)
+		if bytes.Equal(header.PrevHash, ogPrevHash) {
+			break
+		}
+	}
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return nil, err
+	}
+	if pruneHeight > 0 && level > VerifyLevelSignature {
+		level = VerifyLevelSignature
+	}
+	result := &VerifyResult{Height: len(hashes) - 1, Level: level}
+	checkFrom := pruneHeight
+	if depth > 0 && depth < len(hashes) {
+		checkFrom = max(checkFrom, len(hashes)-depth)
+	}
+	start := checkFrom
+	if level >= VerifyLevelUTXO {
+		start = 0
+	}
+	replay := newUTXOReplay()
+	for height := start; height < len(hashes); height++ {
+		hash := hashes[height]
+		err = func() error {
+			block, err := bc.GetBlock(hash)
+			if err != nil {
+				return err
+			}
+			if height >= checkFrom {
+				result.Checked++
+				migrated := false
+				err = bc.Database.View(func(txn storage.Tx) error {
+					migrated, err = isMigratedBlock(txn, hash)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				if err = verifyBlock(block, hash, level, migrated); err != nil {
+					return err
+				}
+			}
+			if level >= VerifyLevelUTXO {
+				median, err := bc.MedianTimePast(block.PrevHash)
+				if err != nil {
+					return err
+				}
+				return replay.connect(block, height, median)
+			}
+			return nil
+		}()
+		if err != nil {
+			result.BadHash = hash
+			result.BadHeight = height
+			result.Err = err
+			if height > 0 {
+				result.GoodHash = hashes[height-1]
+			}
+			return result, nil
+		}
+	}
+	if level >= VerifyLevelUTXO {
+		diff, err := bc.diffUTXOSet(replay.utxos)
+		if err != nil {
+			return nil, err
+		}
+		if diff.missing+diff.extra+diff.different > 0 {
+			result.UTXOErr = fmt.Errorf("stored utxo set does not match the blocks: %d missing, %d extra, %d different", diff.missing, diff.extra, diff.different)
+		}
+	}
+	return result, nil
+}
+
+// utxoDiff 数据库中的UTXO集合和重放得到的UTXO集合的差异
+type utxoDiff struct {
+	missing   int // 数据库中缺少的输出
+	extra     int // 数据库中多出的输出
+	different int // 金额、公钥哈希、高度或者是否挖矿奖励不一致的输出
+}
+
+// diffUTXOSet 比较数据库中的UTXO集合和重放得到的UTXO集合
+func (bc *BlockChain) diffUTXOSet(replayed map[string]UTXO) (utxoDiff, error) {
+	var diff utxoDiff
+	seen := make(map[string]bool, len(replayed))
+	err := bc.Database.View(func(txn storage.Tx) error {
+		return forEachUTXO(txn, func(_ []byte, stored UTXO) error {
+			key := stored.String()
+			seen[key] = true
+			utxo, ok := replayed[key]
+			if !ok {
+				diff.extra++
+				return nil
+			}
+			if utxo.Value() != stored.Value() || !bytes.Equal(utxo.Output.PubKeyHash, stored.Output.PubKeyHash) ||
+				utxo.Height != stored.Height || utxo.Coinbase != stored.Coinbase {
+				diff.different++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return diff, err
+	}
+	for key := range replayed {
+		if !seen[key] {
+			diff.missing++
+		}
+	}
+	return diff, nil
+}
+
+// verifyBlock 不依赖其他区块的检查，migrated表示区块是迁移时记录的旧区块
+func verifyBlock(block *Block, hash []byte, level int, migrated bool) error {
+	if level < VerifyLevelBlock {
+		return nil
+	}
+	// 旧区块的哈希不能由区块头计算出来，只信任迁移时记录的旧区块
+	if !migrated || block.Version != LegacyHeaderVersion {
+		recomputed := *block
+		recomputed.SetHash()
+		if !bytes.Equal(recomputed.Hash, hash) {
+			return fmt.Errorf("%w: block %x hashes to %x", ErrInvalidBlock, hash, recomputed.Hash)
+		}
+		if !block.ValidatePoW() {
+			return fmt.Errorf("%w: block %x", ErrInvalidPoW, hash)
+		}
+	}
+	if err := CheckBlockSanity(block); err != nil {
+		return err
+	}
+
+	if level < VerifyLevelSignature {
+		return nil
+	}
+	for _, tx := range block.Transactions {
+		if migrated && tx.Version == transaction.LegacyVersion {
+			continue
+		}
+		if err := checkTransactionSignature(tx); err != nil {
+			return fmt.Errorf("block %x: %w", hash, err)
+		}
+	}
+	return nil
+}
+
+// utxoReplay 按高度依次连接区块，在内存中重建UTXO集合
+type utxoReplay struct {
+	utxos map[string]UTXO
+}
+
+func newUTXOReplay() *utxoReplay {
+	return &utxoReplay{utxos: make(map[string]UTXO)}
+}
+
+// connect 用和连接区块相同的规则检查区块中的交易，然后更新UTXO集合，median是上一个区块的MedianTimePast
+func (r *utxoReplay) connect(block *Block, height int, median int64) error {
+	_, err := connectTransactions(r, block, height, median)
+	return err
+}
+
+func (r *utxoReplay) spend(op OutPoint) (UTXO, bool, error) {
+	utxo, ok := r.utxos[op.String()]
+	delete(r.utxos, op.String())
+	return utxo, ok, nil
+}
+
+func (r *utxoReplay) add(tx *transaction.Transaction, height int) error {
+	r.addOutputs(tx, height)
+	return nil
+}
+
+func (r *utxoReplay) addOutputs(tx *transaction.Transaction, height int) {
+	for idx, out := range tx.Outputs {
+		op := OutPoint{TxID: tx.ID, OutIdx: idx}
+		r.utxos[op.String()] = UTXO{OutPoint: op, Output: out, Height: height, Coinbase: tx.IsBase()}
+	}
+}
+
+// RollbackTo 把最新区块回退到hash，删除之后的区块，hash必须在当前的链上
+// 回退后由区块体重建UTXO集合和高度索引，所以裁剪过的区块链不能回退
+func (bc *BlockChain) RollbackTo(hash []byte) error {
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return err
+	}
+	if pruneHeight > 0 {
+		return fmt.Errorf("%w: can not roll back a pruned chain", ErrBlockPruned)
+	}
+	err = bc.Database.Update(func(txn storage.Tx) error {
+		if _, err := getHeader(txn, hash); err != nil {
+			return err
+		}
+		current := bc.LastHash
+		for !bytes.Equal(current, hash) {
+			header, err := getHeader(txn, current)
+			if err != nil {
+				return fmt.Errorf("block %x is not an ancestor of the tip: %w", hash, err)
+			}
+			if err = txn.Delete(headerKey(current)); err != nil {
+				return err
+			}
+			if err = txn.Delete(bodyKey(current)); err != nil {
+				return err
+			}
+			current = header.PrevHash
+		}
+		// UTXO集合重建完成之前把数据库标记为旧版本，中断后再次打开时会重新重建
+		if err := setSchemaVersion(txn, stateRebuildVersion); err != nil {
+			return err
+		}
+		return txn.Set([]byte(constcoe.LHKey), hash)
+	})
+	if err != nil {
+		return err
+	}
+	bc.LastHash = hash
+	return bc.Reindex()
+}
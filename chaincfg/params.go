@@ -0,0 +1,156 @@
+package chaincfg
+
+import (
+	"fmt"
+)
+
+const (
+	MainNet = "mainnet"
+	TestNet = "testnet"
+	RegTest = "regtest"
+)
+
+// Params 一条链的全部参数，不同的网络使用不同的参数，互相之间的区块和地址都不通用
+type Params struct {
+	Name string
+
+	// 创世区块
+	GenesisMessage string // 创世区块的PrevHash
+	GenesisReward  int    // 创世区块给创建者的金额
+
+	// 地址版本号
+	AddressVersion byte
+
+	// 工作量证明难度，目标值为 1 << (256 - Difficulty)
+	Difficulty int
+
+	// 出块奖励，每隔 SubsidyHalvingInterval 个区块减半
+	BlockSubsidy           int
+	SubsidyHalvingInterval int
+	// 挖矿奖励交易的输出在CoinbaseMaturity个区块之后才能使用，避免链重组后花费的奖励失效
+	CoinbaseMaturity int
+
+	// 共识限制，超出限制的区块和交易无效
+	MaxBlockSize  int // 区块编码后的最大字节数
+	MaxTxSize     int // 交易编码后的最大字节数
+	MaxTxInputs   int
+	MaxTxOutputs  int
+	DustThreshold int // 交易输出的最小金额，挖矿奖励交易除外
+
+	// 区块时间戳要大于前MedianTimeBlocks个区块时间戳的中位数，并且不能超过当前时间MaxFutureBlockTime秒
+	MedianTimeBlocks   int
+	MaxFutureBlockTime int64
+
+	// 默认端口
+	DefaultPort int
+	RPCPort     int
+
+	// 数据目录名，位于根目录下，不同网络的数据互相隔离
+	DataDir string
+}
+
+// Subsidy 根据区块高度计算出块奖励
+func (p *Params) Subsidy(height int) int {
+	if p.SubsidyHalvingInterval <= 0 {
+		return p.BlockSubsidy
+	}
+	halvings := height / p.SubsidyHalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.BlockSubsidy >> uint(halvings)
+}
+
+var MainNetParams = Params{
+	Name:                   MainNet,
+	GenesisMessage:         "limitZhang is awesome!",
+	GenesisReward:          1000,
+	AddressVersion:         0x00,
+	Difficulty:             12,
+	BlockSubsidy:           50,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       100,
+	MaxBlockSize:           1000000,
+	MaxTxSize:              100000,
+	MaxTxInputs:            1000,
+	MaxTxOutputs:           1000,
+	DustThreshold:          1,
+	MedianTimeBlocks:       11,
+	MaxFutureBlockTime:     2 * 60 * 60,
+	DefaultPort:            9527,
+	RPCPort:                9528,
+	DataDir:                "mainnet",
+}
+
+var TestNetParams = Params{
+	Name:                   TestNet,
+	GenesisMessage:         "limitZhang is awesome on testnet!",
+	GenesisReward:          1000,
+	AddressVersion:         0x6f,
+	Difficulty:             12,
+	BlockSubsidy:           50,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       100,
+	MaxBlockSize:           1000000,
+	MaxTxSize:              100000,
+	MaxTxInputs:            1000,
+	MaxTxOutputs:           1000,
+	DustThreshold:          1,
+	MedianTimeBlocks:       11,
+	MaxFutureBlockTime:     2 * 60 * 60,
+	DefaultPort:            19527,
+	RPCPort:                19528,
+	DataDir:                "testnet",
+}
+
+// RegTestParams 用于本地测试，工作量证明几乎没有难度
+var RegTestParams = Params{
+	Name:                   RegTest,
+	GenesisMessage:         "limitZhang is awesome on regtest!",
+	GenesisReward:          1000,
+	AddressVersion:         0x6f,
+	Difficulty:             1,
+	BlockSubsidy:           50,
+	SubsidyHalvingInterval: 150,
+	CoinbaseMaturity:       100,
+	MaxBlockSize:           1000000,
+	MaxTxSize:              100000,
+	MaxTxInputs:            1000,
+	MaxTxOutputs:           1000,
+	DustThreshold:          1,
+	MedianTimeBlocks:       11,
+	MaxFutureBlockTime:     2 * 60 * 60,
+	DefaultPort:            29527,
+	RPCPort:                29528,
+	DataDir:                "regtest",
+}
+
+var activeParams = &MainNetParams
+
+// ActiveParams 当前使用的网络参数
+func ActiveParams() *Params {
+	return activeParams
+}
+
+// ParamsByName 根据网络名字获取参数
+func ParamsByName(name string) (*Params, error) {
+	switch name {
+	case MainNet, "":
+		return &MainNetParams, nil
+	case TestNet:
+		return &TestNetParams, nil
+	case RegTest:
+		return &RegTestParams, nil
+	}
+	return nil, fmt.Errorf("unknown network %q, use mainnet, testnet or regtest", name)
+}
+
+// SelectNetwork 切换当前使用的网络
+func SelectNetwork(name string) error {
+	params, err := ParamsByName(name)
+	if err != nil {
+		return err
+	}
+	activeParams = params
+	return nil
+}
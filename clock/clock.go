@@ -0,0 +1,74 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 获取当前时间，创建区块和验证区块时间戳都使用当前的Clock
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Mock 手动控制的时间，测试和regtest中使用，时间只在调用Set或Add时变化
+type Mock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewMock(now time.Time) *Mock {
+	return &Mock{now: now}
+}
+
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Mock) Set(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+func (m *Mock) Add(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+var (
+	mu     sync.RWMutex
+	active Clock = systemClock{}
+)
+
+// Now 当前Clock的时间
+func Now() time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+	return active.Now()
+}
+
+// Set 切换当前使用的Clock，为空时使用系统时间
+func Set(c Clock) {
+	mu.Lock()
+	defer mu.Unlock()
+	if c == nil {
+		c = systemClock{}
+	}
+	active = c
+}
+
+// Active 当前使用的Clock
+func Active() Clock {
+	mu.RLock()
+	defer mu.RUnlock()
+	return active
+}
@@ -2,15 +2,28 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/limitzhang87/goblockchain/address"
 	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/clock"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/spv"
 	"github.com/limitzhang87/goblockchain/utils"
 	"github.com/limitzhang87/goblockchain/wallet"
+	"github.com/limitzhang87/goblockchain/webhook"
+	"io"
+	"math"
+	"net/http"
 	"os"
-	"runtime"
+	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,178 +38,599 @@ const (
 	FlagBlockChainInfo    = "blockchaininfo"
 	FlagSend              = "send"
 	FlagSendByRefName     = "sendbyrefname"
+	FlagSendMany          = "sendmany"
 	FlagMine              = "mine"
+	FlagValidateAddress   = "validateaddress"
+	FlagListUnspent       = "listunspent"
+	FlagLockUnspent       = "lockunspent"
+	FlagUnlockUnspent     = "unlockunspent"
+	FlagListLockUnspent   = "listlockunspent"
+	FlagSPVServer         = "spvserver"
+	FlagSPVBalance        = "spvbalance"
+	FlagGetMerkleProof    = "getmerkleproof"
+	FlagVerifyMerkleProof = "verifymerkleproof"
+	FlagVerifyChain       = "verifychain"
+	FlagExportChain       = "exportchain"
+	FlagImportChain       = "importchain"
+	FlagDumpUTXOSet       = "dumputxoset"
+	FlagLoadUTXOSet       = "loadutxoset"
+	FlagInvalidateBlock   = "invalidateblock"
+	FlagReconsiderBlock   = "reconsiderblock"
+	FlagDaemon            = "daemon"
+	FlagWatchAddress      = "watchaddress"
+	FlagUnwatchAddress    = "unwatchaddress"
+	FlagListWatches       = "listwatches"
 )
 
+// importProgressInterval 导入区块时每隔多少个区块输出一次进度
+const importProgressInterval = 100
+
+// CommandLine 命令行，零值在当前进程中执行命令并输出到标准输出
+// 守护进程为每个客户端的命令创建一个CommandLine，输出写给客户端，区块链由守护进程打开
 type CommandLine struct {
+	out      io.Writer
+	ctx      context.Context
+	dir      string                                 // 相对路径的起点，为空时使用当前目录
+	open     func() (*blockchain.BlockChain, error) // 为空时使用ContinueBlockChain
+	remote   bool                                   // 在守护进程中执行，不能读取标准输入
+	mockTime int64                                  // -mocktime，为0时使用系统时间
 }
 
 func (cli *CommandLine) printUsage() {
-	fmt.Println("Welcome to Limit's tiny blockchain system, usage is as follows:")
-	fmt.Println("---------------------------------------------------------------------------------------------------------------------------------------------------------")
-	fmt.Println("All you need is to first create a wallet.")
-	fmt.Println("And then you can use the wallet address to create a blockchain and declare the owner.")
-	fmt.Println("Make transactions to expand the blockchain.")
-	fmt.Println("In addition, don't forget to run mine function after transatcions are collected.")
-	fmt.Println("---------------------------------------------------------------------------------------------------------------------------------------------------------")
-	fmt.Println("createwallet -refname REFNAME                       ----> Creates and save a wallet. The refname is optional.")
-	fmt.Println("walletinfo -refname NAME -address Address           ----> Print the information of a wallet. At least one of the refname and address is required.")
-	fmt.Println("walletsupdate                                       ----> Registrate and update all the wallets (especially when you have added an existed .wlt file).")
-	fmt.Println("walletslist                                         ----> List all the wallets found (make sure you have run walletsupdate first).")
-	fmt.Println("walletbindrefname                                   ----> Bind address to refname.")
-	fmt.Println("createblockchain -refname NAME -address ADDRESS     ----> Creates a blockchain with the owner you input (address or refname).")
-	fmt.Println("balance -refname NAME -address ADDRESS              ----> Back the balance of a wallet using the address (or refname) you input.")
-	fmt.Println("blockchaininfo                                      ----> Prints the blocks in the chain.")
-	fmt.Println("send -from FROADDRESS -to TOADDRESS -amount AMOUNT  ----> Make a transaction and put it into candidate block.")
-	fmt.Println("sendbyrefname -from NAME1 -to NAME2 -amount AMOUNT  ----> Make a transaction and put it into candidate block using refname.")
-	fmt.Println("mine                                                ----> Mine and add a block to the chain.")
-	fmt.Println("---------------------------------------------------------------------------------------------------------------------------------------------------------")
-}
-
-func (cli *CommandLine) validateArgs() {
-	if len(os.Args) < 2 {
+	fmt.Fprintln(cli.out, "Welcome to Limit's tiny blockchain system, usage is as follows:")
+	fmt.Fprintln(cli.out, "---------------------------------------------------------------------------------------------------------------------------------------------------------")
+	fmt.Fprintln(cli.out, "All you need is to first create a wallet.")
+	fmt.Fprintln(cli.out, "And then you can use the wallet address to create a blockchain and declare the owner.")
+	fmt.Fprintln(cli.out, "Make transactions to expand the blockchain.")
+	fmt.Fprintln(cli.out, "In addition, don't forget to run mine function after transatcions are collected.")
+	fmt.Fprintln(cli.out, "---------------------------------------------------------------------------------------------------------------------------------------------------------")
+	fmt.Fprintln(cli.out, "[-network mainnet|testnet|regtest] COMMAND          ----> Choose the network before the command, mainnet is the default.")
+	fmt.Fprintln(cli.out, "[-datadir DIR] [-conf FILE] COMMAND                 ----> Choose the data directory (~/.goblockchain) and config file (DIR/goblockchain.toml).")
+	fmt.Fprintln(cli.out, "                                                    ----> GOBLOCKCHAIN_DATADIR, GOBLOCKCHAIN_NETWORK and GOBLOCKCHAIN_CONFIG override the config file.")
+	fmt.Fprintln(cli.out, "[-mocktime UNIXTIME] COMMAND                        ----> Use a fixed current time to create and check blocks, only for regtest.")
+	fmt.Fprintln(cli.out, "createwallet -refname REFNAME                       ----> Creates and save a wallet. The refname is optional.")
+	fmt.Fprintln(cli.out, "walletinfo -refname NAME -address Address           ----> Print the information of a wallet. At least one of the refname and address is required.")
+	fmt.Fprintln(cli.out, "walletsupdate                                       ----> Registrate and update all the wallets (especially when you have added an existed .wlt file).")
+	fmt.Fprintln(cli.out, "walletslist                                         ----> List all the wallets found (make sure you have run walletsupdate first).")
+	fmt.Fprintln(cli.out, "walletbindrefname                                   ----> Bind address to refname.")
+	fmt.Fprintln(cli.out, "createblockchain -refname NAME -address ADDRESS     ----> Creates a blockchain with the owner you input (address or refname).")
+	fmt.Fprintln(cli.out, "balance -refname NAME -address ADDRESS              ----> Back the balance of a wallet using the address (or refname) you input.")
+	fmt.Fprintln(cli.out, "blockchaininfo                                      ----> Prints the blocks in the chain.")
+	fmt.Fprintln(cli.out, "send -from FROADDRESS -to TOADDRESS -amount AMOUNT  ----> Make a transaction and put it into candidate block.")
+	fmt.Fprintln(cli.out, "     [-strategy largest|smallest|bnb|random]        ----> Choose the coin selection strategy, largest is the default.")
+	fmt.Fprintln(cli.out, "     [-utxos TXID:IDX,TXID:IDX]                     ----> Spend exactly the outputs you input.")
+	fmt.Fprintln(cli.out, "     [-locktime HEIGHT|UNIXTIME]                    ----> The transaction can only be mined after the height or time.")
+	fmt.Fprintln(cli.out, "sendbyrefname -from NAME1 -to NAME2 -amount AMOUNT  ----> Make a transaction and put it into candidate block using refname.")
+	fmt.Fprintln(cli.out, "sendmany -from FROMADDRESS -file FILE [-fee FEE]    ----> Pay every address->amount pair in a json or csv file with one transaction.")
+	fmt.Fprintln(cli.out, "     [-strategy STRATEGY] [-utxos TXID:IDX] [-yes]  ----> Same coin control as send, -yes skips the confirmation.")
+	fmt.Fprintln(cli.out, "mine [-threads N] [-address ADDRESS]                ----> Mine and add a block to the chain with N goroutines, Ctrl+C to abort.")
+	fmt.Fprintln(cli.out, "     [-continuous] [-interval D] [-blocks N]        ----> Keep mining blocks paying the reward to the address until Ctrl+C or N blocks.")
+	fmt.Fprintln(cli.out, "validateaddress -address ADDRESS                    ----> Check the version, length and checksum of an address.")
+	fmt.Fprintln(cli.out, "listunspent -refname NAME -address ADDRESS          ----> List the unspent outputs of a wallet.")
+	fmt.Fprintln(cli.out, "lockunspent -utxos TXID:IDX,TXID:IDX                ----> Lock outputs so that they will not be spent.")
+	fmt.Fprintln(cli.out, "unlockunspent -utxos TXID:IDX,TXID:IDX              ----> Unlock outputs locked before.")
+	fmt.Fprintln(cli.out, "listlockunspent                                     ----> List all the locked outputs.")
+	fmt.Fprintln(cli.out, "spvserver [-listen HOST:PORT]                       ----> Serve block headers and merkle proofs to light clients.")
+	fmt.Fprintln(cli.out, "spvbalance -address ADDRESS [-node URL]             ----> Sync headers only and show the balance proved by merkle proofs.")
+	fmt.Fprintln(cli.out, "     [-minconf N]                                   ----> Only count outputs with at least N confirmations as confirmed.")
+	fmt.Fprintln(cli.out, "getmerkleproof -tx TXID,TXID                        ----> Print a json proof that the transactions (in the same block) are in the chain.")
+	fmt.Fprintln(cli.out, "verifymerkleproof -proof FILE [-root MERKLEROOT]    ----> Verify a proof against the local block header, or against the merkle root you input.")
+	fmt.Fprintln(cli.out, "verifychain [-depth N] [-level 0-3] [-rollback]     ----> Check the stored blocks, -rollback moves the tip back to the last good block.")
+	fmt.Fprintln(cli.out, "exportchain -file FILE [-from H] [-to H]            ----> Write the blocks in height order to a bootstrap file.")
+	fmt.Fprintln(cli.out, "importchain -file FILE                              ----> Validate and append the blocks in a bootstrap file, run again to resume after a failure.")
+	fmt.Fprintln(cli.out, "dumputxoset -file FILE                              ----> Write the block headers and the utxo set to a snapshot file and print its hash.")
+	fmt.Fprintln(cli.out, "loadutxoset -file FILE [-hash HASH]                 ----> Start a new chain from a snapshot, check the hash against a trusted one if given.")
+	fmt.Fprintln(cli.out, "daemon                                              ----> Keep the chain open and run the commands of other processes one at a time over DATADIR/NETWORK/daemon.sock.")
+	fmt.Fprintln(cli.out, "                                                    ----> While a daemon is running all the other commands are sent to it, so they never fight over the database.")
+	fmt.Fprintln(cli.out, "       [-websocket HOST:PORT]                       ----> Push block connected/disconnected and pool tx accepted/removed events to ws://HOST:PORT/events.")
+	fmt.Fprintln(cli.out, "       [-notify tcp://HOST:PORT|ipc://PATH]         ----> Publish the same events on a ZeroMQ style socket, topics like hashblockconnected and rawtxaccepted.")
+	fmt.Fprintln(cli.out, "watchaddress -address ADDRESS -url URL              ----> POST a signed json to the url when an output paying the address enters the pool,")
+	fmt.Fprintln(cli.out, "     [-confirmations N]                             ----> gets its first confirmation and reaches N (6) confirmations. Failed posts are retried.")
+	fmt.Fprintln(cli.out, "unwatchaddress -id ID                               ----> Stop watching and drop the notifications not sent yet.")
+	fmt.Fprintln(cli.out, "listwatches                                         ----> List the watched addresses and their queued notifications.")
+	fmt.Fprintln(cli.out, "invalidateblock -hash HASH                          ----> Mark a block invalid and disconnect it and the blocks after it, their transactions go back to the pool.")
+	fmt.Fprintln(cli.out, "reconsiderblock -hash HASH                          ----> Remove the invalid mark and reconnect the blocks disconnected by invalidateblock.")
+	fmt.Fprintln(cli.out, "                                                    ----> Set prune = N (at least 10) in the config file to keep only the latest N block bodies.")
+	fmt.Fprintln(cli.out, "                                                    ----> Set backend = \"badger\" (default) or \"bolt\" in the config file to choose the block storage.")
+	fmt.Fprintln(cli.out, "---------------------------------------------------------------------------------------------------------------------------------------------------------")
+}
+
+func (cli *CommandLine) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
-		runtime.Goexit()
+		os.Exit(1)
+	}
+}
+
+// parseGlobalFlags 解析子命令前面的全局参数，返回子命令及其参数
+func (cli *CommandLine) parseGlobalFlags(args []string) ([]string, error) {
+	globalCmd := flag.NewFlagSet("goblockchain", flag.ExitOnError)
+	configFile := globalCmd.String("conf", "", "The config file, default is goblockchain.toml in the data directory")
+	network := globalCmd.String("network", "", "The network to use: mainnet, testnet or regtest")
+	dataDir := globalCmd.String("datadir", "", "The data directory, default is ~/.goblockchain")
+	mockTime := globalCmd.Int64("mocktime", 0, "Use a fixed unix time as the current time, only for regtest")
+	err := globalCmd.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load(config.Options{ConfigFile: *configFile, Network: *network, DataDir: *dataDir})
+	if err != nil {
+		return nil, err
+	}
+	err = config.SetActive(cfg)
+	if err != nil {
+		return nil, err
 	}
+	if *mockTime != 0 {
+		if cfg.Network != chaincfg.RegTest {
+			return nil, fmt.Errorf("-mocktime is only allowed on %s, not %s", chaincfg.RegTest, cfg.Network)
+		}
+		clock.Set(clock.NewMock(time.Unix(*mockTime, 0)))
+		cli.mockTime = *mockTime
+	}
+	return globalCmd.Args(), nil
 }
 
+// Run 执行命令，是否退出进程只在这里决定
 func (cli *CommandLine) Run() {
-	cli.validateArgs()
-
-	createBlockchainCmd := flag.NewFlagSet(FlagCreateBlockchain, flag.ExitOnError)
-	createWalletCmd := flag.NewFlagSet(FlagCreateWallet, flag.ExitOnError)
-	walletInfoCmd := flag.NewFlagSet(FlagWalletInfo, flag.ExitOnError)
-	walletBindRefNameCmd := flag.NewFlagSet(FlagWalletBindRefName, flag.ExitOnError)
-	balanceCmd := flag.NewFlagSet(FlagBalance, flag.ExitOnError)
-	//getBlockCmd := flag.NewFlagSet(FlagBlockChainInfo, flag.ExitOnError)
-	sendCmd := flag.NewFlagSet(FlagSend, flag.ExitOnError)
-	//mineCmd := flag.NewFlagSet(FlagMine, flag.ExitOnError)
-
-	switch os.Args[1] {
+	cli.out = os.Stdout
+	cli.ctx = context.Background()
+	args, err := cli.parseGlobalFlags(os.Args[1:])
+	if err == nil {
+		cli.validateArgs(args)
+		err = cli.runOrForward(args)
+	}
+	if err != nil {
+		fmt.Fprintln(cli.out, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func (cli *CommandLine) run(args []string) error {
+	createBlockchainCmd := cli.newFlagSet(FlagCreateBlockchain)
+	createWalletCmd := cli.newFlagSet(FlagCreateWallet)
+	walletInfoCmd := cli.newFlagSet(FlagWalletInfo)
+	walletBindRefNameCmd := cli.newFlagSet(FlagWalletBindRefName)
+	balanceCmd := cli.newFlagSet(FlagBalance)
+	//getBlockCmd := cli.newFlagSet(FlagBlockChainInfo)
+	sendCmd := cli.newFlagSet(FlagSend)
+	sendManyCmd := cli.newFlagSet(FlagSendMany)
+	validateAddressCmd := cli.newFlagSet(FlagValidateAddress)
+	listUnspentCmd := cli.newFlagSet(FlagListUnspent)
+	lockUnspentCmd := cli.newFlagSet(FlagLockUnspent)
+	mineCmd := cli.newFlagSet(FlagMine)
+	spvServerCmd := cli.newFlagSet(FlagSPVServer)
+	spvBalanceCmd := cli.newFlagSet(FlagSPVBalance)
+	getMerkleProofCmd := cli.newFlagSet(FlagGetMerkleProof)
+	verifyMerkleProofCmd := cli.newFlagSet(FlagVerifyMerkleProof)
+	verifyChainCmd := cli.newFlagSet(FlagVerifyChain)
+	exportChainCmd := cli.newFlagSet(FlagExportChain)
+	importChainCmd := cli.newFlagSet(FlagImportChain)
+	dumpUTXOSetCmd := cli.newFlagSet(FlagDumpUTXOSet)
+	loadUTXOSetCmd := cli.newFlagSet(FlagLoadUTXOSet)
+	invalidateBlockCmd := cli.newFlagSet(FlagInvalidateBlock)
+	reconsiderBlockCmd := cli.newFlagSet(FlagReconsiderBlock)
+	daemonCmd := cli.newFlagSet(FlagDaemon)
+	watchAddressCmd := cli.newFlagSet(FlagWatchAddress)
+	unwatchAddressCmd := cli.newFlagSet(FlagUnwatchAddress)
+
+	switch args[0] {
 	case FlagCreateBlockchain:
 		refName := createBlockchainCmd.String("refname", "", "The refName refer to the owner of blockchain")
-		err := createBlockchainCmd.Parse(os.Args[2:]) // 解析子参数后面的其他参数address， 并传给createBlockchainAddress(这个是一个指针)
-		utils.Handle(err)
+		address := createBlockchainCmd.String("address", "", "The address of the owner of blockchain")
+		err := createBlockchainCmd.Parse(args[1:]) // 解析子参数后面的其他参数address， 并传给createBlockchainAddress(这个是一个指针)
+		if err != nil {
+			return err
+		}
 
-		if len(*refName) == 0 {
-			fmt.Println("Please enter a valid refname")
-			return
+		if len(*refName) == 0 && len(*address) == 0 {
+			return errors.New("please enter a valid refname or address")
 		}
-		cli.createBlockchainRefName(*refName)
+		if len(*refName) != 0 {
+			return cli.createBlockchainRefName(*refName)
+		}
+		return cli.createBlockchain(*address)
 	case FlagCreateWallet:
 		refName := createWalletCmd.String("refname", "", "The refName refer to the owner of blockchain")
-		err := createWalletCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-		cli.createWallet(*refName)
+		err := createWalletCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.createWallet(*refName)
 	case FlagWalletInfo:
 		walletInfoAddress := walletInfoCmd.String("address", "", "The address of the wallet")
 		walletInfoRefName := walletInfoCmd.String("refname", "", "The refname of the wallet")
-		err := walletInfoCmd.Parse(os.Args[2:])
-		utils.Handle(err)
+		err := walletInfoCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
 
 		if len(*walletInfoRefName) == 0 && len(*walletInfoAddress) == 0 {
-			fmt.Println("Please enter a valid address or refname")
-			return
+			return errors.New("please enter a valid address or refname")
 		}
-		cli.walletInfo(*walletInfoAddress, *walletInfoRefName)
+		return cli.walletInfo(*walletInfoAddress, *walletInfoRefName)
 	case FlagWalletsUpdate:
-		cli.walletsUpdate()
+		return cli.walletsUpdate()
 	case FlagWalletsList:
-		cli.walletsList()
+		return cli.walletsList()
 	case FlagWalletBindRefName:
 		address := walletBindRefNameCmd.String("address", "", "The address of the wallet")
 		refName := walletBindRefNameCmd.String("refname", "", "The refname of the wallet")
-		err := walletBindRefNameCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-		if len(*refName) == 0 && len(*address) == 0 {
-			fmt.Println("Please enter a valid address or refname")
-			return
+		err := walletBindRefNameCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*refName) == 0 || len(*address) == 0 {
+			return errors.New("please enter a valid address and refname")
 		}
-		cli.walletBindRefName(*address, *refName)
+		return cli.walletBindRefName(*address, *refName)
 	case FlagBalance:
 		address := balanceCmd.String("address", "", "The address of the wallet")
 		refName := balanceCmd.String("refname", "", "Who need to get balance amount")
-		err := balanceCmd.Parse(os.Args[2:])
-		utils.Handle(err)
+		err := balanceCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
 		if len(*refName) == 0 && len(*address) == 0 {
-			fmt.Println("Please enter a valid refName or address")
-			return
+			return errors.New("please enter a valid refName or address")
 		}
 		if len(*refName) != 0 {
-			cli.balanceRefName(*refName)
-		} else {
-			cli.balance(*address)
+			return cli.balanceRefName(*refName)
 		}
+		return cli.balance(*address)
 	case FlagBlockChainInfo:
-		cli.getBlockchainInfo()
+		return cli.getBlockchainInfo()
 	case FlagSend:
 		sendFromAddress := sendCmd.String("from", "", "Source address")
 		sendToAddress := sendCmd.String("to", "", "Destination address")
 		sendAmount := sendCmd.Int("amount", 0, "Amount to send")
-		err := sendCmd.Parse(os.Args[2:])
-		utils.Handle(err)
+		sendStrategy := sendCmd.String("strategy", "", "Coin selection strategy: largest, smallest, bnb or random")
+		sendUTXOs := sendCmd.String("utxos", "", "Outputs to spend, in the form of txid:idx,txid:idx")
+		sendLockTime := sendCmd.Uint("locktime", 0, "Block height (or unix time if >= 500000000) before which the transaction can not be mined")
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
 		if len(*sendFromAddress) == 0 {
-			fmt.Println("Please enter a valid from address")
+			return errors.New("please enter a valid from address")
 		}
 		if len(*sendToAddress) == 0 {
-			fmt.Println("Please enter a valid to address")
+			return errors.New("please enter a valid to address")
 		}
 		if *sendAmount <= 0 {
-			fmt.Println("Please enter a valid amount")
+			return errors.New("please enter a valid amount")
+		}
+		control, err := cli.coinControl(*sendStrategy, *sendUTXOs, *sendLockTime)
+		if err != nil {
+			return err
 		}
-		cli.send(*sendFromAddress, *sendToAddress, *sendAmount)
+		return cli.send(*sendFromAddress, *sendToAddress, *sendAmount, control)
 	case FlagSendByRefName:
 		sendFromRefName := sendCmd.String("from", "", "Source refName")
 		sendToRefName := sendCmd.String("to", "", "Destination refName")
 		sendAmount := sendCmd.Int("amount", 0, "Amount to send")
-		err := sendCmd.Parse(os.Args[2:])
-		utils.Handle(err)
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
 		if len(*sendFromRefName) == 0 {
-			fmt.Println("Please enter a valid from address")
+			return errors.New("please enter a valid from refname")
 		}
 		if len(*sendToRefName) == 0 {
-			fmt.Println("Please enter a valid to address")
+			return errors.New("please enter a valid to refname")
 		}
 		if *sendAmount <= 0 {
-			fmt.Println("Please enter a valid amount")
+			return errors.New("please enter a valid amount")
+		}
+		return cli.sendByName(*sendFromRefName, *sendToRefName, *sendAmount)
+	case FlagSendMany:
+		sendFromAddress := sendManyCmd.String("from", "", "Source address")
+		sendFile := sendManyCmd.String("file", "", "Json or csv file of address->amount pairs")
+		sendFee := sendManyCmd.Int("fee", 0, "Transaction fee")
+		sendStrategy := sendManyCmd.String("strategy", "", "Coin selection strategy: largest, smallest, bnb or random")
+		sendUTXOs := sendManyCmd.String("utxos", "", "Outputs to spend, in the form of txid:idx,txid:idx")
+		sendYes := sendManyCmd.Bool("yes", false, "Sign without confirmation")
+		sendLockTime := sendManyCmd.Uint("locktime", 0, "Block height (or unix time if >= 500000000) before which the transaction can not be mined")
+		err := sendManyCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*sendFromAddress) == 0 || len(*sendFile) == 0 {
+			return errors.New("please enter a valid from address and payment file")
 		}
-		cli.sendByName(*sendFromRefName, *sendToRefName, *sendAmount)
+		control, err := cli.coinControl(*sendStrategy, *sendUTXOs, *sendLockTime)
+		if err != nil {
+			return err
+		}
+		return cli.sendMany(*sendFromAddress, *sendFile, *sendFee, control, *sendYes)
 	case FlagMine:
-		cli.mine()
+		threads := mineCmd.Int("threads", 0, "Number of mining goroutines, default is the number of CPUs")
+		address := mineCmd.String("address", "", "The address to receive the mining reward")
+		continuous := mineCmd.Bool("continuous", false, "Keep mining blocks until Ctrl+C")
+		interval := mineCmd.Duration("interval", 0, "Minimum time between two blocks in continuous mode")
+		blocks := mineCmd.Int("blocks", 0, "Stop after mining N blocks in continuous mode, 0 means no limit")
+		err := mineCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if *continuous {
+			if len(*address) == 0 {
+				return errors.New("please enter the reward address for continuous mining")
+			}
+			return cli.mineContinuous(*threads, *address, *interval, *blocks)
+		}
+		return cli.mine(*threads, *address)
+	case FlagValidateAddress:
+		address := validateAddressCmd.String("address", "", "The address to validate")
+		err := validateAddressCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.validateAddress(*address)
+	case FlagListUnspent:
+		address := listUnspentCmd.String("address", "", "The address of the wallet")
+		refName := listUnspentCmd.String("refname", "", "The refname of the wallet")
+		err := listUnspentCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*refName) == 0 && len(*address) == 0 {
+			return errors.New("please enter a valid refName or address")
+		}
+		if len(*refName) != 0 {
+			*address, err = cli.getAddressByRefName(*refName)
+			if err != nil {
+				return err
+			}
+		}
+		return cli.listUnspent(*address)
+	case FlagLockUnspent, FlagUnlockUnspent:
+		utxos := lockUnspentCmd.String("utxos", "", "Outputs to lock or unlock, in the form of txid:idx,txid:idx")
+		err := lockUnspentCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.lockUnspent(*utxos, args[0] == FlagLockUnspent)
+	case FlagListLockUnspent:
+		return cli.listLockUnspent()
+	case FlagSPVServer:
+		listen := spvServerCmd.String("listen", defaultRPCListen(), "The address to listen on")
+		err := spvServerCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.spvServer(*listen)
+	case FlagSPVBalance:
+		address := spvBalanceCmd.String("address", "", "The address to check")
+		node := spvBalanceCmd.String("node", "http://"+defaultRPCListen(), "The full node serving headers and proofs")
+		minConf := spvBalanceCmd.Int("minconf", 1, "Minimum confirmations of confirmed balance")
+		err := spvBalanceCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*address) == 0 {
+			return errors.New("please enter a valid address")
+		}
+		return cli.spvBalance(*address, *node, *minConf)
+	case FlagGetMerkleProof:
+		txIDs := getMerkleProofCmd.String("tx", "", "The transaction ids, in the form of txid,txid")
+		err := getMerkleProofCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*txIDs) == 0 {
+			return errors.New("please enter a valid transaction id")
+		}
+		return cli.getMerkleProof(*txIDs)
+	case FlagVerifyMerkleProof:
+		proofFile := verifyMerkleProofCmd.String("proof", "", "The json file printed by getmerkleproof")
+		root := verifyMerkleProofCmd.String("root", "", "The merkle root to verify against, the local block header is used if empty")
+		err := verifyMerkleProofCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*proofFile) == 0 {
+			return errors.New("please enter a valid proof file")
+		}
+		return cli.verifyMerkleProof(*proofFile, *root)
+	case FlagVerifyChain:
+		depth := verifyChainCmd.Int("depth", 0, "Number of latest blocks to check, 0 means all the blocks")
+		level := verifyChainCmd.Int("level", blockchain.DefaultVerifyLevel, "0: read blocks, 1: hash, pow and merkle root, 2: signatures, 3: replay the utxo set")
+		rollback := verifyChainCmd.Bool("rollback", false, "Roll the tip back to the last good block if a bad block is found")
+		err := verifyChainCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.verifyChain(*depth, *level, *rollback)
+	case FlagExportChain:
+		file := exportChainCmd.String("file", "", "The file to write the blocks to")
+		from := exportChainCmd.Int("from", 0, "The first block height to export")
+		to := exportChainCmd.Int("to", -1, "The last block height to export, -1 means the latest block")
+		err := exportChainCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*file) == 0 {
+			return errors.New("please enter a valid file")
+		}
+		return cli.exportChain(*file, *from, *to)
+	case FlagImportChain:
+		file := importChainCmd.String("file", "", "The file written by exportchain")
+		err := importChainCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*file) == 0 {
+			return errors.New("please enter a valid file")
+		}
+		return cli.importChain(*file)
+	case FlagDumpUTXOSet:
+		file := dumpUTXOSetCmd.String("file", "", "The file to write the snapshot to")
+		err := dumpUTXOSetCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*file) == 0 {
+			return errors.New("please enter a valid file")
+		}
+		return cli.dumpUTXOSet(*file)
+	case FlagLoadUTXOSet:
+		file := loadUTXOSetCmd.String("file", "", "The file written by dumputxoset")
+		hash := loadUTXOSetCmd.String("hash", "", "The trusted snapshot hash printed by dumputxoset")
+		err := loadUTXOSetCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*file) == 0 {
+			return errors.New("please enter a valid file")
+		}
+		return cli.loadUTXOSet(*file, *hash)
+	case FlagInvalidateBlock:
+		hash := invalidateBlockCmd.String("hash", "", "The hash of the block to mark invalid")
+		err := invalidateBlockCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.invalidateBlock(*hash)
+	case FlagReconsiderBlock:
+		hash := reconsiderBlockCmd.String("hash", "", "The hash of the block marked by invalidateblock")
+		err := reconsiderBlockCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.reconsiderBlock(*hash)
+	case FlagDaemon:
+		webSocket := daemonCmd.String("websocket", "", "Push chain events to WebSocket clients on HOST:PORT")
+		notifyAddr := daemonCmd.String("notify", "", "Publish chain events on tcp://HOST:PORT or ipc://PATH")
+		err := daemonCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.daemon(*webSocket, *notifyAddr)
+	case FlagWatchAddress:
+		address := watchAddressCmd.String("address", "", "The address to watch")
+		url := watchAddressCmd.String("url", "", "The webhook url to POST the notifications to")
+		confirmations := watchAddressCmd.Int("confirmations", webhook.DefaultConfirmations, "Also notify when an output reaches N confirmations")
+		err := watchAddressCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(*address) == 0 || len(*url) == 0 {
+			return errors.New("please enter a valid address and url")
+		}
+		return cli.watchAddress(*address, *url, *confirmations)
+	case FlagUnwatchAddress:
+		id := unwatchAddressCmd.String("id", "", "The watch id printed by watchaddress")
+		err := unwatchAddressCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return cli.unwatchAddress(*id)
+	case FlagListWatches:
+		return cli.listWatches()
 	default:
 		cli.printUsage()
 	}
+	return nil
+}
 
+// interruptContext 本地执行的命令Ctrl+C时取消，守护进程中的命令由请求的ctx取消，不在守护进程中注册信号
+func (cli *CommandLine) interruptContext() (context.Context, context.CancelFunc) {
+	if cli.remote {
+		return context.WithCancel(cli.ctx)
+	}
+	return signal.NotifyContext(cli.ctx, os.Interrupt)
+}
+
+// chainOpener 打开区块链的函数，在守护进程中使用守护进程打开的数据库
+// 打开时重建了链状态会输出提示，加入区块之后裁剪失败等错误输出到cli.out
+func (cli *CommandLine) chainOpener() func() (*blockchain.BlockChain, error) {
+	open := cli.open
+	if open == nil {
+		open = blockchain.ContinueBlockChain
+	}
+	return func() (*blockchain.BlockChain, error) {
+		chain, err := open()
+		if err != nil {
+			return nil, err
+		}
+		if chain.Repaired {
+			fmt.Fprintln(cli.out, "Chain state did not match the tip and has been rebuilt from the blocks")
+		}
+		chain.Log = cli.out
+		return chain, nil
+	}
+}
+
+// openChain 打开区块链，调用方负责关闭数据库
+func (cli *CommandLine) openChain() (*blockchain.BlockChain, func(), error) {
+	open := cli.chainOpener()
+	chain, err := open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return chain, func() {
+		_ = chain.Database.Close()
+	}, nil
 }
 
 // createBlockchain 创建区块链
-func (cli *CommandLine) createBlockchain(address string) {
-	newChain := blockchain.InitBlockChain(utils.Address2PubHash([]byte(address)))
+func (cli *CommandLine) createBlockchain(addr string) error {
+	pubKeyHash, err := address.Decode([]byte(addr))
+	if err != nil {
+		return err
+	}
+	newChain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		return err
+	}
 	_ = newChain.Database.Close()
-	fmt.Println("Created new blockchain: owner is : ", address)
+	fmt.Fprintln(cli.out, "genesis block create")
+	fmt.Fprintln(cli.out, "Created new blockchain: owner is : ", addr)
+	return nil
 }
 
 // createBlockchainRefName
-func (cli *CommandLine) createBlockchainRefName(refName string) {
-	address := cli.getAddressByRefName(refName)
-	cli.createBlockchain(address)
+func (cli *CommandLine) createBlockchainRefName(refName string) error {
+	address, err := cli.getAddressByRefName(refName)
+	if err != nil {
+		return err
+	}
+	return cli.createBlockchain(address)
 }
 
 // createWallet 创建钱包
-func (cli *CommandLine) createWallet(refName string) {
-	wlt := wallet.NewWallet()
-	wlt.Save()
-	refList := wallet.LoadRefList()
+func (cli *CommandLine) createWallet(refName string) error {
+	wlt, err := wallet.NewWallet()
+	if err != nil {
+		return err
+	}
+	err = wlt.Save()
+	if err != nil {
+		return err
+	}
+	refList, err := wallet.LoadRefList()
+	if err != nil {
+		return err
+	}
 	refList.BindRef(string(wlt.Address()), refName)
-	refList.Save()
-	fmt.Println("Succeed in creating wallet")
-	fmt.Printf("Wallet address:%x\n", wlt.Address())
-	fmt.Printf("Public Key:%x\n", wlt.PublicKey)
+	err = refList.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "Succeed in creating wallet")
+	fmt.Fprintf(cli.out, "Wallet address:%x\n", wlt.Address())
+	fmt.Fprintf(cli.out, "Public Key:%x\n", wlt.PublicKey)
+	return nil
 }
 
 // walletInfo 查询钱包信息
-func (cli *CommandLine) walletInfo(address, refName string) {
-	refList := wallet.LoadRefList()
+func (cli *CommandLine) walletInfo(address, refName string) error {
+	refList, err := wallet.LoadRefList()
+	if err != nil {
+		return err
+	}
 	if address != "" {
 		refName = (*refList)[address]
 	}
@@ -207,152 +641,827 @@ func (cli *CommandLine) walletInfo(address, refName string) {
 			}
 		}
 	}
-	wlt := wallet.LoadWallet(address)
-	fmt.Printf("Wallet address:%x\n", wlt.Address())
-	fmt.Printf("Public Key:%x\n", wlt.PublicKey)
-	fmt.Printf("Reference Name:%s\n", (*refList)[address])
+	wlt, err := wallet.LoadWallet(address)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Wallet address:%x\n", wlt.Address())
+	fmt.Fprintf(cli.out, "Public Key:%x\n", wlt.PublicKey)
+	fmt.Fprintf(cli.out, "Reference Name:%s\n", (*refList)[address])
+	return nil
 }
 
 // walletsUpdate 更新钱包管理文件
-func (cli *CommandLine) walletsUpdate() {
-	refList := wallet.LoadRefList()
-	refList.Update()
-	refList.Save()
+func (cli *CommandLine) walletsUpdate() error {
+	refList, err := wallet.LoadRefList()
+	if err != nil {
+		return err
+	}
+	err = refList.Update()
+	if err != nil {
+		return err
+	}
+	return refList.Save()
 }
 
 // walletsList 钱包列表
-func (cli *CommandLine) walletsList() {
-	refList := wallet.LoadRefList()
+func (cli *CommandLine) walletsList() error {
+	refList, err := wallet.LoadRefList()
+	if err != nil {
+		return err
+	}
 	for address, refName := range *refList {
-		wlt := wallet.LoadWallet(address)
-		fmt.Println("--------------------------------------------------------------------------------------------------------------")
-		fmt.Printf("Wallet address:%s\n", address)
-		fmt.Printf("Wallet refName:%s\n", refName)
-		fmt.Printf("Public Key:%x\n", wlt.PublicKey)
-		fmt.Println("--------------------------------------------------------------------------------------------------------------")
-		fmt.Println()
+		wlt, err := wallet.LoadWallet(address)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cli.out, "--------------------------------------------------------------------------------------------------------------")
+		fmt.Fprintf(cli.out, "Wallet address:%s\n", address)
+		fmt.Fprintf(cli.out, "Wallet refName:%s\n", refName)
+		fmt.Fprintf(cli.out, "Public Key:%x\n", wlt.PublicKey)
+		fmt.Fprintln(cli.out, "--------------------------------------------------------------------------------------------------------------")
+		fmt.Fprintln(cli.out)
 	}
+	return nil
 }
 
 // walletBindRefName 钱包绑定别名
-func (cli *CommandLine) walletBindRefName(address, refName string) {
-	_ = wallet.LoadWallet(address) // 加载钱包，用于判断address是合法的
+func (cli *CommandLine) walletBindRefName(address, refName string) error {
+	_, err := wallet.LoadWallet(address) // 加载钱包，用于判断钱包是存在的
+	if err != nil {
+		return err
+	}
 
-	refList := wallet.LoadRefList()
+	refList, err := wallet.LoadRefList()
+	if err != nil {
+		return err
+	}
 	// 判断refName是否已经存在
 	a, err := refList.FindRef(refName)
 	if err == nil {
-		fmt.Printf("refname had already bind to %s\n", a)
-		return
+		return fmt.Errorf("refname had already bind to %s", a)
 	}
 	refList.BindRef(address, refName)
-	refList.Save()
-	fmt.Printf("Bind success")
+	err = refList.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "Bind success")
+	return nil
 }
 
 // balance 查询账户余额 address
-func (cli *CommandLine) balance(address string) {
-	chain := blockchain.ContinueBlockChain()
-	defer func() {
-		_ = chain.Database.Close()
-	}()
-	wlt := wallet.LoadWallet(address)
-	balance, _ := chain.FindUTXOs(wlt.PublicKey)
-	fmt.Println("Address is : ", address, "Balance : ", balance)
+func (cli *CommandLine) balance(address string) error {
+	wlt, err := wallet.LoadWallet(address)
+	if err != nil {
+		return err
+	}
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+	balance, _, err := chain.FindUTXOs(wlt.PublicKey)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "Address is : ", address, "Balance : ", balance)
+	return nil
 }
 
 // balanceRefName 根据昵称查询余额
-func (cli *CommandLine) balanceRefName(refName string) {
-	address := cli.getAddressByRefName(refName)
-	cli.balance(address)
+func (cli *CommandLine) balanceRefName(refName string) error {
+	address, err := cli.getAddressByRefName(refName)
+	if err != nil {
+		return err
+	}
+	return cli.balance(address)
 }
 
 // getBlockchainInfo 输出区块信息
-func (cli *CommandLine) getBlockchainInfo() {
-	chain := blockchain.ContinueBlockChain()
+func (cli *CommandLine) getBlockchainInfo() error {
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
 	iter := chain.Iterator()
-	defer func() {
-		_ = chain.Database.Close()
-	}()
-	ogPrevHash := chain.BackOgPrevHash()
+	ogPrevHash, err := chain.BackOgPrevHash()
+	if err != nil {
+		return err
+	}
 	for {
-		block := iter.Next()
-		fmt.Println("---------------------------------------------------------------------------------------------")
-		fmt.Printf("Timestamp:%s\n", time.Unix(block.Timestamp, 0).Format(time.DateTime))
-		fmt.Printf("Previous hash:%x\n", block.PrevHash)
-		fmt.Printf("Transactions:%v\n", block.Transactions)
+		block, err := iter.Next()
+		if errors.Is(err, blockchain.ErrBlockPruned) {
+			fmt.Fprintf(cli.out, "The bodies of block %x and older blocks have been pruned\n", iter.CurrentHash)
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cli.out, "---------------------------------------------------------------------------------------------")
+		fmt.Fprintf(cli.out, "Timestamp:%s\n", time.Unix(block.Timestamp, 0).Format(time.DateTime))
+		fmt.Fprintf(cli.out, "Previous hash:%x\n", block.PrevHash)
+		fmt.Fprintf(cli.out, "Version:%d Bits:%d Nonce:%d\n", block.Version, block.Bits, block.Nonce)
+		fmt.Fprintf(cli.out, "Merkle root:%x\n", block.MerkleRoot)
+		fmt.Fprintf(cli.out, "Transactions:%v\n", block.Transactions)
 		for i, tx := range block.Transactions {
-			fmt.Printf("\tTransaction Index:%d\n", i)
-			fmt.Println("\tInput:")
+			fmt.Fprintf(cli.out, "\tTransaction Index:%d\n", i)
+			fmt.Fprintln(cli.out, "\tInput:")
 			for _, in := range tx.Inputs {
-				fmt.Printf("\t\tTxID:%s\n", hex.EncodeToString(in.TxID))
-				fmt.Printf("\t\tOutIdx:%d\n", in.OutIdx)
-				fmt.Printf("\t\tPubKey:%x\n", in.PubKey)
-				fmt.Printf("\t\tAddress:%s\n", string(utils.PubHash2Address(utils.PublicKeyHash(in.PubKey))))
+				fmt.Fprintf(cli.out, "\t\tTxID:%s\n", hex.EncodeToString(in.TxID))
+				fmt.Fprintf(cli.out, "\t\tOutIdx:%d\n", in.OutIdx)
+				fmt.Fprintf(cli.out, "\t\tPubKey:%x\n", in.PubKey)
+				fmt.Fprintf(cli.out, "\t\tAddress:%s\n", string(address.Encode(utils.PublicKeyHash(in.PubKey))))
 			}
-			fmt.Println("\tOutput:")
+			fmt.Fprintln(cli.out, "\tOutput:")
 			for _, out := range tx.Outputs {
-				fmt.Printf("\t\tPubKeyHash:%s\n", hex.EncodeToString(out.PubKeyHash))
-				fmt.Printf("\t\tValue:%d\n", out.Value)
-				fmt.Printf("\t\tAddress:%s\n", string(utils.PubHash2Address(out.PubKeyHash)))
+				fmt.Fprintf(cli.out, "\t\tPubKeyHash:%s\n", hex.EncodeToString(out.PubKeyHash))
+				fmt.Fprintf(cli.out, "\t\tValue:%d\n", out.Value)
+				fmt.Fprintf(cli.out, "\t\tAddress:%s\n", string(address.Encode(out.PubKeyHash)))
 			}
 		}
-		fmt.Printf("hash:%x\n", block.Hash)
-		fmt.Printf("Pow: %s\n", strconv.FormatBool(block.ValidatePoW()))
-		fmt.Println("---------------------------------------------------------------------------------------------")
-		fmt.Println()
+		fmt.Fprintf(cli.out, "hash:%x\n", block.Hash)
+		fmt.Fprintf(cli.out, "Pow: %s\n", strconv.FormatBool(chain.CheckPoW(block) == nil))
+		fmt.Fprintln(cli.out, "---------------------------------------------------------------------------------------------")
+		fmt.Fprintln(cli.out)
 		if bytes.Equal(ogPrevHash, block.PrevHash) {
 			break
 		}
 	}
+	return nil
+}
+
+// validateAddress 输出地址的校验结果，地址无效时返回错误
+func (cli *CommandLine) validateAddress(addr string) error {
+	info, err := address.Parse([]byte(addr))
+	if err != nil {
+		fmt.Fprintln(cli.out, "Valid:false")
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	fmt.Fprintln(cli.out, "Valid:true")
+	fmt.Fprintf(cli.out, "Address:%s\n", info.Address)
+	fmt.Fprintf(cli.out, "Version:%#02x\n", info.Version)
+	fmt.Fprintf(cli.out, "PubKeyHash:%x\n", info.PubKeyHash)
+	fmt.Fprintf(cli.out, "Checksum:%x\n", info.Checksum)
+	return nil
+}
+
+// coinControl 根据命令行参数生成选币方式
+func (cli *CommandLine) coinControl(strategy, utxos string, lockTime uint) (*blockchain.CoinControl, error) {
+	selector, err := blockchain.GetCoinSelector(strategy)
+	if err != nil {
+		return nil, err
+	}
+	if lockTime > math.MaxUint32 {
+		return nil, fmt.Errorf("lock time %d is out of range", lockTime)
+	}
+	control := &blockchain.CoinControl{Selector: selector, LockTime: uint32(lockTime)}
+	if utxos != "" {
+		control.UTXOs, err = blockchain.ParseOutPoints(utxos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return control, nil
 }
 
 // send 发送交易 from,to 钱包地址
-func (cli *CommandLine) send(from, to string, amount int) {
-	chain := blockchain.ContinueBlockChain()
-	defer func() {
-		_ = chain.Database.Close()
-	}()
+func (cli *CommandLine) send(from, to string, amount int, control *blockchain.CoinControl) error {
+	toPubKeyHash, err := address.Decode([]byte(to))
+	if err != nil {
+		return fmt.Errorf("invalid to address: %w", err)
+	}
+	fromWallet, err := wallet.LoadWallet(from)
+	if err != nil {
+		return err
+	}
 
-	fromWallet := wallet.LoadWallet(from)
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
 
-	tx, err := chain.CreateTransaction(fromWallet.PublicKey, utils.Address2PubHash([]byte(to)), amount, fromWallet.PrivateKey)
+	tx, err := chain.CreateTransactionWithControl(fromWallet.PublicKey, toPubKeyHash, amount, fromWallet.PrivateKey, control)
 	if err != nil {
-		fmt.Println("Create transaction error : ", err)
-		return
+		return fmt.Errorf("create transaction: %w", err)
 	}
 
-	pool := blockchain.CreatePool()
-	pool.AddTransaction(tx)
-	pool.SaveFile()
-	fmt.Println("success")
+	err = blockchain.AcceptTransaction(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "success")
+	return nil
+}
+
+// sendMany 批量支付，一笔交易支付给文件中的所有地址
+func (cli *CommandLine) sendMany(from, file string, fee int, control *blockchain.CoinControl, yes bool) error {
+	file = cli.path(file)
+	fromWallet, err := wallet.LoadWallet(from)
+	if err != nil {
+		return err
+	}
+	entries, err := LoadPayments(file)
+	if err != nil {
+		return fmt.Errorf("load payments: %w", err)
+	}
+
+	// 签名之前先校验所有地址
+	payments := make([]blockchain.Payment, 0, len(entries))
+	total := 0
+	for _, entry := range entries {
+		pubKeyHash, err := address.Decode([]byte(entry.Address))
+		if err != nil {
+			return err
+		}
+		payments = append(payments, blockchain.Payment{
+			PubKeyHash: pubKeyHash,
+			Amount:     entry.Amount,
+		})
+		total += entry.Amount
+	}
+
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	tx, err := chain.BuildTransaction(fromWallet.PublicKey, payments, fee, control)
+	if err != nil {
+		return fmt.Errorf("create transaction: %w", err)
+	}
+
+	change := 0
+	if len(tx.Outputs) > len(payments) {
+		change = tx.Outputs[len(tx.Outputs)-1].Value
+	}
+	fmt.Fprintf(cli.out, "Recipients:%d\n", len(payments))
+	fmt.Fprintf(cli.out, "Inputs:%d\n", len(tx.Inputs))
+	fmt.Fprintf(cli.out, "Total amount:%d\n", total)
+	fmt.Fprintf(cli.out, "Fee:%d\n", fee)
+	fmt.Fprintf(cli.out, "Change:%d\n", change)
+	if !yes && !cli.confirm("Sign and send this transaction?") {
+		fmt.Fprintln(cli.out, "canceled")
+		return nil
+	}
+
+	err = tx.Sign(fromWallet.PrivateKey)
+	if err != nil {
+		return err
+	}
+	err = blockchain.AcceptTransaction(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "success, transaction id:%x\n", tx.ID)
+	return nil
+}
+
+// confirm 从标准输入读取确认，在守护进程中执行时不能确认
+func (cli *CommandLine) confirm(prompt string) bool {
+	fmt.Fprintf(cli.out, "%s [y/N] ", prompt)
+	if cli.remote {
+		fmt.Fprintln(cli.out, "\nCannot confirm through the daemon, use -yes")
+		return false
+	}
+	var answer string
+	_, _ = fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 // sendByName 根据名字交易
-func (cli *CommandLine) sendByName(nameFrom, toFrom string, amount int) {
-	refList := wallet.LoadRefList()
-	addressFrom, err := refList.FindRef(nameFrom)
-	utils.Handle(err)
-	addressTo, err := refList.FindRef(toFrom)
-	utils.Handle(err)
-	cli.send(addressFrom, addressTo, amount)
+func (cli *CommandLine) sendByName(nameFrom, toFrom string, amount int) error {
+	addressFrom, err := cli.getAddressByRefName(nameFrom)
+	if err != nil {
+		return err
+	}
+	addressTo, err := cli.getAddressByRefName(toFrom)
+	if err != nil {
+		return err
+	}
+	return cli.send(addressFrom, addressTo, amount, nil)
 }
 
 // getAddressByRefName 根据别名查找钱包地址
-func (cli *CommandLine) getAddressByRefName(refName string) string {
-	refList := wallet.LoadRefList()
-	address, err := refList.FindRef(refName)
-	utils.Handle(err)
-	return address
+func (cli *CommandLine) getAddressByRefName(refName string) (string, error) {
+	refList, err := wallet.LoadRefList()
+	if err != nil {
+		return "", err
+	}
+	return refList.FindRef(refName)
+}
+
+// listUnspent 列出钱包的未花费交易输出
+func (cli *CommandLine) listUnspent(address string) error {
+	wlt, err := wallet.LoadWallet(address)
+	if err != nil {
+		return err
+	}
+	locked, err := blockchain.LoadLockedOutputs()
+	if err != nil {
+		return err
+	}
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	utxos, err := chain.FindUTXOList(wlt.PublicKey)
+	if err != nil {
+		return err
+	}
+	height, err := chain.Height()
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, utxo := range utxos {
+		lockFlag := ""
+		if locked.IsLocked(utxo.OutPoint) {
+			lockFlag = " (locked)"
+		}
+		if !utxo.Mature(height + 1) {
+			lockFlag += " (immature)"
+		}
+		fmt.Fprintf(cli.out, "%s Value:%d%s\n", utxo.String(), utxo.Value(), lockFlag)
+		total += utxo.Value()
+	}
+	fmt.Fprintln(cli.out, "Address is : ", address, "Total : ", total)
+	return nil
+}
+
+// lockUnspent 锁定或解锁交易输出
+func (cli *CommandLine) lockUnspent(utxos string, lock bool) error {
+	points, err := blockchain.ParseOutPoints(utxos)
+	if err != nil {
+		return err
+	}
+	locked, err := blockchain.LoadLockedOutputs()
+	if err != nil {
+		return err
+	}
+	for _, op := range points {
+		if lock {
+			locked.Lock(op)
+		} else {
+			locked.Unlock(op)
+		}
+	}
+	err = locked.SaveFile()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "success")
+	return nil
+}
+
+// listLockUnspent 列出所有被锁定的交易输出
+func (cli *CommandLine) listLockUnspent() error {
+	locked, err := blockchain.LoadLockedOutputs()
+	if err != nil {
+		return err
+	}
+	for _, op := range locked.List() {
+		fmt.Fprintln(cli.out, op)
+	}
+	return nil
+}
+
+// mine 挖矿，Ctrl+C 可以中断，address不为空时奖励发给这个地址
+func (cli *CommandLine) mine(threads int, rewardAddress string) error {
+	var rewardPubKeyHash []byte
+	if len(rewardAddress) != 0 {
+		var err error
+		rewardPubKeyHash, err = address.Decode([]byte(rewardAddress))
+		if err != nil {
+			return err
+		}
+	}
+	ctx, stop := cli.interruptContext()
+	defer stop()
+	stats, err := blockchain.RunMine(ctx, cli.chainOpener(), blockchain.NewMiner(threads), rewardPubKeyHash)
+	if stats != nil {
+		cli.printMineStats(stats)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "Finish Mining")
+	return nil
+}
+
+// mineContinuous 持续挖矿，直到挖够blocks个区块或者Ctrl+C
+func (cli *CommandLine) mineContinuous(threads int, rewardAddress string, interval time.Duration, blocks int) error {
+	rewardPubKeyHash, err := address.Decode([]byte(rewardAddress))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := cli.interruptContext()
+	defer stop()
+	miner := &blockchain.ContinuousMiner{
+		OpenChain:        cli.chainOpener(),
+		Miner:            blockchain.NewMiner(threads),
+		RewardPubKeyHash: rewardPubKeyHash,
+		Interval:         interval,
+		Blocks:           blocks,
+		OnBlock: func(block *blockchain.Block, stats *blockchain.MineStats) {
+			fmt.Fprintf(cli.out, "Mined block %x with %d transactions\n", block.Hash, len(block.Transactions))
+			cli.printMineStats(stats)
+		},
+		OnRestart: func(reason string) {
+			fmt.Fprintln(cli.out, "Restart mining:", reason)
+		},
+	}
+	mined, err := miner.Run(ctx)
+	fmt.Fprintf(cli.out, "Finish Mining, %d blocks mined\n", mined)
+	return err
+}
+
+func (cli *CommandLine) printMineStats(stats *blockchain.MineStats) {
+	fmt.Fprintf(cli.out, "Hashes:%d Time:%s Hash rate:%.0f H/s\n", stats.Hashes, stats.Duration.Round(time.Millisecond), stats.HashRate())
+}
+
+// defaultRPCListen 当前网络默认的RPC地址
+func defaultRPCListen() string {
+	return fmt.Sprintf("127.0.0.1:%d", config.Active().Params.RPCPort)
+}
+
+// spvServer 为轻节点提供区块头和merkle路径，Ctrl+C 停止
+func (cli *CommandLine) spvServer(listen string) error {
+	server := &http.Server{Addr: listen, Handler: spv.NewHandler(&spv.ChainNode{Open: cli.open})}
+	ctx, stop := cli.interruptContext()
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+
+	fmt.Fprintln(cli.out, "Serving light clients on", listen)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// spvBalance 轻节点模式：只同步区块头，通过merkle路径验证交易后计算余额
+func (cli *CommandLine) spvBalance(addr, nodeURL string, minConf int) error {
+	pubKeyHash, err := address.Decode([]byte(addr))
+	if err != nil {
+		return err
+	}
+	client, err := spv.NewClient(&spv.RemoteNode{URL: nodeURL})
+	if err != nil {
+		return err
+	}
+	client.MinConf = minConf
+	added, err := client.Sync()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Synced %d headers, height %d\n", added, client.Store.Height())
+
+	balance, err := client.Balance(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "Address is : ", addr, "Confirmed : ", balance.Confirmed, "Pending : ", balance.Pending)
+	return nil
+}
+
+// getMerkleProof 输出交易在区块中的证明
+func (cli *CommandLine) getMerkleProof(txIDs string) error {
+	ids := make([][]byte, 0)
+	for _, s := range strings.Split(txIDs, ",") {
+		id, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil || len(id) == 0 {
+			return fmt.Errorf("invalid transaction id %q", s)
+		}
+		ids = append(ids, id)
+	}
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+	proof, err := chain.ProveTransactions(ids)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(proof, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, string(data))
+	return nil
+}
+
+// verifyMerkleProof 验证getmerkleproof输出的证明，指定merkle根时不需要区块链
+func (cli *CommandLine) verifyMerkleProof(proofFile, root string) error {
+	proofFile = cli.path(proofFile)
+	data, err := os.ReadFile(proofFile)
+	if err != nil {
+		return err
+	}
+	var proof blockchain.InclusionProof
+	err = json.Unmarshal(data, &proof)
+	if err != nil {
+		return fmt.Errorf("decode proof: %w", err)
+	}
+	if len(root) != 0 {
+		merkleRoot, err := hex.DecodeString(root)
+		if err != nil {
+			return fmt.Errorf("invalid merkle root: %w", err)
+		}
+		if !proof.Proof.Verify(merkleRoot) {
+			return cli.printProofResult(&proof, fmt.Errorf("%w: merkle root %x", blockchain.ErrInvalidProof, merkleRoot))
+		}
+		return cli.printProofResult(&proof, nil)
+	}
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+	return cli.printProofResult(&proof, chain.VerifyInclusionProof(&proof))
+}
+
+func (cli *CommandLine) printProofResult(proof *blockchain.InclusionProof, err error) error {
+	if err != nil {
+		fmt.Fprintln(cli.out, "Valid:false")
+		fmt.Fprintln(cli.out, "Error:", err)
+		return nil
+	}
+	fmt.Fprintln(cli.out, "Valid:true")
+	fmt.Fprintf(cli.out, "Block:%x\n", proof.BlockHash)
+	for _, leaf := range proof.Proof.Leaves {
+		fmt.Fprintf(cli.out, "Transaction:%x\n", leaf)
+	}
+	return nil
+}
+
+// verifyChain 检查数据库中的区块链，发现问题时可以把最新区块回退到最后一个正确的区块
+func (cli *CommandLine) verifyChain(depth, level int, rollback bool) error {
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	result, err := chain.VerifyChain(depth, level)
+	if err != nil {
+		return err
+	}
+	if result.Level != level {
+		fmt.Fprintf(cli.out, "Block bodies have been pruned, only level %d can be checked\n", result.Level)
+	}
+	if result.OK() {
+		fmt.Fprintf(cli.out, "Chain height:%d, checked %d blocks at level %d, no problem found\n", result.Height, result.Checked, result.Level)
+		return nil
+	}
+	if result.BadHash == nil {
+		// 区块都没有问题，只是UTXO集合和区块不一致
+		fmt.Fprintln(cli.out, "Reason:", result.UTXOErr)
+		if !rollback {
+			return errors.New("utxo set is corrupted, run with -rollback to rebuild it from the blocks")
+		}
+		if err = chain.Reindex(); err != nil {
+			return fmt.Errorf("rebuild utxo set: %w", err)
+		}
+		fmt.Fprintln(cli.out, "Rebuilt the utxo set from the blocks")
+		return nil
+	}
+	fmt.Fprintf(cli.out, "Bad block:%x\n", result.BadHash)
+	fmt.Fprintf(cli.out, "Height:%d\n", result.BadHeight)
+	fmt.Fprintln(cli.out, "Reason:", result.Err)
+	if result.GoodHash == nil {
+		return errors.New("chain is corrupted and can not be rolled back")
+	}
+	fmt.Fprintf(cli.out, "Last good block:%x\n", result.GoodHash)
+	if !rollback {
+		return errors.New("chain is corrupted, run with -rollback to roll the tip back to the last good block")
+	}
+	err = chain.RollbackTo(result.GoodHash)
+	if err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	fmt.Fprintf(cli.out, "Rolled the tip back to height %d\n", result.BadHeight-1)
+	return nil
+}
+
+// exportChain 把区块导出到文件，用于给新节点提供区块或者分享测试链
+func (cli *CommandLine) exportChain(file string, from, to int) error {
+	file = cli.path(file)
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	count, err := chain.ExportChain(f, from, to)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file)
+		return fmt.Errorf("export chain: %w", err)
+	}
+	fmt.Fprintf(cli.out, "Exported %d blocks to %s\n", count, file)
+	return nil
 }
 
-// mine 挖矿
-func (cli *CommandLine) mine() {
-	chain := blockchain.ContinueBlockChain()
+// importChain 导入exportchain导出的区块，每个区块都经过正常的验证再加入区块链
+// 本地没有区块链时使用文件中的创世区块创建，已经存在的区块直接跳过，所以失败后可以重新导入继续
+func (cli *CommandLine) importChain(file string) error {
+	file = cli.path(file)
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
 	defer func() {
-		_ = chain.Database.Close()
+		_ = f.Close()
+	}()
+	reader, err := blockchain.NewBootstrapReader(f)
+	if err != nil {
+		return err
+	}
+
+	open := cli.chainOpener()
+	chain, err := open()
+	if err != nil && !errors.Is(err, blockchain.ErrChainNotFound) {
+		return err
+	}
+	defer func() {
+		if chain != nil {
+			_ = chain.Database.Close()
+		}
+	}()
+
+	imported, skipped, lastHeight := 0, 0, -1
+	for {
+		height, block, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read block after height %d: %w", lastHeight, err)
+		}
+		if chain == nil {
+			if height != 0 {
+				return fmt.Errorf("there is no local blockchain, the file must start from the genesis block, not height %d", height)
+			}
+			chain, err = blockchain.InitBlockChainWithGenesis(block)
+			if err != nil {
+				return fmt.Errorf("import genesis block: %w", err)
+			}
+			imported++
+		} else {
+			added, err := chain.ImportBlock(block)
+			if err != nil {
+				return fmt.Errorf("import block %x at height %d: %w, run importchain again to resume after fixing it", block.Hash, height, err)
+			}
+			if added {
+				imported++
+			} else {
+				skipped++
+			}
+		}
+		lastHeight = height
+		if (imported+skipped)%importProgressInterval == 0 {
+			fmt.Fprintf(cli.out, "Processed %d blocks, height %d\n", imported+skipped, height)
+		}
+	}
+	if chain == nil {
+		return errors.New("the bootstrap file has no block")
+	}
+	fmt.Fprintf(cli.out, "Imported %d blocks, skipped %d blocks already in the chain, tip:%x\n", imported, skipped, chain.LastHash)
+	return nil
+}
+
+// dumpUTXOSet 把区块头和UTXO集合写入快照文件，别的节点可以从快照开始而不用重放所有区块
+func (cli *CommandLine) dumpUTXOSet(file string) error {
+	file = cli.path(file)
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	info, err := chain.DumpUTXOSet(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file)
+		return fmt.Errorf("dump utxo set: %w", err)
+	}
+	fmt.Fprintf(cli.out, "Dumped %d utxos at height %d to %s\n", info.UTXOs, info.Height, file)
+	fmt.Fprintf(cli.out, "Tip:%x\n", info.TipHash)
+	fmt.Fprintf(cli.out, "Snapshot hash:%x\n", info.Hash)
+	return nil
+}
+
+// loadUTXOSet 使用快照文件创建区块链，快照哈希应该和可信的节点核对
+func (cli *CommandLine) loadUTXOSet(file, hash string) error {
+	file = cli.path(file)
+	wantHash, err := hex.DecodeString(hash)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot hash %q: %w", hash, err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
 	}()
+	chain, info, err := blockchain.LoadUTXOSnapshot(f, wantHash)
+	if err != nil {
+		return fmt.Errorf("load utxo set: %w", err)
+	}
+	_ = chain.Database.Close()
+	fmt.Fprintf(cli.out, "Loaded %d utxos at height %d\n", info.UTXOs, info.Height)
+	fmt.Fprintf(cli.out, "Tip:%x\n", info.TipHash)
+	fmt.Fprintf(cli.out, "Snapshot hash:%x\n", info.Hash)
+	if len(wantHash) == 0 {
+		fmt.Fprintln(cli.out, "The snapshot hash is not checked, compare it with a trusted node")
+	}
+	return nil
+}
 
-	chain.RunMine()
-	fmt.Println("Finish Mining")
+// parseBlockHash 解析命令行中输入的区块哈希
+func parseBlockHash(hash string) ([]byte, error) {
+	blockHash, err := hex.DecodeString(hash)
+	if err != nil || len(blockHash) == 0 {
+		return nil, fmt.Errorf("invalid block hash %q", hash)
+	}
+	return blockHash, nil
+}
+
+// invalidateBlock 把区块标记为无效并断开，断开的交易放回交易池
+func (cli *CommandLine) invalidateBlock(hash string) error {
+	blockHash, err := parseBlockHash(hash)
+	if err != nil {
+		return err
+	}
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	disconnected, err := chain.InvalidateBlock(blockHash)
+	if err != nil {
+		return fmt.Errorf("invalidate block: disconnected %d blocks: %w", disconnected, err)
+	}
+	height, err := chain.Height()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Marked %x invalid and disconnected %d blocks\n", blockHash, disconnected)
+	fmt.Fprintf(cli.out, "Tip:%x Height:%d\n", chain.LastHash, height)
+	return nil
+}
+
+// reconsiderBlock 删除区块的无效标记，断开的区块还能接上时重新连接
+func (cli *CommandLine) reconsiderBlock(hash string) error {
+	blockHash, err := parseBlockHash(hash)
+	if err != nil {
+		return err
+	}
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	connected, err := chain.ReconsiderBlock(blockHash)
+	if err != nil {
+		return fmt.Errorf("reconsider block: reconnected %d blocks: %w", connected, err)
+	}
+	height, err := chain.Height()
+	if err != nil {
+		return err
+	}
+	if connected == 0 {
+		fmt.Fprintf(cli.out, "Removed the invalid mark of %x, no block was reconnected\n", blockHash)
+	} else {
+		fmt.Fprintf(cli.out, "Removed the invalid mark of %x and reconnected %d blocks\n", blockHash, connected)
+	}
+	fmt.Fprintf(cli.out, "Tip:%x Height:%d\n", chain.LastHash, height)
+	return nil
 }
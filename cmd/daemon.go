@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/daemon"
+	"github.com/limitzhang87/goblockchain/notify"
+	"github.com/limitzhang87/goblockchain/webhook"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// runOrForward 有守护进程在运行时把命令发给守护进程执行，否则在当前进程中执行
+func (cli *CommandLine) runOrForward(args []string) error {
+	if args[0] == FlagDaemon {
+		return cli.run(args)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	// Ctrl+C 断开连接，守护进程中的命令随之停止
+	ctx, stop := signal.NotifyContext(cli.ctx, os.Interrupt)
+	defer stop()
+	req := daemon.Request{Args: args, Dir: dir, ConfigFile: config.Active().ConfigFile, MockTime: cli.mockTime}
+	err = daemon.Run(ctx, config.Active().DaemonSocket, req, cli.out)
+	if errors.Is(err, daemon.ErrNotRunning) {
+		return cli.runWithWebhooks(args)
+	}
+	return err
+}
+
+// daemon 独占打开区块链，执行客户端发来的命令，Ctrl+C 停止
+// webSocket和notifyAddr不为空时把命令产生的区块和交易池事件推送给订阅者
+func (cli *CommandLine) daemon(webSocket, notifyAddr string) error {
+	// 先占用socket，已经有守护进程在运行或者地址不可用时不会去打开数据库
+	socket := config.Active().DaemonSocket
+	listener, err := daemon.Listen(socket)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = listener.Close()
+		_ = os.Remove(socket)
+	}()
+	notifiers := make([]func(context.Context) error, 0, 3)
+	if webSocket != "" {
+		wsListener, err := net.Listen("tcp", webSocket)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = wsListener.Close()
+		}()
+		fmt.Fprintf(cli.out, "Pushing events to ws://%s%s\n", wsListener.Addr(), notify.WebSocketPath)
+		notifiers = append(notifiers, func(ctx context.Context) error {
+			return notify.ServeWebSocket(ctx, wsListener)
+		})
+	}
+	if notifyAddr != "" {
+		pubListener, err := notify.ListenPublisher(notifyAddr)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = pubListener.Close()
+		}()
+		fmt.Fprintln(cli.out, "Publishing events on", notifyAddr)
+		publisher := notify.NewPublisher()
+		notifiers = append(notifiers, func(ctx context.Context) error {
+			return publisher.Serve(ctx, pubListener)
+		})
+	}
+	// 守护进程中的命令产生的事件变成webhook通知，之后注册的监视也会生效
+	sub := blockchain.Subscribe(webhook.EventBuffer)
+	defer sub.Unsubscribe()
+	webhooks := webhook.NewNotifier()
+	webhooks.Log = cli.out
+	notifiers = append(notifiers, func(ctx context.Context) error {
+		webhooks.Run(ctx, sub)
+		return nil
+	})
+
+	chain, err := cli.chainOpener()()
+	if err != nil {
+		return err
+	}
+	server := daemon.NewServer(chain)
+	server.Exec = func(ctx context.Context, req daemon.Request, out io.Writer) error {
+		if err := cli.checkRequest(req); err != nil {
+			return err
+		}
+		remote := &CommandLine{out: out, ctx: ctx, dir: req.Dir, open: server.OpenChain, remote: true}
+		if len(req.Args) == 0 {
+			remote.printUsage()
+			return nil
+		}
+		return remote.run(req.Args)
+	}
+
+	ctx, stop := signal.NotifyContext(cli.ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// 事件推送出错时停止守护进程，守护进程停止时事件推送也停止
+	ctx, cancel := context.WithCancel(ctx)
+	notifyErrs := make(chan error, len(notifiers))
+	for _, serve := range notifiers {
+		go func(serve func(context.Context) error) {
+			err := serve(ctx)
+			cancel()
+			notifyErrs <- err
+		}(serve)
+	}
+	fmt.Fprintln(cli.out, "Daemon listening on", socket)
+	err = server.Serve(ctx, listener)
+	cancel()
+	for range notifiers {
+		if notifyErr := <-notifyErrs; err == nil && notifyErr != nil {
+			err = fmt.Errorf("push events: %w", notifyErr)
+		}
+	}
+	fmt.Fprintln(cli.out, "Daemon stopped")
+	return err
+}
+
+// checkRequest 时间和配置是整个守护进程共用的，不能按命令切换，客户端的全局参数和守护进程不同时拒绝执行
+func (cli *CommandLine) checkRequest(req daemon.Request) error {
+	if configFile := config.Active().ConfigFile; req.ConfigFile != configFile {
+		return fmt.Errorf("%w: the daemon uses config file %q, the command uses %q", daemon.ErrSettingsMismatch, configFile, req.ConfigFile)
+	}
+	if req.MockTime != cli.mockTime {
+		return fmt.Errorf("%w: the daemon uses -mocktime=%d, the command uses -mocktime=%d", daemon.ErrSettingsMismatch, cli.mockTime, req.MockTime)
+	}
+	return nil
+}
+
+// newFlagSet 子命令的参数，在守护进程中解析失败时返回错误而不是退出进程
+func (cli *CommandLine) newFlagSet(name string) *flag.FlagSet {
+	if !cli.remote {
+		return flag.NewFlagSet(name, flag.ExitOnError)
+	}
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.SetOutput(cli.out)
+	return flagSet
+}
+
+// path 命令中的相对路径以客户端的工作目录为起点
+func (cli *CommandLine) path(file string) string {
+	if cli.dir == "" || file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(cli.dir, file)
+}
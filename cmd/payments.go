@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PaymentEntry 批量支付文件中的一条记录
+type PaymentEntry struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// LoadPayments 读取批量支付文件，支持json和csv两种格式
+// json可以是 {"address": amount} 对象或者 [{"address": "...", "amount": 1}] 数组
+// csv每一行为 address,amount，第一行可以是表头
+func LoadPayments(filename string) ([]PaymentEntry, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PaymentEntry
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		entries, err = parseJSONPayments(content)
+	case ".csv":
+		entries, err = parseCSVPayments(content)
+	default:
+		return nil, fmt.Errorf("unsupported payment file %s, use .json or .csv", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no payment found in file")
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Amount <= 0 {
+			return nil, fmt.Errorf("invalid amount %d for address %s", entry.Amount, entry.Address)
+		}
+		if seen[entry.Address] {
+			return nil, fmt.Errorf("address %s appears more than once", entry.Address)
+		}
+		seen[entry.Address] = true
+	}
+	return entries, nil
+}
+
+func parseJSONPayments(content []byte) ([]PaymentEntry, error) {
+	content = bytes.TrimSpace(content)
+	if len(content) > 0 && content[0] == '[' {
+		var entries []PaymentEntry
+		if err := json.Unmarshal(content, &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+
+	var pairs map[string]int
+	if err := json.Unmarshal(content, &pairs); err != nil {
+		return nil, err
+	}
+	entries := make([]PaymentEntry, 0, len(pairs))
+	for address, amount := range pairs {
+		entries = append(entries, PaymentEntry{Address: address, Amount: amount})
+	}
+	return entries, nil
+}
+
+func parseCSVPayments(content []byte) ([]PaymentEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	entries := make([]PaymentEntry, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		amount, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if line == 1 {
+				continue // 表头
+			}
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, record[1])
+		}
+		entries = append(entries, PaymentEntry{Address: strings.TrimSpace(record[0]), Amount: amount})
+	}
+	return entries, nil
+}
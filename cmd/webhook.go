@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/webhook"
+	"time"
+)
+
+// localDeliverTimeout 在当前进程中执行命令时，命令结束后发送通知最多等待的时间，没有发出的通知由之后的命令或守护进程重试
+const localDeliverTimeout = 10 * time.Second
+
+// eventCommands 会产生区块或者交易池事件的命令，其他命令不读取webhook文件
+var eventCommands = map[string]bool{
+	FlagSend:            true,
+	FlagSendByRefName:   true,
+	FlagSendMany:        true,
+	FlagMine:            true,
+	FlagImportChain:     true,
+	FlagInvalidateBlock: true,
+	FlagReconsiderBlock: true,
+}
+
+// runWithWebhooks 在当前进程中执行命令，有监视的地址时把命令产生的事件变成webhook通知
+// 读取webhook文件失败只输出警告，命令照常执行
+func (cli *CommandLine) runWithWebhooks(args []string) error {
+	if !eventCommands[args[0]] {
+		return cli.run(args)
+	}
+	watching, err := webhook.Watching()
+	if err != nil {
+		fmt.Fprintln(cli.out, "Webhook: skip notifications:", err)
+	}
+	if !watching {
+		return cli.run(args)
+	}
+	sub := blockchain.Subscribe(webhook.EventBuffer)
+	defer sub.Unsubscribe()
+	notifier := webhook.NewNotifier()
+	notifier.Log = cli.out
+	// 命令执行期间只处理事件，通知在命令结束后统一发送，不会因为中断正在发送的请求而重复通知
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case e := <-sub.C:
+				cli.handleWebhookEvent(notifier, e)
+			case <-stop:
+				for {
+					select {
+					case e := <-sub.C:
+						cli.handleWebhookEvent(notifier, e)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	err = cli.run(args)
+	close(stop)
+	<-done
+	if dropped := sub.Dropped(); dropped > 0 {
+		fmt.Fprintf(cli.out, "Webhook missed %d events, the event buffer was full\n", dropped)
+	}
+
+	deliverCtx, cancelDeliver := context.WithTimeout(cli.ctx, localDeliverTimeout)
+	defer cancelDeliver()
+	if _, deliverErr := notifier.Deliver(deliverCtx); deliverErr != nil {
+		fmt.Fprintln(cli.out, "Webhook delivery:", deliverErr)
+	}
+	return err
+}
+
+func (cli *CommandLine) handleWebhookEvent(notifier *webhook.Notifier, e blockchain.Event) {
+	if err := notifier.Handle(e); err != nil {
+		fmt.Fprintf(cli.out, "Webhook %s %x: %v\n", e.Type, e.Hash(), err)
+	}
+}
+
+// watchAddress 监视地址，已经确认但还没有达到确认数的输出从地址索引中找出，达到时也会通知
+func (cli *CommandLine) watchAddress(addr, url string, confirmations int) error {
+	pubKeyHash, err := address.Decode([]byte(addr))
+	if err != nil {
+		return err
+	}
+	chain, closeChain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer closeChain()
+
+	height, err := chain.Height()
+	if err != nil {
+		return err
+	}
+	outputs, err := chain.AddressOutputs(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	waiting := make([]webhook.Output, 0)
+	for _, out := range outputs {
+		if height-out.Height+1 >= confirmations {
+			continue
+		}
+		blockHash, err := chain.HashAtHeight(out.Height)
+		if err != nil {
+			return err
+		}
+		waiting = append(waiting, webhook.Output{
+			TxID:        hex.EncodeToString(out.TxID),
+			OutIdx:      out.OutIdx,
+			Value:       out.Value,
+			BlockHash:   hex.EncodeToString(blockHash),
+			BlockHeight: out.Height,
+		})
+	}
+	watch, err := webhook.AddWatch(addr, url, confirmations, waiting)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Watch id:%s\n", watch.ID)
+	fmt.Fprintf(cli.out, "Secret:%s\n", watch.Secret)
+	fmt.Fprintf(cli.out, "Outputs waiting for %d confirmations:%d\n", confirmations, len(waiting))
+	fmt.Fprintf(cli.out, "Check the %s header against sha256=HMAC-SHA256(secret, body)\n", webhook.SignatureHeader)
+	return nil
+}
+
+func (cli *CommandLine) unwatchAddress(id string) error {
+	if len(id) == 0 {
+		return errors.New("please enter a valid watch id")
+	}
+	if err := webhook.RemoveWatch(id); err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "success")
+	return nil
+}
+
+func (cli *CommandLine) listWatches() error {
+	watches, err := webhook.ListWatches()
+	if err != nil {
+		return err
+	}
+	for _, w := range watches {
+		fmt.Fprintf(cli.out, "%s %s -> %s confirmations:%d waiting:%d queued:%d\n", w.ID, w.Address, w.URL, w.Confirmations, w.Waiting, w.Queued)
+	}
+	return nil
+}
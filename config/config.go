@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DefaultConfigFile = "goblockchain.toml"
+
+	EnvConfigFile = "GOBLOCKCHAIN_CONFIG"
+	EnvNetwork    = "GOBLOCKCHAIN_NETWORK"
+	EnvDataDir    = "GOBLOCKCHAIN_DATADIR"
+
+	// 交易池的默认限制，不是共识规则，每个节点可以在配置文件中修改
+	DefaultMaxPoolTxs  = 5000
+	DefaultMaxPoolSize = 5 << 20
+
+	// MinPruneBlocks 裁剪模式下至少保留的区块体个数
+	MinPruneBlocks = 10
+)
+
+// File 配置文件内容
+type File struct {
+	Network     string `toml:"network"`
+	DataDir     string `toml:"datadir"`
+	MaxPoolTxs  int    `toml:"maxpooltxs"`
+	MaxPoolSize int    `toml:"maxpoolsize"`
+	Prune       int    `toml:"prune"`
+	Backend     string `toml:"backend"`
+}
+
+// Options 命令行传入的参数，优先级最高
+type Options struct {
+	ConfigFile string
+	Network    string
+	DataDir    string
+}
+
+// Config 解析后的配置，所有文件路径都是绝对路径
+// 优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+type Config struct {
+	ConfigFile string
+	Network    string
+	Params     *chaincfg.Params
+
+	BaseDir string // 所有网络共用的根目录
+	DataDir string // 当前网络的数据目录
+
+	BlocksDir      string
+	WalletsDir     string
+	RefListFile    string
+	PoolFile       string
+	LockedUTXOFile string
+	SPVHeadersFile string // 轻节点保存的区块头
+	DaemonSocket   string // 守护进程监听的本地socket
+	WebhooksFile   string // 监视的地址和待发送的webhook通知
+
+	MaxPoolTxs  int    // 交易池最多保存的交易个数
+	MaxPoolSize int    // 交易池中交易编码后的总字节数上限
+	Prune       int    // 只保留最新的Prune个区块体，为0时不裁剪
+	Backend     string // 区块数据的存储后端
+}
+
+// DefaultBaseDir 默认的根目录 ~/.goblockchain
+func DefaultBaseDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".goblockchain"
+	}
+	return filepath.Join(home, ".goblockchain")
+}
+
+// Load 按优先级合并命令行参数、环境变量和配置文件，得到最终的配置
+func Load(opts Options) (*Config, error) {
+	configFile := firstNonEmpty(opts.ConfigFile, os.Getenv(EnvConfigFile))
+	explicitConfig := configFile != ""
+	if !explicitConfig {
+		// 默认的配置文件位于根目录下，根目录只能由命令行参数或环境变量指定
+		configFile = filepath.Join(firstNonEmpty(opts.DataDir, os.Getenv(EnvDataDir), DefaultBaseDir()), DefaultConfigFile)
+	}
+	configFile, err := expandPath(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var file File
+	_, err = toml.DecodeFile(configFile, &file)
+	if err != nil {
+		// 默认位置的配置文件不存在时直接使用默认值
+		if !errors.Is(err, os.ErrNotExist) || explicitConfig {
+			return nil, fmt.Errorf("load config file %s: %w", configFile, err)
+		}
+		configFile = ""
+	}
+
+	network := firstNonEmpty(opts.Network, os.Getenv(EnvNetwork), file.Network, chaincfg.MainNet)
+	baseDir := firstNonEmpty(opts.DataDir, os.Getenv(EnvDataDir), file.DataDir, DefaultBaseDir())
+	cfg, err := New(network, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ConfigFile = configFile
+	if file.MaxPoolTxs > 0 {
+		cfg.MaxPoolTxs = file.MaxPoolTxs
+	}
+	if file.MaxPoolSize > 0 {
+		cfg.MaxPoolSize = file.MaxPoolSize
+	}
+	if file.Prune < 0 || (file.Prune > 0 && file.Prune < MinPruneBlocks) {
+		return nil, fmt.Errorf("prune %d in %s: keep 0 (no pruning) or at least %d blocks", file.Prune, configFile, MinPruneBlocks)
+	}
+	cfg.Prune = file.Prune
+	if file.Backend != "" {
+		if err = storage.CheckBackend(file.Backend); err != nil {
+			return nil, fmt.Errorf("backend in %s: %w", configFile, err)
+		}
+		// 内存存储在命令结束后就丢失了，只在测试中使用
+		if file.Backend == storage.Memory {
+			return nil, fmt.Errorf("backend in %s: %s is only for tests", configFile, storage.Memory)
+		}
+		cfg.Backend = file.Backend
+	}
+	return cfg, nil
+}
+
+// New 根据网络和根目录生成配置
+func New(network, baseDir string) (*Config, error) {
+	params, err := chaincfg.ParamsByName(network)
+	if err != nil {
+		return nil, err
+	}
+	baseDir, err = expandPath(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return newConfig(params, baseDir), nil
+}
+
+// newConfig 由已经检查过的网络参数和绝对路径的根目录生成配置
+func newConfig(params *chaincfg.Params, baseDir string) *Config {
+	dataDir := filepath.Join(baseDir, params.DataDir)
+	return &Config{
+		Network:        params.Name,
+		Params:         params,
+		BaseDir:        baseDir,
+		DataDir:        dataDir,
+		BlocksDir:      filepath.Join(dataDir, constcoe.BCPatch),
+		WalletsDir:     filepath.Join(dataDir, constcoe.Wallets),
+		RefListFile:    filepath.Join(dataDir, constcoe.WalletsRefList),
+		PoolFile:       filepath.Join(dataDir, constcoe.TransactionPoolFile),
+		LockedUTXOFile: filepath.Join(dataDir, constcoe.LockedUTXOFile),
+		SPVHeadersFile: filepath.Join(dataDir, constcoe.SPVHeadersFile),
+		DaemonSocket:   filepath.Join(dataDir, constcoe.DaemonSocket),
+		WebhooksFile:   filepath.Join(dataDir, constcoe.WebhooksFile),
+		MaxPoolTxs:     DefaultMaxPoolTxs,
+		MaxPoolSize:    DefaultMaxPoolSize,
+		Backend:        storage.DefaultBackend,
+	}
+}
+
+var active *Config
+
+// Active 当前使用的配置，没有设置时使用当前网络的默认配置
+// 命令行在启动时用Load读取并检查配置，配置错误在那里返回，这里不会失败
+func Active() *Config {
+	if active == nil {
+		baseDir := DefaultBaseDir()
+		if abs, err := filepath.Abs(baseDir); err == nil {
+			baseDir = abs
+		}
+		active = newConfig(chaincfg.ActiveParams(), baseDir)
+	}
+	return active
+}
+
+// SetActive 设置当前使用的配置，同时切换到配置中的网络
+func SetActive(cfg *Config) error {
+	err := chaincfg.SelectNetwork(cfg.Network)
+	if err != nil {
+		return err
+	}
+	active = cfg
+	return nil
+}
+
+// expandPath 展开 ~ 并转为绝对路径，这样在任何目录下运行都使用同一份数据
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1,18 +1,29 @@
 package constcoe
 
 const (
-	Difficult = 12
-	InitCoin  = 1000 // This line is new
+	LHKey           = "lh"
+	OgPrevHashKey   = "ogPrevHash"
+	SchemaKey       = "schema" // 数据库中区块编码的版本
+	HeaderPrefix    = "h"      // 区块头的key为前缀加区块哈希
+	BodyPrefix      = "b"      // 区块体的key为前缀加区块哈希
+	UTXOPrefix      = "u"      // UTXO的key为前缀加交易ID和4字节的输出下标
+	HeightPrefix    = "n"      // 区块高度索引的key为前缀加8字节的高度，值为区块哈希
+	UndoPrefix      = "r"      // 区块撤销数据的key为前缀加区块哈希，值为区块花费的UTXO
+	InvalidPrefix   = "x"      // 被标记为无效的区块的key为前缀加区块哈希，值为标记时的最新区块
+	AddrIndexPrefix = "a"      // 地址索引的key为前缀加公钥哈希、高度和输出位置，值为金额
+	MigratedPrefix  = "m"      // 从旧数据库迁移过来的区块的key为前缀加区块哈希，这些区块的哈希不能由区块头计算
+	TipHeightKey    = "tipHeight"
+	PruneHeightKey  = "pruneHeight" // 区块体没有被裁剪的最低高度
 
-	LHKey         = "lh"
-	OgPrevHashKey = "ogPrevHash"
-
-	TransactionPoolFile = "./tmp/transaction_pool.data"
-	BCPatch             = "./tmp/blocks"
-	BCFile              = "./tmp/blocks/MANIFEST"
+	// 以下文件路径都相对于当前网络的数据目录
+	TransactionPoolFile = "transaction_pool.data"
+	LockedUTXOFile      = "locked_utxos.data"
+	SPVHeadersFile      = "spv_headers.data"
+	DaemonSocket        = "daemon.sock"
+	WebhooksFile        = "webhooks.json"
+	BCPatch             = "blocks"
 
 	ChecksumLength = 4
-	NetworkVersion = byte(0x00)
-	Wallets        = "./tmp/wallets/"
-	WalletsRefList = "./tmp/ref_list"
+	Wallets        = "wallets"
+	WalletsRefList = "ref_list.data"
 )
@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Run 把命令发给path上的守护进程执行，输出写入out，返回命令的错误
+// 没有守护进程在运行时返回ErrNotRunning，调用方可以在本进程中执行命令
+func Run(ctx context.Context, path string, req Request, out io.Writer) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	// 主机名不会被使用，连接总是发往socket
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://daemon"+runPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return fmt.Errorf("%w: %v", ErrNotRunning, err)
+		}
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxRequestSize))
+		return fmt.Errorf("daemon: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		return fmt.Errorf("read daemon output: %w", err)
+	}
+	if msg := resp.Trailer.Get(errorTrailer); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package daemon
+
+import "errors"
+
+var (
+	ErrNotRunning     = errors.New("daemon is not running")
+	ErrAlreadyRunning = errors.New("daemon is already running")
+	// ErrSettingsMismatch 客户端的全局参数和守护进程不同，命令在守护进程中的结果会和单独执行时不一样
+	ErrSettingsMismatch = errors.New("command settings differ from the daemon")
+)
@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/storage"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 守护进程独占打开区块链数据库，其他命令通过本地socket发给守护进程执行
+// 每个命令打开区块链时获得锁，关闭时释放，同一时间只有一个命令读写数据库和交易池
+
+const (
+	runPath = "/run"
+	// errorTrailer 命令的输出写完之后用HTTP trailer返回命令的错误
+	errorTrailer = "Gbc-Error"
+	// maxRequestSize 命令参数的上限
+	maxRequestSize = 1 << 20
+)
+
+// Request 客户端发送的命令，Dir是客户端的工作目录，命令中的相对路径以它为起点
+type Request struct {
+	Args       []string `json:"args"`
+	Dir        string   `json:"dir"`
+	ConfigFile string   `json:"config"`             // 客户端使用的配置文件
+	MockTime   int64    `json:"mocktime,omitempty"` // 客户端的-mocktime，为0时使用系统时间
+}
+
+// ExecFunc 在守护进程中执行命令，输出写入out，客户端断开时ctx被取消
+type ExecFunc func(ctx context.Context, req Request, out io.Writer) error
+
+type Server struct {
+	Exec ExecFunc // 在Serve之前设置
+
+	mu sync.Mutex
+	db storage.ChainStore
+}
+
+// NewServer 使用已经打开的区块链创建守护进程，Serve结束时关闭区块链的数据库
+func NewServer(chain *blockchain.BlockChain) *Server {
+	return &Server{db: chain.Database}
+}
+
+// OpenChain 等待其他命令关闭区块链，然后返回使用守护进程数据库的区块链
+// 返回的区块链关闭时只释放锁，不关闭数据库，每次都重新读取最新区块
+func (s *Server) OpenChain() (*blockchain.BlockChain, error) {
+	s.mu.Lock()
+	chain, err := blockchain.LoadBlockChain(&lockedStore{ChainStore: s.db, unlock: s.mu.Unlock})
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return chain, nil
+}
+
+// Serve 处理客户端的命令直到ctx被取消，所有命令结束后关闭数据库
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	// 命令的ctx来自守护进程的ctx，守护进程退出时正在执行的命令也会停止
+	server := &http.Server{Handler: s.handler(), BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(runPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		var req Request
+		err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("decode request: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Trailer", errorTrailer)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = s.Exec(r.Context(), req, &flushWriter{w: w})
+		if err != nil {
+			w.Header().Set(errorTrailer, err.Error())
+		}
+	})
+	return mux
+}
+
+// Listen 监听本地socket，socket文件已经存在时检查是否有守护进程在使用，没有时删除
+func Listen(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w on %s", ErrAlreadyRunning, path)
+		}
+		// 上次的守护进程没有正常退出
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// 只有当前用户可以连接
+	if err = os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// lockedStore 守护进程的数据库，Close释放OpenChain获得的锁
+type lockedStore struct {
+	storage.ChainStore
+	once   sync.Once
+	unlock func()
+}
+
+func (s *lockedStore) Close() error {
+	s.once.Do(s.unlock)
+	return nil
+}
+
+// flushWriter 每次写入后马上发给客户端，挖矿等长时间运行的命令也能看到进度
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
@@ -2,7 +2,15 @@ module github.com/limitzhang87/goblockchain
 
 go 1.22.2
 
-require github.com/dgraph-io/badger v1.6.2
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/dgraph-io/badger v1.6.2
+	github.com/mr-tron/base58 v1.2.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.23.0
+	golang.org/x/net v0.21.0
+	golang.org/x/sys v0.20.0
+)
 
 require (
 	github.com/AndreasBriese/bbloom v0.0.0-20190825152654-46b345b51c96 // indirect
@@ -10,9 +18,5 @@ require (
 	github.com/dgraph-io/ristretto v0.0.2 // indirect
 	github.com/dustin/go-humanize v1.0.0 // indirect
 	github.com/golang/protobuf v1.3.1 // indirect
-	github.com/mr-tron/base58 v1.2.0 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
-	golang.org/x/net v0.21.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
 )
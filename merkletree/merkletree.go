@@ -3,142 +3,156 @@ package merkletree
 import (
 	"bytes"
 	"crypto/sha256"
-	"errors"
 	"github.com/limitzhang87/goblockchain/transaction"
-	"github.com/limitzhang87/goblockchain/utils"
 )
 
+// merkle树的规则：
+// Version: 叶子结点为sha256(0x00 || 交易ID)，中间结点为sha256(0x01 || 左 || 右)，
+// 某一层结点个数为单数时，最后一个结点不做哈希直接放到上一层的最后，没有叶子时merkle根为sha256("")。
+// 叶子和中间结点的哈希不同，不会把中间结点当成交易，也不会复制结点，两组不同的交易不会得到同一个merkle根
+// LegacyVersion: 旧区块使用的规则，叶子结点为交易ID，中间结点为sha256(左 || 右)，
+// 叶子个数为单数时复制最后一个叶子，上面的层结点个数为单数时最后一个结点放到上一层的最前面
+const (
+	LegacyVersion = 1
+	Version       = 2
+)
+
+const (
+	leafPrefix  = 0x00
+	innerPrefix = 0x01
+)
+
+// MerkleTree 按层从叶子到根保存全部结点哈希，最后一个结点为merkle根
 type MerkleTree struct {
-	Root *MerkleNode
+	Version   int
+	LeafCount int
+	Leaves    [][]byte // 叶子数据，也就是交易ID
+	Nodes     [][]byte
 }
 
-type MerkleNode struct {
-	Left  *MerkleNode
-	Right *MerkleNode
-	Data  []byte
+// EmptyRoot 没有叶子时的merkle根
+func EmptyRoot() []byte {
+	hash := sha256.Sum256(nil)
+	return hash[:]
 }
 
-func CreateMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
-	temp := &MerkleNode{}
+// CreateMerkleTree 根据交易ID创建merkle树
+func CreateMerkleTree(txs []*transaction.Transaction) *MerkleTree {
+	return New(Version, txIDs(txs))
+}
 
-	if left != nil && right != nil {
-		tmpHash := append(left.Data, right.Data...)
-		hash := sha256.Sum256(tmpHash)
-		temp.Data = hash[:]
-	} else {
-		temp.Data = data
-	}
+// CreateLegacyMerkleTree 按旧规则创建merkle树，旧区块的merkle根使用这个规则
+func CreateLegacyMerkleTree(txs []*transaction.Transaction) *MerkleTree {
+	return New(LegacyVersion, txIDs(txs))
+}
 
-	temp.Left = left
-	temp.Right = right
-	return temp
+func txIDs(txs []*transaction.Transaction) [][]byte {
+	ids := make([][]byte, 0, len(txs))
+	for _, tx := range txs {
+		ids = append(ids, tx.ID)
+	}
+	return ids
 }
 
-// CreateMerkleTree 根据交易创建merkleTree
-func CreateMerkleTree(txs []*transaction.Transaction) *MerkleTree {
-	txLen := len(txs)
-	if txLen%2 != 0 {
-		txs = append(txs, txs[txLen-1])
+// New 按version的规则用叶子数据创建merkle树
+func New(version int, leaves [][]byte) *MerkleTree {
+	mt := &MerkleTree{Version: version, LeafCount: len(leaves), Leaves: leaves}
+	if len(leaves) == 0 {
+		mt.Nodes = [][]byte{EmptyRoot()}
+		return mt
 	}
-	nodeList := make([]*MerkleNode, 0, txLen+1)
-	for _, tx := range txs {
-		nodeList = append(nodeList, CreateMerkleNode(nil, nil, tx.ID))
+	sizes := levelSizes(version, len(leaves))
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	mt.Nodes = make([][]byte, 0, total)
+	for _, leaf := range leaves {
+		mt.Nodes = append(mt.Nodes, leafHash(version, leaf))
+	}
+	if len(leaves) < sizes[0] {
+		mt.Nodes = append(mt.Nodes, mt.Nodes[len(leaves)-1])
 	}
 
-	for len(nodeList) > 1 {
-		l := len(nodeList)
-		tmpNodeList := make([]*MerkleNode, 0, l/2)
-		// 如果是单数，将最后一个结点单独放到前面, 如果放在后面，极端情况下最后一个一直向上没有合并做hash
-		if l%2 != 0 {
-			tmpNodeList = append(tmpNodeList, nodeList[l-1])
-		}
-		for i := 0; i < l/2; i++ {
-			tmpNodeList = append(tmpNodeList, CreateMerkleNode(nodeList[2*i], nodeList[2*i+1], nil))
+	start := 0
+	for _, size := range sizes[:len(sizes)-1] {
+		level := mt.Nodes[start : start+size]
+		next := make([][]byte, size/2+size%2)
+		for pos := 0; pos < size; pos += 2 {
+			parent, sibling, ok := nextPos(version, size, pos)
+			if !ok {
+				next[parent] = level[pos]
+				continue
+			}
+			next[parent] = innerHash(version, level[pos], level[sibling])
 		}
-		nodeList = tmpNodeList
+		mt.Nodes = append(mt.Nodes, next...)
+		start += size
 	}
-	return &MerkleTree{Root: nodeList[0]}
+	return mt
 }
 
-func (mn *MerkleNode) Find2(data []byte, route []int, hashRoute [][]byte) (bool, []int, [][]byte) {
-	findFlag := false
-	if bytes.EqualFold(mn.Data, data) {
-		findFlag = true
-		return findFlag, route, hashRoute
-	} else {
-		if mn.Left != nil {
-			routeT := append(route, 0)
-			hashRouteT := append(hashRoute, mn.Right.Data)
-			findFlag, routeT, hashRouteT = mn.Left.Find(data, routeT, hashRouteT)
-			if findFlag {
-				return findFlag, routeT, hashRouteT
-			} else {
-				if mn.Right != nil {
-					routeT = append(route, 1)
-					hashRouteT = append(hashRoute, mn.Left.Data)
-					findFlag, routeT, hashRouteT = mn.Right.Find(data, routeT, hashRouteT)
-					if findFlag {
-						return findFlag, routeT, hashRouteT
-					} else {
-						return findFlag, route, hashRoute
-					}
-				}
-			}
-		} else {
-			return findFlag, route, hashRoute
+// Root merkle根
+func (mt *MerkleTree) Root() []byte {
+	return mt.Nodes[len(mt.Nodes)-1]
+}
+
+// IndexOf 叶子数据在树中的索引，找不到时返回-1
+func (mt *MerkleTree) IndexOf(leaf []byte) int {
+	for idx := range mt.Leaves {
+		if bytes.Equal(mt.Leaves[idx], leaf) {
+			return idx
 		}
 	}
-	return findFlag, route, hashRoute
+	return -1
 }
 
-// Find SPV算法中，返回待验证数据的路径和路径hash， 使用深度有限算法
-func (mn *MerkleNode) Find(data []byte, route []int, hashRoute [][]byte) (bool, []int, [][]byte) {
-	flag := false
-	if bytes.Equal(mn.Data, data) {
-		flag = true
-		return flag, route, hashRoute
+// levelSizes 每一层的结点个数，从叶子层到根
+func levelSizes(version, leafCount int) []int {
+	size := leafCount
+	if version == LegacyVersion && size%2 != 0 {
+		size++
 	}
-	if mn.Left != nil {
-		routeT := append(route, 0)                     // 路径中0， 代表当前深度有限算法中，走的是左边
-		hashRouteT := append(hashRoute, mn.Right.Data) // 放入右边的数据，为了验证，从底部往上回溯时左边的数据和这个右边的数据合并一起
-		flag, routeT, hashRouteT = mn.Left.Find2(data, routeT, hashRouteT)
-		if flag {
-			return flag, routeT, hashRouteT
-		}
+	sizes := []int{size}
+	for size > 1 {
+		size = size/2 + size%2
+		sizes = append(sizes, size)
 	}
-	if mn.Right != nil {
-		routeT := append(route, 1)                    // 路径中1， 代表当前深度有限算法中，走的是右边
-		hashRouteT := append(hashRoute, mn.Left.Data) // 放入左边的结点，这是为了后面验证时，
-		flag, routeT, hashRouteT = mn.Right.Find2(data, routeT, hashRouteT)
-		if flag {
-			return flag, routeT, hashRouteT
+	return sizes
+}
+
+// nextPos 结点在上一层中的位置和它的兄弟结点位置，ok为false表示没有兄弟结点，直接放到上一层
+func nextPos(version, size, pos int) (parent, sibling int, ok bool) {
+	offset := 0
+	if size%2 != 0 {
+		if pos == size-1 {
+			if version == LegacyVersion {
+				return 0, 0, false
+			}
+			return size / 2, 0, false
+		}
+		if version == LegacyVersion {
+			offset = 1
 		}
 	}
-	return flag, route, hashRoute
+	return offset + pos/2, pos ^ 1, true
 }
 
-// BackValidationRoute ...
-func (mt *MerkleTree) BackValidationRoute(txId []byte) ([]int, [][]byte, bool) {
-	ok, route, hashRoute := mt.Root.Find(txId, []int{}, [][]byte{})
-	return route, hashRoute, ok
+func leafHash(version int, data []byte) []byte {
+	if version == LegacyVersion {
+		return data
+	}
+	hash := sha256.Sum256(append([]byte{leafPrefix}, data...))
+	return hash[:]
 }
 
-// SimplePaymentValidation SPV算法验算数据是否是块中数据，从下往上验算
-func SimplePaymentValidation(txId, mtRoutHash []byte, route []int, hashRoute [][]byte) bool {
-	routeLen := len(route)
-	tempHash := txId
-
-	for i := routeLen - 1; i >= 0; i-- {
-		var parentHash []byte
-		if route[i] == 0 {
-			parentHash = append(tempHash, hashRoute[i]...)
-		} else if route[i] == 1 {
-			parentHash = append(hashRoute[i], tempHash...)
-		} else {
-			utils.Handle(errors.New("error in validation route"))
-		}
-		hash := sha256.Sum256(parentHash)
-		tempHash = hash[:]
+func innerHash(version int, left, right []byte) []byte {
+	data := make([]byte, 0, len(left)+len(right)+1)
+	if version != LegacyVersion {
+		data = append(data, innerPrefix)
 	}
-	return bytes.Equal(mtRoutHash, tempHash)
+	data = append(data, left...)
+	data = append(data, right...)
+	hash := sha256.Sum256(data)
+	return hash[:]
 }
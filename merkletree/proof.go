@@ -0,0 +1,257 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/wire"
+	"sort"
+)
+
+var ErrLeafNotFound = errors.New("merkle tree: leaf index out of range")
+
+// MerkleProof 证明一个或多个叶子结点在merkle树中
+// 树的形状只由叶子个数决定，所以只需要叶子索引和验证时缺少的兄弟结点哈希，不需要保存左右路径
+type MerkleProof struct {
+	Version   int // merkle树的规则，也是编码的版本
+	LeafCount int
+	Indices   []int    // 从小到大排列
+	Leaves    [][]byte // 和Indices一一对应的叶子数据
+	Hashes    [][]byte // 从底层往上，每层按位置从左往右，验证时无法计算出来的兄弟结点哈希
+}
+
+// Proof 生成叶子结点的证明，每个叶子只需要O(log n)个哈希，多个叶子共用的结点只保存一次
+func (mt *MerkleTree) Proof(indices ...int) (*MerkleProof, error) {
+	if len(indices) == 0 {
+		return nil, ErrLeafNotFound
+	}
+	sorted := append([]int(nil), indices...)
+	sort.Ints(sorted)
+	proof := &MerkleProof{Version: mt.Version, LeafCount: mt.LeafCount}
+	leafHashes := make([][]byte, 0, len(sorted))
+	for i, idx := range sorted {
+		if idx < 0 || idx >= mt.LeafCount {
+			return nil, fmt.Errorf("%w: %d", ErrLeafNotFound, idx)
+		}
+		if i > 0 && idx == sorted[i-1] {
+			continue
+		}
+		proof.Indices = append(proof.Indices, idx)
+		proof.Leaves = append(proof.Leaves, mt.Leaves[idx])
+		leafHashes = append(leafHashes, mt.Nodes[idx])
+	}
+
+	levelStarts := []int{0}
+	for _, size := range levelSizes(mt.Version, mt.LeafCount) {
+		levelStarts = append(levelStarts, levelStarts[len(levelStarts)-1]+size)
+	}
+	_, ok := walkProof(mt.Version, mt.LeafCount, proof.Indices, leafHashes, func(level, pos int) ([]byte, bool) {
+		hash := mt.Nodes[levelStarts[level]+pos]
+		proof.Hashes = append(proof.Hashes, hash)
+		return hash, true
+	})
+	if !ok {
+		return nil, ErrLeafNotFound
+	}
+	return proof, nil
+}
+
+// Verify 验证证明中的叶子结点都在merkle根为root的树中
+func (p *MerkleProof) Verify(root []byte) bool {
+	if p.Version != LegacyVersion && p.Version != Version {
+		return false
+	}
+	if p.LeafCount <= 0 || len(p.Indices) == 0 || len(p.Indices) != len(p.Leaves) {
+		return false
+	}
+	for i, idx := range p.Indices {
+		if idx < 0 || idx >= p.LeafCount || (i > 0 && idx <= p.Indices[i-1]) {
+			return false
+		}
+	}
+	leafHashes := make([][]byte, 0, len(p.Leaves))
+	for _, leaf := range p.Leaves {
+		leafHashes = append(leafHashes, leafHash(p.Version, leaf))
+	}
+	used := 0
+	computed, ok := walkProof(p.Version, p.LeafCount, p.Indices, leafHashes, func(level, pos int) ([]byte, bool) {
+		if used >= len(p.Hashes) {
+			return nil, false
+		}
+		used++
+		return p.Hashes[used-1], true
+	})
+	return ok && used == len(p.Hashes) && bytes.Equal(computed, root)
+}
+
+// walkProof 按version的规则从叶子结点往上计算merkle根，缺少的兄弟结点通过sibling获取
+func walkProof(version, leafCount int, indices []int, leafHashes [][]byte, sibling func(level, pos int) ([]byte, bool)) ([]byte, bool) {
+	sizes := levelSizes(version, leafCount)
+	known := make(map[int][]byte, len(indices)+1)
+	for i, idx := range indices {
+		known[idx] = leafHashes[i]
+	}
+	// 旧规则复制的叶子和最后一个叶子一样
+	if sizes[0] > leafCount {
+		if leaf, ok := known[leafCount-1]; ok {
+			known[leafCount] = leaf
+		}
+	}
+
+	for level, size := range sizes[:len(sizes)-1] {
+		positions := make([]int, 0, len(known))
+		for pos := range known {
+			positions = append(positions, pos)
+		}
+		sort.Ints(positions)
+
+		next := make(map[int][]byte, len(known))
+		for _, pos := range positions {
+			parent, sib, paired := nextPos(version, size, pos)
+			if !paired {
+				next[parent] = known[pos]
+				continue
+			}
+			if _, ok := next[parent]; ok {
+				continue
+			}
+			other, ok := known[sib]
+			if !ok {
+				if other, ok = sibling(level, sib); !ok {
+					return nil, false
+				}
+			}
+			if pos < sib {
+				next[parent] = innerHash(version, known[pos], other)
+			} else {
+				next[parent] = innerHash(version, other, known[pos])
+			}
+		}
+		known = next
+	}
+	return known[0], true
+}
+
+// Encode 按版本、叶子个数、索引、叶子、哈希的顺序写入
+func (p *MerkleProof) Encode(w *wire.Writer) {
+	w.WriteUvarint(uint64(p.Version))
+	w.WriteUvarint(uint64(p.LeafCount))
+	w.WriteUvarint(uint64(len(p.Indices)))
+	for _, idx := range p.Indices {
+		w.WriteUvarint(uint64(idx))
+	}
+	writeHashes(w, p.Leaves)
+	writeHashes(w, p.Hashes)
+}
+
+func (p *MerkleProof) Serialize() []byte {
+	w := wire.NewWriter()
+	p.Encode(w)
+	return w.Bytes()
+}
+
+// DecodeMerkleProof 从r中读取证明，错误通过r.Err返回
+func DecodeMerkleProof(r *wire.Reader) *MerkleProof {
+	p := &MerkleProof{}
+	p.Version = int(r.ReadVersion(Version))
+	p.LeafCount = int(r.ReadUvarint())
+	count := r.ReadCount(1)
+	p.Indices = make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		p.Indices = append(p.Indices, int(r.ReadUvarint()))
+	}
+	p.Leaves = readHashes(r)
+	p.Hashes = readHashes(r)
+	return p
+}
+
+func DeserializeMerkleProof(data []byte) (*MerkleProof, error) {
+	r := wire.NewReader(data)
+	p := DecodeMerkleProof(r)
+	if err := r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode merkle proof: %w", err)
+	}
+	if p.Version < LegacyVersion {
+		return nil, fmt.Errorf("decode merkle proof: %w %d", wire.ErrUnknownVersion, p.Version)
+	}
+	return p, nil
+}
+
+func writeHashes(w *wire.Writer, hashes [][]byte) {
+	w.WriteUvarint(uint64(len(hashes)))
+	for _, hash := range hashes {
+		w.WriteBytes(hash)
+	}
+}
+
+func readHashes(r *wire.Reader) [][]byte {
+	count := r.ReadCount(1)
+	hashes := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		hashes = append(hashes, r.ReadBytes())
+	}
+	return hashes
+}
+
+// merkleProofJSON JSON中的哈希使用十六进制字符串
+type merkleProofJSON struct {
+	Version   int      `json:"version"`
+	LeafCount int      `json:"leafCount"`
+	Indices   []int    `json:"indices"`
+	Leaves    []string `json:"leaves"`
+	Hashes    []string `json:"hashes"`
+}
+
+func (p *MerkleProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(merkleProofJSON{
+		Version:   p.Version,
+		LeafCount: p.LeafCount,
+		Indices:   p.Indices,
+		Leaves:    hexStrings(p.Leaves),
+		Hashes:    hexStrings(p.Hashes),
+	})
+}
+
+func (p *MerkleProof) UnmarshalJSON(data []byte) error {
+	var v merkleProofJSON
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	leaves, err := decodeHexStrings(v.Leaves)
+	if err != nil {
+		return fmt.Errorf("decode merkle proof leaves: %w", err)
+	}
+	hashes, err := decodeHexStrings(v.Hashes)
+	if err != nil {
+		return fmt.Errorf("decode merkle proof hashes: %w", err)
+	}
+	// 没有版本号的是旧规则的证明
+	if v.Version == 0 {
+		v.Version = LegacyVersion
+	}
+	*p = MerkleProof{Version: v.Version, LeafCount: v.LeafCount, Indices: v.Indices, Leaves: leaves, Hashes: hashes}
+	return nil
+}
+
+func hexStrings(data [][]byte) []string {
+	strs := make([]string, 0, len(data))
+	for _, b := range data {
+		strs = append(strs, hex.EncodeToString(b))
+	}
+	return strs
+}
+
+func decodeHexStrings(strs []string) ([][]byte, error) {
+	data := make([][]byte, 0, len(strs))
+	for _, s := range strs {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b)
+	}
+	return data, nil
+}
@@ -0,0 +1,9 @@
+package notify
+
+import "errors"
+
+var (
+	ErrBadAddress = errors.New("bad notification address")
+	ErrBadPayload = errors.New("bad payload option")
+	ErrBadFrame   = errors.New("bad notification frame")
+)
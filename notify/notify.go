@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+)
+
+// 把守护进程中的区块链事件推送给其他进程：WebSocket推送JSON消息，发布socket推送带主题的多帧消息
+// 每个事件可以只推送区块哈希或交易ID，也可以推送区块或交易的完整编码
+
+// subscriptionBuffer 每个客户端缓冲的事件个数，客户端读得太慢时丢弃后面的事件
+const subscriptionBuffer = 256
+
+// Payload 推送的内容
+type Payload string
+
+const (
+	PayloadHash Payload = "hash" // 区块哈希或交易ID
+	PayloadRaw  Payload = "raw"  // 区块或交易的编码
+)
+
+// Payloads 所有的推送内容
+func Payloads() []Payload {
+	return []Payload{PayloadHash, PayloadRaw}
+}
+
+// ParsePayload 为空时使用PayloadHash
+func ParsePayload(s string) (Payload, error) {
+	switch Payload(s) {
+	case "", PayloadHash:
+		return PayloadHash, nil
+	case PayloadRaw:
+		return PayloadRaw, nil
+	}
+	return "", fmt.Errorf("%w: %q, use %s or %s", ErrBadPayload, s, PayloadHash, PayloadRaw)
+}
+
+// Body 事件按照推送内容编码
+func Body(e blockchain.Event, payload Payload) ([]byte, error) {
+	if payload == PayloadRaw {
+		return e.Serialize()
+	}
+	return e.Hash(), nil
+}
+
+// Topic 发布socket中事件的主题，例如hashblockconnected、rawtxaccepted
+func Topic(payload Payload, t blockchain.EventType) string {
+	return string(payload) + t.String()
+}
+
+// Message WebSocket推送的JSON消息
+type Message struct {
+	Event  string `json:"event"`
+	Hash   string `json:"hash"`
+	Height *int   `json:"height,omitempty"` // 只有区块事件有高度
+	Data   string `json:"data,omitempty"`   // 推送完整编码时为编码的十六进制
+}
+
+// NewMessage 事件转为WebSocket消息
+func NewMessage(e blockchain.Event, payload Payload) (*Message, error) {
+	msg := &Message{Event: e.Type.String(), Hash: hex.EncodeToString(e.Hash())}
+	if e.IsBlock() {
+		height := e.Height
+		msg.Height = &height
+	}
+	if payload == PayloadRaw {
+		data, err := e.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		msg.Data = hex.EncodeToString(data)
+	}
+	return msg, nil
+}
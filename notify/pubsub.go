@@ -0,0 +1,329 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 类似ZeroMQ的发布socket，地址为 tcp://HOST:PORT 或 ipc://PATH
+// 每帧前面是4字节大端序的长度，每条消息有三帧：主题、内容和4字节小端序的序号，每个主题的序号从0开始递增
+// 客户端发送订阅帧：第一个字节为1时订阅、为0时取消订阅，后面是主题前缀，空前缀订阅所有主题
+// 例如订阅 hash 收到所有事件的哈希，订阅 rawblock 收到连接和断开的区块编码
+
+const (
+	frameHeaderSize = 4
+	// maxSubscriptionSize 客户端订阅帧的上限
+	maxSubscriptionSize = 256
+	// MaxFrameSize 客户端接收的帧的上限
+	MaxFrameSize = 64 << 20
+
+	subscribeFlag   = 1
+	unsubscribeFlag = 0
+)
+
+// splitAddress 把发布socket的地址拆成网络和地址
+func splitAddress(addr string) (string, string, error) {
+	if rest, ok := strings.CutPrefix(addr, "tcp://"); ok && rest != "" {
+		return "tcp", rest, nil
+	}
+	if rest, ok := strings.CutPrefix(addr, "ipc://"); ok && rest != "" {
+		return "unix", rest, nil
+	}
+	return "", "", fmt.Errorf("%w: %q, use tcp://HOST:PORT or ipc://PATH", ErrBadAddress, addr)
+}
+
+// ListenPublisher 监听发布socket，ipc的socket文件已经存在时先删除
+func ListenPublisher(addr string) (net.Listener, error) {
+	network, address, err := splitAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if err = os.MkdirAll(filepath.Dir(address), 0755); err != nil {
+			return nil, err
+		}
+		if err = os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// Publisher 把区块链事件发布给所有订阅了对应主题的客户端
+type Publisher struct {
+	mu    sync.Mutex
+	conns map[*subscriber]struct{}
+	seq   map[string]uint32
+}
+
+func NewPublisher() *Publisher {
+	return &Publisher{conns: make(map[*subscriber]struct{}), seq: make(map[string]uint32)}
+}
+
+// Subscribers 至少订阅了一个主题的客户端个数
+func (p *Publisher) Subscribers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for conn := range p.conns {
+		if conn.subscribed() {
+			count++
+		}
+	}
+	return count
+}
+
+// Serve 发布事件直到ctx被取消，返回时关闭所有连接
+func (p *Publisher) Serve(ctx context.Context, listener net.Listener) error {
+	sub := blockchain.Subscribe(subscriptionBuffer)
+	defer sub.Unsubscribe()
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	accepted := make(chan error, 1)
+	go func() {
+		accepted <- p.accept(listener)
+	}()
+	for {
+		select {
+		case e := <-sub.C:
+			p.publish(e)
+		case err := <-accepted:
+			p.closeAll()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (p *Publisher) accept(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		s := &subscriber{conn: conn, out: make(chan []byte, subscriptionBuffer), done: make(chan struct{})}
+		p.mu.Lock()
+		p.conns[s] = struct{}{}
+		p.mu.Unlock()
+		go p.serveConn(s)
+	}
+}
+
+func (p *Publisher) serveConn(s *subscriber) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, s)
+		p.mu.Unlock()
+		s.close()
+	}()
+	go func() {
+		// 写失败时关闭连接，读取订阅帧的循环随之退出
+		for {
+			select {
+			case <-s.done:
+				return
+			case msg := <-s.out:
+				if _, err := s.conn.Write(msg); err != nil {
+					s.close()
+					return
+				}
+			}
+		}
+	}()
+	r := bufio.NewReader(s.conn)
+	for {
+		frame, err := readFrame(r, maxSubscriptionSize)
+		if err != nil || len(frame) == 0 {
+			return
+		}
+		switch frame[0] {
+		case subscribeFlag:
+			s.subscribe(string(frame[1:]))
+		case unsubscribeFlag:
+			s.unsubscribe(string(frame[1:]))
+		default:
+			return
+		}
+	}
+}
+
+func (p *Publisher) publish(e blockchain.Event) {
+	for _, payload := range Payloads() {
+		body, err := Body(e, payload)
+		if err != nil {
+			continue
+		}
+		topic := Topic(payload, e.Type)
+		p.mu.Lock()
+		seq := p.seq[topic]
+		p.seq[topic] = seq + 1
+		var seqBytes [4]byte
+		binary.LittleEndian.PutUint32(seqBytes[:], seq)
+		msg := appendFrame(appendFrame(appendFrame(nil, []byte(topic)), body), seqBytes[:])
+		for conn := range p.conns {
+			if conn.matches(topic) {
+				conn.send(msg)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *Publisher) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.conns {
+		conn.close()
+	}
+}
+
+// subscriber 发布socket的一个客户端
+type subscriber struct {
+	conn net.Conn
+	out  chan []byte
+	done chan struct{}
+	once sync.Once
+
+	mu       sync.Mutex
+	prefixes []string
+}
+
+func (s *subscriber) subscribe(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefixes = append(s.prefixes, prefix)
+}
+
+// unsubscribe 和ZeroMQ一样，取消一次订阅只删除一个相同的前缀
+func (s *subscriber) unsubscribe(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range s.prefixes {
+		if p == prefix {
+			s.prefixes = append(s.prefixes[:i], s.prefixes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *subscriber) subscribed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.prefixes) > 0
+}
+
+func (s *subscriber) matches(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// send 客户端读得太慢时丢弃消息，不阻塞其他客户端
+func (s *subscriber) send(msg []byte) {
+	select {
+	case s.out <- msg:
+	default:
+	}
+}
+
+func (s *subscriber) close() {
+	s.once.Do(func() {
+		close(s.done)
+		_ = s.conn.Close()
+	})
+}
+
+func appendFrame(dst, frame []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(frame)))
+	return append(dst, frame...)
+}
+
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > uint32(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrBadFrame, size, maxSize)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Subscriber 发布socket的客户端
+type Subscriber struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial 连接发布socket，连接后还要订阅主题才能收到消息
+func Dial(addr string) (*Subscriber, error) {
+	network, address, err := splitAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscriber{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Subscribe 订阅以prefix开头的主题
+func (s *Subscriber) Subscribe(prefix string) error {
+	return s.writeSubscription(subscribeFlag, prefix)
+}
+
+// Unsubscribe 取消一次Subscribe
+func (s *Subscriber) Unsubscribe(prefix string) error {
+	return s.writeSubscription(unsubscribeFlag, prefix)
+}
+
+func (s *Subscriber) writeSubscription(flag byte, prefix string) error {
+	if len(prefix)+1 > maxSubscriptionSize {
+		return fmt.Errorf("%w: topic prefix %q", ErrBadFrame, prefix)
+	}
+	_, err := s.conn.Write(appendFrame(nil, append([]byte{flag}, prefix...)))
+	return err
+}
+
+// Receive 读取下一条消息
+func (s *Subscriber) Receive() (topic string, body []byte, seq uint32, err error) {
+	frames := make([][]byte, 3)
+	for i := range frames {
+		if frames[i], err = readFrame(s.r, MaxFrameSize); err != nil {
+			return "", nil, 0, err
+		}
+	}
+	if len(frames[2]) != 4 {
+		return "", nil, 0, fmt.Errorf("%w: sequence frame has %d bytes", ErrBadFrame, len(frames[2]))
+	}
+	return string(frames[0]), frames[1], binary.LittleEndian.Uint32(frames[2]), nil
+}
+
+func (s *Subscriber) Close() error {
+	return s.conn.Close()
+}
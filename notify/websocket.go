@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"golang.org/x/net/websocket"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// WebSocketPath 客户端连接 ws://HOST:PORT/events?events=blockconnected,txaccepted&payload=hash|raw
+// 不传events时推送所有事件，不传payload时只推送哈希
+const WebSocketPath = "/events"
+
+// WebSocketHandler 每个连接单独订阅事件，连接断开时取消订阅
+func WebSocketHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(WebSocketPath, func(w http.ResponseWriter, r *http.Request) {
+		types, payload, err := parseQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// 握手之前订阅，客户端连接成功之后的事件都能收到
+		sub := blockchain.Subscribe(subscriptionBuffer, types...)
+		defer sub.Unsubscribe()
+		// 不检查Origin，命令行和其他程序连接时没有Origin，只应该监听本机地址
+		server := websocket.Server{
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				sendEvents(r.Context(), ws, sub, payload)
+			},
+		}
+		server.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+func parseQuery(r *http.Request) ([]blockchain.EventType, Payload, error) {
+	query := r.URL.Query()
+	payload, err := ParsePayload(query.Get("payload"))
+	if err != nil {
+		return nil, "", err
+	}
+	types := make([]blockchain.EventType, 0)
+	if events := query.Get("events"); events != "" {
+		for _, name := range strings.Split(events, ",") {
+			t, err := blockchain.ParseEventType(strings.TrimSpace(name))
+			if err != nil {
+				return nil, "", err
+			}
+			types = append(types, t)
+		}
+	}
+	return types, payload, nil
+}
+
+func sendEvents(ctx context.Context, ws *websocket.Conn, sub *blockchain.Subscription, payload Payload) {
+	// 客户端不发送消息，读取只是为了发现连接断开
+	closed := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, ws)
+		close(closed)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case e := <-sub.C:
+			msg, err := NewMessage(e, payload)
+			if err != nil {
+				return
+			}
+			if err = websocket.JSON.Send(ws, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeWebSocket 推送事件直到ctx被取消
+func ServeWebSocket(ctx context.Context, listener net.Listener) error {
+	// 升级后的连接不受Shutdown管理，连接的ctx来自ctx，ctx被取消时连接关闭
+	server := &http.Server{Handler: WebSocketHandler(), BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package spv
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/merkletree"
+)
+
+// syncBatch 每次向全节点请求的区块头个数
+const syncBatch = 500
+
+// Client 轻节点，只保存区块头，通过merkle路径验证全节点返回的交易
+type Client struct {
+	Node    FullNode
+	Store   *HeaderStore
+	MinConf int // 确认数达到MinConf的交易才计入确认余额，小于等于0时为1
+}
+
+// Balance 轻节点计算的余额
+type Balance struct {
+	Confirmed int
+	Pending   int // 在区块中但确认数不够的金额
+	UTXOs     []blockchain.UTXO
+}
+
+// NewClient 使用已经保存的区块头创建轻节点
+func NewClient(node FullNode) (*Client, error) {
+	store, err := LoadHeaderStore()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Node: node, Store: store}, nil
+}
+
+// Sync 从全节点同步新的区块头并保存，返回新增的区块头个数
+func (c *Client) Sync() (int, error) {
+	added := 0
+	for {
+		entries, err := c.Node.Headers(c.Store.Tip(), syncBatch)
+		if err != nil {
+			return added, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if err = c.Store.Append(entries); err != nil {
+			return added, err
+		}
+		added += len(entries)
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, c.Store.SaveFile()
+}
+
+// Balance 向全节点请求和公钥哈希有关的交易，验证merkle路径后计算余额
+// 全节点可以隐瞒交易，但是不能伪造交易
+func (c *Client) Balance(pubKeyHash []byte) (*Balance, error) {
+	proofs, err := c.Node.Proofs([][]byte{pubKeyHash})
+	if err != nil {
+		return nil, err
+	}
+
+	type output struct {
+		utxo   blockchain.UTXO
+		height int
+	}
+	outputs := make([]output, 0)
+	spent := make(map[string]bool)
+	for _, proof := range proofs {
+		height, err := c.verify(proof)
+		if err != nil {
+			return nil, err
+		}
+		tx := proof.Tx
+		for idx, out := range tx.Outputs {
+			if bytes.Equal(out.PubKeyHash, pubKeyHash) {
+				utxo := blockchain.UTXO{OutPoint: blockchain.OutPoint{TxID: tx.ID, OutIdx: idx}, Output: out}
+				outputs = append(outputs, output{utxo: utxo, height: height})
+			}
+		}
+		if tx.IsBase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			spent[blockchain.OutPoint{TxID: in.TxID, OutIdx: in.OutIdx}.String()] = true
+		}
+	}
+
+	minConf := c.MinConf
+	if minConf <= 0 {
+		minConf = 1
+	}
+	balance := &Balance{UTXOs: make([]blockchain.UTXO, 0)}
+	for _, out := range outputs {
+		if spent[out.utxo.String()] {
+			continue
+		}
+		if c.Store.Height()-out.height+1 >= minConf {
+			balance.Confirmed += out.utxo.Value()
+			balance.UTXOs = append(balance.UTXOs, out.utxo)
+		} else {
+			balance.Pending += out.utxo.Value()
+		}
+	}
+	return balance, nil
+}
+
+// verify 验证交易ID和交易内容一致，并且交易ID在已经同步的区块头的merkle根中，返回区块高度
+func (c *Client) verify(proof TxProof) (int, error) {
+	if proof.Tx == nil || !proof.Tx.VerifyID() {
+		return 0, fmt.Errorf("%w: transaction does not match its id", ErrInvalidProof)
+	}
+	header, height, ok := c.Store.Lookup(proof.BlockHash)
+	if !ok {
+		return 0, fmt.Errorf("%w: block %x of transaction %x", ErrUnknownBlock, proof.BlockHash, proof.Tx.ID)
+	}
+	if !provesTx(proof.Proof, proof.Tx.ID) || !proof.Proof.Verify(header.MerkleRoot) {
+		return 0, fmt.Errorf("%w: transaction %x in block %x", ErrInvalidProof, proof.Tx.ID, proof.BlockHash)
+	}
+	return height, nil
+}
+
+// provesTx merkle证明是否正好证明了这一个交易
+func provesTx(proof *merkletree.MerkleProof, txID []byte) bool {
+	return proof != nil && len(proof.Leaves) == 1 && bytes.Equal(proof.Leaves[0], txID)
+}
@@ -0,0 +1,9 @@
+package spv
+
+import "errors"
+
+var (
+	ErrUnknownBlock  = errors.New("unknown block")
+	ErrInvalidHeader = errors.New("invalid block header")
+	ErrInvalidProof  = errors.New("invalid merkle proof")
+)
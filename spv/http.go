@@ -0,0 +1,139 @@
+package spv
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxResponseSize 轻节点读取全节点返回数据的上限，避免恶意的全节点耗尽内存
+	maxResponseSize = 32 << 20
+	// maxPubKeyHashes 一次请求最多查询的公钥哈希个数
+	maxPubKeyHashes = 100
+)
+
+// NewHandler 通过HTTP提供全节点的接口
+// GET /headers?after=HASH&max=N
+// GET /proofs?pkh=HASH,HASH
+func NewHandler(node FullNode) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		after, err := hex.DecodeString(r.URL.Query().Get("after"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		max, err := strconv.Atoi(r.URL.Query().Get("max"))
+		if err != nil || max <= 0 || max > syncBatch {
+			max = syncBatch
+		}
+		entries, err := node.Headers(after, max)
+		if errors.Is(err, ErrUnknownBlock) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, entries)
+	})
+	mux.HandleFunc("/proofs", func(w http.ResponseWriter, r *http.Request) {
+		values := strings.Split(r.URL.Query().Get("pkh"), ",")
+		if len(values) > maxPubKeyHashes {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("too many public key hashes, at most %d", maxPubKeyHashes))
+			return
+		}
+		pubKeyHashes := make([][]byte, 0, len(values))
+		for _, s := range values {
+			pubKeyHash, err := hex.DecodeString(s)
+			if err != nil || len(pubKeyHash) == 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid public key hash %q", s))
+				return
+			}
+			pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+		}
+		proofs, err := node.Proofs(pubKeyHashes)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, proofs)
+	})
+	return mux
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+// RemoteNode 通过HTTP访问的全节点
+type RemoteNode struct {
+	URL    string
+	Client *http.Client // 为空时使用30秒超时的http.Client
+}
+
+func (n *RemoteNode) Headers(after []byte, max int) ([]HeaderEntry, error) {
+	query := url.Values{}
+	query.Set("after", hex.EncodeToString(after))
+	query.Set("max", strconv.Itoa(max))
+	entries := make([]HeaderEntry, 0)
+	err := n.get("/headers", query, &entries)
+	return entries, err
+}
+
+func (n *RemoteNode) Proofs(pubKeyHashes [][]byte) ([]TxProof, error) {
+	hashes := make([]string, 0, len(pubKeyHashes))
+	for _, pubKeyHash := range pubKeyHashes {
+		hashes = append(hashes, hex.EncodeToString(pubKeyHash))
+	}
+	query := url.Values{}
+	query.Set("pkh", strings.Join(hashes, ","))
+	proofs := make([]TxProof, 0)
+	err := n.get("/proofs", query, &proofs)
+	return proofs, err
+}
+
+func (n *RemoteNode) get(path string, query url.Values, v any) error {
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Get(strings.TrimSuffix(n.URL, "/") + path + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body := io.LimitReader(resp.Body, maxResponseSize)
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		_ = json.NewDecoder(body).Decode(&errResp)
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s", ErrUnknownBlock, errResp.Error)
+		}
+		return fmt.Errorf("full node returned %s: %s", resp.Status, errResp.Error)
+	}
+	return json.NewDecoder(body).Decode(v)
+}
@@ -0,0 +1,155 @@
+package spv
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/merkletree"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"sync"
+)
+
+// HeaderEntry 区块哈希和区块头，旧区块的哈希不能由区块头计算，所以一起传输
+type HeaderEntry struct {
+	Hash   []byte
+	Header *blockchain.BlockHeader
+}
+
+// TxProof 交易和证明交易在区块中的merkle证明
+type TxProof struct {
+	BlockHash []byte
+	Tx        *transaction.Transaction
+	Proof     *merkletree.MerkleProof
+}
+
+// FullNode 轻节点依赖的全节点
+type FullNode interface {
+	// Headers 返回after之后的区块头，从旧到新最多max个，after为空时从创世区块开始
+	Headers(after []byte, max int) ([]HeaderEntry, error)
+	// Proofs 返回支付给公钥哈希或者从公钥哈希花费的所有交易，以及交易的merkle路径
+	Proofs(pubKeyHashes [][]byte) ([]TxProof, error)
+}
+
+// ChainNode 使用本地区块链数据库的全节点，每次请求时打开数据库，结束后关闭，其他命令仍然可以使用数据库
+type ChainNode struct {
+	Open func() (*blockchain.BlockChain, error) // 为空时使用ContinueBlockChain
+	mu   sync.Mutex
+}
+
+func (n *ChainNode) open() (*blockchain.BlockChain, func(), error) {
+	open := n.Open
+	if open == nil {
+		open = blockchain.ContinueBlockChain
+	}
+	n.mu.Lock()
+	chain, err := open()
+	if err != nil {
+		n.mu.Unlock()
+		return nil, nil, err
+	}
+	return chain, func() {
+		_ = chain.Database.Close()
+		n.mu.Unlock()
+	}, nil
+}
+
+func (n *ChainNode) Headers(after []byte, max int) ([]HeaderEntry, error) {
+	chain, closeChain, err := n.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain()
+
+	ogPrevHash, err := chain.BackOgPrevHash()
+	if err != nil {
+		return nil, err
+	}
+	// 区块只链接到上一个区块，从最新的区块往回找到after
+	entries := make([]HeaderEntry, 0)
+	iter := chain.HeaderIterator()
+	for {
+		hash, header, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(after) != 0 && bytes.Equal(hash, after) {
+			break
+		}
+		entries = append(entries, HeaderEntry{Hash: hash, Header: header})
+		if bytes.Equal(header.PrevHash, ogPrevHash) {
+			if len(after) != 0 {
+				return nil, fmt.Errorf("%w: %x", ErrUnknownBlock, after)
+			}
+			break
+		}
+	}
+
+	// 反转为从旧到新
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if max > 0 && len(entries) > max {
+		entries = entries[:max]
+	}
+	return entries, nil
+}
+
+func (n *ChainNode) Proofs(pubKeyHashes [][]byte) ([]TxProof, error) {
+	chain, closeChain, err := n.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain()
+
+	ogPrevHash, err := chain.BackOgPrevHash()
+	if err != nil {
+		return nil, err
+	}
+	proofs := make([]TxProof, 0)
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var tree *merkletree.MerkleTree
+		for idx, tx := range block.Transactions {
+			if !relevant(tx, pubKeyHashes) {
+				continue
+			}
+			if tree == nil {
+				tree = block.MerkleTree()
+			}
+			proof, err := tree.Proof(idx)
+			if err != nil {
+				return nil, fmt.Errorf("prove transaction %x in block %x: %w", tx.ID, block.Hash, err)
+			}
+			proofs = append(proofs, TxProof{BlockHash: block.Hash, Tx: tx, Proof: proof})
+		}
+		if bytes.Equal(block.PrevHash, ogPrevHash) {
+			break
+		}
+	}
+	return proofs, nil
+}
+
+// relevant 交易是否支付给公钥哈希或者花费了公钥哈希的输出
+func relevant(tx *transaction.Transaction, pubKeyHashes [][]byte) bool {
+	for _, pubKeyHash := range pubKeyHashes {
+		for _, out := range tx.Outputs {
+			if bytes.Equal(out.PubKeyHash, pubKeyHash) {
+				return true
+			}
+		}
+		if tx.IsBase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if bytes.Equal(utils.PublicKeyHash(in.PubKey), pubKeyHash) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package spv
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wire"
+	"os"
+	"path/filepath"
+)
+
+// HeaderStore 轻节点只保存区块头，按高度从创世区块开始排列
+type HeaderStore struct {
+	Entries []HeaderEntry
+	heights map[string]int
+}
+
+// LoadHeaderStore 读取保存的区块头，文件不存在时返回空的HeaderStore
+func LoadHeaderStore() (*HeaderStore, error) {
+	store := &HeaderStore{heights: make(map[string]int)}
+	filename := config.Active().SPVHeadersFile
+	if !utils.FileExists(filename) {
+		return store, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r := wire.NewReader(data)
+	count := r.ReadCount(1)
+	entries := make([]HeaderEntry, 0, count)
+	for i := 0; i < count; i++ {
+		hash := r.ReadBytes()
+		header := blockchain.DecodeBlockHeader(r)
+		entries = append(entries, HeaderEntry{Hash: hash, Header: header})
+	}
+	if err = r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode spv headers: %w", err)
+	}
+	// 文件中的区块头也重新验证一遍
+	if err = store.Append(entries); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *HeaderStore) SaveFile() error {
+	w := wire.NewWriter()
+	w.WriteUvarint(uint64(len(s.Entries)))
+	for _, entry := range s.Entries {
+		w.WriteBytes(entry.Hash)
+		entry.Header.Encode(w)
+	}
+	filename := config.Active().SPVHeadersFile
+	// 轻节点的数据目录中可能还没有其他文件
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + ".tmp"
+	err = os.WriteFile(tmpFile, w.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filename)
+}
+
+// Tip 最新区块的哈希，没有区块头时返回nil
+func (s *HeaderStore) Tip() []byte {
+	if len(s.Entries) == 0 {
+		return nil
+	}
+	return s.Entries[len(s.Entries)-1].Hash
+}
+
+// Height 最新区块的高度，没有区块头时返回-1
+func (s *HeaderStore) Height() int {
+	return len(s.Entries) - 1
+}
+
+// Lookup 根据区块哈希查找区块头和高度
+func (s *HeaderStore) Lookup(hash []byte) (*blockchain.BlockHeader, int, bool) {
+	height, ok := s.heights[string(hash)]
+	if !ok {
+		return nil, 0, false
+	}
+	return s.Entries[height].Header, height, true
+}
+
+// Append 验证区块头并追加到最后，有一个区块头无效时全部不追加
+// 区块头要链接到当前最新的区块，难度要等于网络的难度，要满足工作量证明，区块哈希要由区块头计算出来
+// 第一个区块头必须链接到当前网络的创世信息
+func (s *HeaderStore) Append(entries []HeaderEntry) error {
+	if s.heights == nil {
+		s.heights = make(map[string]int)
+	}
+	prevHash := s.Tip()
+	if prevHash == nil {
+		prevHash = []byte(chaincfg.ActiveParams().GenesisMessage)
+	}
+	for _, entry := range entries {
+		err := checkHeader(entry, prevHash)
+		if err != nil {
+			return err
+		}
+		prevHash = entry.Hash
+	}
+	for _, entry := range entries {
+		s.heights[string(entry.Hash)] = len(s.Entries)
+		s.Entries = append(s.Entries, entry)
+	}
+	return nil
+}
+
+func checkHeader(entry HeaderEntry, prevHash []byte) error {
+	header := entry.Header
+	if header == nil || !bytes.Equal(header.PrevHash, prevHash) {
+		return fmt.Errorf("%w: %x does not connect to %x", ErrInvalidHeader, entry.Hash, prevHash)
+	}
+	if header.Bits != uint32(chaincfg.ActiveParams().Difficulty) {
+		return fmt.Errorf("%w: %x has bits %d", ErrInvalidHeader, entry.Hash, header.Bits)
+	}
+	// 全节点不可信，区块哈希必须由区块头计算得到，旧版本的区块头也不例外
+	if !bytes.Equal(header.BlockHash(), entry.Hash) {
+		return fmt.Errorf("%w: %x does not match its header", ErrInvalidHeader, entry.Hash)
+	}
+	if !header.ValidatePoW() {
+		return fmt.Errorf("%w: %x has not enough work", ErrInvalidHeader, entry.Hash)
+	}
+	return nil
+}
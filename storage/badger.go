@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// badgerDir 数据库目录，早期版本直接使用blocks/MANIFEST作为badger的目录，保持不变才能打开旧的数据
+const badgerDir = "MANIFEST"
+
+type badgerStore struct {
+	db *badger.DB
+}
+
+// OpenBadger 打开dir中的badger数据库
+func OpenBadger(dir string) (ChainStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create blocks dir: %w", err)
+	}
+	opts := badger.DefaultOptions(filepath.Join(dir, badgerDir))
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if badgerLocked(err) {
+		return nil, fmt.Errorf("open database: %w: %v", ErrLocked, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	return &badgerStore{db: db}, nil
+}
+
+// badgerLocked 打开badger失败是因为其他进程拿着目录锁，badger的错误没有Unwrap，只能通过Cause找到原始错误
+func badgerLocked(err error) bool {
+	for err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return true
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok || cause.Cause() == err {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
+
+func badgerExists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, badgerDir))
+	return err == nil
+}
+
+func (s *badgerStore) View(fn func(tx Tx) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return fn(badgerTx{txn: txn})
+	})
+}
+
+func (s *badgerStore) Update(fn func(tx Tx) error) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return fn(badgerTx{txn: txn})
+	})
+}
+
+func (s *badgerStore) NewBatch() Batch {
+	return badgerBatch{wb: s.db.NewWriteBatch()}
+}
+
+func (s *badgerStore) DropPrefix(prefixes ...[]byte) error {
+	return s.db.DropPrefix(prefixes...)
+}
+
+func (s *badgerStore) Close() error {
+	return s.db.Close()
+}
+
+type badgerTx struct {
+	txn *badger.Txn
+}
+
+func (t badgerTx) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// Set badger在事务提交之前会一直使用传入的切片，所以先拷贝一份
+func (t badgerTx) Set(key, value []byte) error {
+	return t.txn.Set(clone(key), clone(value))
+}
+
+func (t badgerTx) Delete(key []byte) error {
+	return t.txn.Delete(clone(key))
+}
+
+func (t badgerTx) ForEach(prefix []byte, fn func(key, value []byte) error) error {
+	iter := t.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		item := iter.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type badgerBatch struct {
+	wb *badger.WriteBatch
+}
+
+func (b badgerBatch) Set(key, value []byte) error {
+	return b.wb.Set(clone(key), clone(value))
+}
+
+func (b badgerBatch) Delete(key []byte) error {
+	return b.wb.Delete(clone(key))
+}
+
+func (b badgerBatch) Flush() error {
+	return b.wb.Flush()
+}
+
+func (b badgerBatch) Cancel() {
+	b.wb.Cancel()
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+func removeDir(dir string) error {
+	return os.RemoveAll(dir)
+}
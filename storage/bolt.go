@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	boltFile = "chain.db"
+	// boltBatchSize 批量写入时每个事务提交的写入个数
+	boltBatchSize = 10000
+)
+
+// boltBucket 所有数据都放在同一个bucket中，和badger一样按key的顺序排列
+var boltBucket = []byte("chain")
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt 打开dir中的bbolt数据库，数据库文件被其他进程打开时等待一秒后失败
+func OpenBolt(dir string) (ChainStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create blocks dir: %w", err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, boltFile), 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("open database: %w: %w", ErrLocked, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func boltExists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, boltFile))
+	return err == nil
+}
+
+func (s *boltStore) View(fn func(tx Tx) error) error {
+	return s.db.View(func(btx *bolt.Tx) error {
+		return fn(boltTx{bucket: btx.Bucket(boltBucket)})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx Tx) error) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		return fn(boltTx{bucket: btx.Bucket(boltBucket)})
+	})
+}
+
+func (s *boltStore) NewBatch() Batch {
+	return &boltBatch{store: s}
+}
+
+func (s *boltStore) DropPrefix(prefixes ...[]byte) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{bucket: btx.Bucket(boltBucket)}
+		for _, prefix := range prefixes {
+			keys := make([][]byte, 0)
+			err := tx.ForEach(prefix, func(key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err = tx.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// boltTx bbolt返回的切片只在事务中有效，读取的数据都要拷贝
+type boltTx struct {
+	bucket *bolt.Bucket
+}
+
+func (t boltTx) Get(key []byte) ([]byte, error) {
+	// 值为空的key和不存在的key都可能返回nil，用游标区分
+	k, v := t.bucket.Cursor().Seek(key)
+	if k == nil || !bytes.Equal(k, key) {
+		return nil, ErrNotFound
+	}
+	return clone(v), nil
+}
+
+func (t boltTx) Set(key, value []byte) error {
+	return t.bucket.Put(clone(key), clone(value))
+}
+
+func (t boltTx) Delete(key []byte) error {
+	return t.bucket.Delete(key)
+}
+
+func (t boltTx) ForEach(prefix []byte, fn func(key, value []byte) error) error {
+	c := t.bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(clone(k), clone(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type boltOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// boltBatch 在内存中缓存写入，Flush时每boltBatchSize个写入提交一个事务
+type boltBatch struct {
+	store *boltStore
+	ops   []boltOp
+}
+
+func (b *boltBatch) Set(key, value []byte) error {
+	b.ops = append(b.ops, boltOp{key: clone(key), value: clone(value)})
+	if len(b.ops) >= boltBatchSize {
+		return b.Flush()
+	}
+	return nil
+}
+
+func (b *boltBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, boltOp{key: clone(key), delete: true})
+	if len(b.ops) >= boltBatchSize {
+		return b.Flush()
+	}
+	return nil
+}
+
+func (b *boltBatch) Flush() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	err := b.store.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(boltBucket)
+		for _, op := range b.ops {
+			var err error
+			if op.delete {
+				err = bucket.Delete(op.key)
+			} else {
+				err = bucket.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	b.ops = nil
+	return err
+}
+
+func (b *boltBatch) Cancel() {
+	b.ops = nil
+}
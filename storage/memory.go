@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errReadOnly = errors.New("write in a read-only transaction")
+
+// memoryStores 进程中所有的内存存储，key为目录，关闭后再次打开同一个目录可以读到之前的数据
+var (
+	memoryMu     sync.Mutex
+	memoryStores = make(map[string]*memoryStore)
+)
+
+// memoryStore 保存在map中的存储，Update之间互斥，提交时一次性写入map
+// View读取的是每次读取时的最新数据，不是事务开始时的快照
+type memoryStore struct {
+	writeMu sync.Mutex   // 同一时间只有一个Update
+	dataMu  sync.RWMutex // 保护data
+	data    map[string][]byte
+}
+
+// OpenMemory 打开dir对应的内存存储，不存在时创建，数据在进程退出后丢失
+func OpenMemory(dir string) ChainStore {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	store, ok := memoryStores[dir]
+	if !ok {
+		store = &memoryStore{data: make(map[string][]byte)}
+		memoryStores[dir] = store
+	}
+	return store
+}
+
+func memoryExists(dir string) bool {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	_, ok := memoryStores[dir]
+	return ok
+}
+
+func removeMemory(dir string) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	delete(memoryStores, dir)
+}
+
+func (s *memoryStore) View(fn func(tx Tx) error) error {
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(tx Tx) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	tx := &memoryTx{store: s, writes: make(map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.commit(tx.writes)
+	return nil
+}
+
+// commit 写入修改，值为nil表示删除
+func (s *memoryStore) commit(writes map[string][]byte) {
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+	for key, value := range writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+}
+
+func (s *memoryStore) NewBatch() Batch {
+	return &memoryBatch{store: s, writes: make(map[string][]byte)}
+}
+
+func (s *memoryStore) DropPrefix(prefixes ...[]byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+	for key := range s.data {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, string(prefix)) {
+				delete(s.data, key)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Close 数据保留在进程中，再次打开同一个目录时还能读到
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// memoryTx writes中是还没有提交的修改，值为nil表示删除
+type memoryTx struct {
+	store  *memoryStore
+	writes map[string][]byte
+}
+
+func (t *memoryTx) Get(key []byte) ([]byte, error) {
+	if value, ok := t.writes[string(key)]; ok {
+		if value == nil {
+			return nil, ErrNotFound
+		}
+		return clone(value), nil
+	}
+	t.store.dataMu.RLock()
+	defer t.store.dataMu.RUnlock()
+	value, ok := t.store.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(value), nil
+}
+
+func (t *memoryTx) Set(key, value []byte) error {
+	if t.writes == nil {
+		return errReadOnly
+	}
+	// 空值也要和删除区分开
+	t.writes[string(key)] = append(make([]byte, 0, len(value)), value...)
+	return nil
+}
+
+func (t *memoryTx) Delete(key []byte) error {
+	if t.writes == nil {
+		return errReadOnly
+	}
+	t.writes[string(key)] = nil
+	return nil
+}
+
+func (t *memoryTx) ForEach(prefix []byte, fn func(key, value []byte) error) error {
+	keys := make([]string, 0)
+	t.store.dataMu.RLock()
+	for key := range t.store.data {
+		if _, ok := t.writes[key]; !ok && strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	t.store.dataMu.RUnlock()
+	for key, value := range t.writes {
+		if value != nil && strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := t.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			// 遍历过程中被其他事务删除
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryBatch 缓存写入，Flush时一次性提交
+type memoryBatch struct {
+	store  *memoryStore
+	writes map[string][]byte
+}
+
+func (b *memoryBatch) Set(key, value []byte) error {
+	b.writes[string(key)] = append(make([]byte, 0, len(value)), value...)
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.writes[string(key)] = nil
+	return nil
+}
+
+func (b *memoryBatch) Flush() error {
+	b.store.writeMu.Lock()
+	defer b.store.writeMu.Unlock()
+	b.store.commit(b.writes)
+	b.writes = make(map[string][]byte)
+	return nil
+}
+
+func (b *memoryBatch) Cancel() {
+	b.writes = make(map[string][]byte)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 区块链数据保存在有序的key-value存储中，区块、最新区块、索引和UTXO集合的编码由blockchain包负责
+// 存储后端只需要提供事务、按前缀有序遍历和批量写入
+
+// 可以使用的存储后端
+const (
+	Badger = "badger"
+	Bolt   = "bolt"
+	Memory = "memory" // 数据只保存在当前进程中，用于测试
+
+	DefaultBackend = Badger
+)
+
+var (
+	ErrNotFound       = errors.New("key not found")
+	ErrUnknownBackend = errors.New("unknown storage backend")
+	// ErrLocked 数据库被其他进程打开，等对方关闭之后可以重试
+	ErrLocked = errors.New("database is used by another process")
+)
+
+// Tx 存储事务，View中只能读取，Update中的修改在函数返回nil时一起提交，返回error时全部丢弃
+type Tx interface {
+	// Get 返回值的拷贝，key不存在时返回ErrNotFound
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// ForEach 按key的顺序遍历前缀为prefix的key，fn返回error时停止遍历，遍历过程中不要修改同一个事务
+	ForEach(prefix []byte, fn func(key, value []byte) error) error
+}
+
+// Batch 不需要读取的大量写入，可能分成多个事务提交，不保证原子性
+type Batch interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// Flush 提交所有还没有提交的写入
+	Flush() error
+	// Cancel 丢弃还没有提交的写入，Flush之后调用没有影响
+	Cancel()
+}
+
+// ChainStore 区块链的存储后端，BlockChain只通过这个接口读写数据
+type ChainStore interface {
+	View(fn func(tx Tx) error) error
+	Update(fn func(tx Tx) error) error
+	NewBatch() Batch
+	// DropPrefix 删除前缀为prefixes之一的所有key
+	DropPrefix(prefixes ...[]byte) error
+	Close() error
+}
+
+// Backends 所有可以使用的存储后端
+func Backends() []string {
+	return []string{Badger, Bolt, Memory}
+}
+
+// CheckBackend 检查存储后端的名字
+func CheckBackend(backend string) error {
+	for _, name := range Backends() {
+		if name == backend {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q, use one of %v", ErrUnknownBackend, backend, Backends())
+}
+
+// Open 打开dir中的存储，不存在时创建
+func Open(backend, dir string) (ChainStore, error) {
+	switch backend {
+	case Badger:
+		return OpenBadger(dir)
+	case Bolt:
+		return OpenBolt(dir)
+	case Memory:
+		return OpenMemory(dir), nil
+	}
+	return nil, CheckBackend(backend)
+}
+
+// Exists dir中是否已经有backend的存储
+func Exists(backend, dir string) bool {
+	switch backend {
+	case Badger:
+		return badgerExists(dir)
+	case Bolt:
+		return boltExists(dir)
+	case Memory:
+		return memoryExists(dir)
+	}
+	return false
+}
+
+// Detect 查找dir中已经存在的存储后端
+func Detect(dir string) (string, bool) {
+	for _, backend := range Backends() {
+		if Exists(backend, dir) {
+			return backend, true
+		}
+	}
+	return "", false
+}
+
+// Remove 删除dir中的存储，调用前要先关闭
+func Remove(backend, dir string) error {
+	if backend == Memory {
+		removeMemory(dir)
+		return nil
+	}
+	return removeDir(dir)
+}
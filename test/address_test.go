@@ -0,0 +1,50 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/utils"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	pubKeyHash := utils.PublicKeyHash([]byte("public key"))
+	addr := address.Encode(pubKeyHash)
+
+	decoded, err := address.Decode(addr)
+	if err != nil || !bytes.Equal(decoded, pubKeyHash) {
+		t.Fatalf("decode %s: got %x err %v", addr, decoded, err)
+	}
+
+	// 修改最后一个字符，校验和应该不通过
+	typo := append([]byte{}, addr...)
+	if typo[len(typo)-1] == '2' {
+		typo[len(typo)-1] = '3'
+	} else {
+		typo[len(typo)-1] = '2'
+	}
+
+	var tests = []struct {
+		address []byte
+		want    error
+	}{
+		{address: []byte(""), want: address.ErrEmpty},
+		{address: []byte("0OIl"), want: address.ErrInvalidBase58},
+		{address: utils.Base58Encode([]byte{0x00, 0x01, 0x02}), want: address.ErrInvalidLength},
+		{address: typo, want: address.ErrInvalidChecksum},
+	}
+	for _, test := range tests {
+		err := address.Validate(test.address)
+		if !errors.Is(err, test.want) {
+			t.Errorf("validate %q: got %v, want %v", test.address, err, test.want)
+		}
+	}
+
+	// 版本号错误
+	payload := append([]byte{0x6f}, pubKeyHash...)
+	wrongVersion := utils.Base58Encode(append(payload, utils.Checksum(payload)...))
+	if err := address.Validate(wrongVersion); !errors.Is(err, address.ErrInvalidVersion) {
+		t.Errorf("validate wrong version: got %v", err)
+	}
+}
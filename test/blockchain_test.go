@@ -0,0 +1,97 @@
+package test
+
+import (
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+// useTempChain 在临时目录中使用regtest网络，区块数据保存在内存中，测试结束后恢复
+func useTempChain(t *testing.T) {
+	t.Helper()
+	useTempChainWith(t, storage.Memory)
+}
+
+// useTempChainWith 和useTempChain一样，区块数据使用backend保存
+func useTempChainWith(t *testing.T, backend string) {
+	t.Helper()
+	cfg, err := config.New(chaincfg.RegTest, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Backend = backend
+	prev := config.Active()
+	if err = config.SetActive(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = storage.Remove(backend, cfg.BlocksDir)
+		_ = config.SetActive(prev)
+	})
+}
+
+func TestChainErrors(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	_, err := blockchain.ContinueBlockChain()
+	if !errors.Is(err, blockchain.ErrChainNotFound) {
+		t.Fatalf("continue missing chain: got %v", err)
+	}
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+
+	_, err = blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if !errors.Is(err, blockchain.ErrChainExists) {
+		t.Errorf("init existing chain: got %v", err)
+	}
+
+	receiver, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.CreateTransaction(owner.PublicKey, utils.PublicKeyHash(receiver.PublicKey), chaincfg.RegTestParams.GenesisReward+1, owner.PrivateKey)
+	if !errors.Is(err, blockchain.ErrInsufficientFunds) {
+		t.Errorf("overspend: got %v", err)
+	}
+
+	tx, err := chain.CreateTransaction(owner.PublicKey, utils.PublicKeyHash(receiver.PublicKey), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = chain.VerityTransaction([]*transaction.Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := blockchain.CreateBlock([]byte("not the tip"), []*transaction.Transaction{tx})
+	if err = chain.AddBlock(orphan); !errors.Is(err, blockchain.ErrTipMismatch) {
+		t.Errorf("add orphan block: got %v", err)
+	}
+
+	mock.Add(time.Minute)
+	block := blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{tx})
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	balance, _, err := chain.FindUTXOs(receiver.PublicKey)
+	if err != nil || balance != 10 {
+		t.Errorf("receiver balance: got %d err %v", balance, err)
+	}
+}
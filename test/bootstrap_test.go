@@ -0,0 +1,122 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestExportImportChain(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		mineBlock(t, chain, mock, utils.PublicKeyHash(owner.PublicKey))
+	}
+	tip := chain.LastHash
+	var full, part bytes.Buffer
+	if count, err := chain.ExportChain(&full, 0, -1); err != nil || count != 4 {
+		t.Fatalf("export: %d blocks, %v", count, err)
+	}
+	if count, err := chain.ExportChain(&part, 0, 1); err != nil || count != 2 {
+		t.Fatalf("export part: %d blocks, %v", count, err)
+	}
+	_ = chain.Database.Close()
+
+	// 导入到新的数据目录
+	useTempChain(t)
+	reader, err := blockchain.NewBootstrapReader(bytes.NewReader(part.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, genesis, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := blockchain.InitBlockChainWithGenesis(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = imported.Database.Close()
+	}()
+	importAll := func(data []byte) int {
+		reader, err := blockchain.NewBootstrapReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		added := 0
+		for {
+			_, block, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				return added
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := imported.ImportBlock(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				added++
+			}
+		}
+	}
+	if added := importAll(part.Bytes()); added != 1 {
+		t.Fatalf("imported %d blocks from part", added)
+	}
+	// 前面已经导入的区块被跳过，从中断的地方继续
+	if added := importAll(full.Bytes()); added != 2 || !bytes.Equal(imported.LastHash, tip) {
+		t.Fatalf("imported %d blocks, tip %x", added, imported.LastHash)
+	}
+
+	// 工作量证明不正确的区块不能导入
+	block := blockchain.NewBlockTemplate(imported.LastHash, []*transaction.Transaction{transaction.CoinbaseTx([]byte("miner"), 50, 4)})
+	block.Timestamp = mock.Now().Unix() + 1
+	block.Bits = 255
+	block.SetHash()
+	if _, err = imported.ImportBlock(block); !errors.Is(err, blockchain.ErrInvalidPoW) {
+		t.Fatalf("bad pow: got %v", err)
+	}
+
+	// 导入的区块和挖出的区块一样检查奖励
+	mock.Add(time.Minute)
+	minted := blockchain.CreateBlock(imported.LastHash, []*transaction.Transaction{transaction.CoinbaseTx([]byte("miner"), 1000, 4)})
+	if _, err = imported.ImportBlock(minted); !errors.Is(err, blockchain.ErrInvalidBlock) {
+		t.Fatalf("excess reward: got %v", err)
+	}
+
+	// 截断的文件和其他网络的文件
+	reader, err = blockchain.NewBootstrapReader(bytes.NewReader(full.Bytes()[:full.Len()-10]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, _, err = reader.Next()
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated file: got %v", err)
+	}
+	useParams(t, func(params *chaincfg.Params) {
+		params.Name = chaincfg.TestNet
+	})
+	if _, err = blockchain.NewBootstrapReader(bytes.NewReader(full.Bytes())); err == nil {
+		t.Fatal("imported a file of another network")
+	}
+}
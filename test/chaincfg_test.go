@@ -0,0 +1,48 @@
+package test
+
+import (
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/utils"
+	"testing"
+)
+
+func TestSubsidy(t *testing.T) {
+	params := chaincfg.RegTestParams
+	var tests = []struct {
+		height int
+		want   int
+	}{
+		{height: 0, want: 50},
+		{height: params.SubsidyHalvingInterval - 1, want: 50},
+		{height: params.SubsidyHalvingInterval, want: 25},
+		{height: params.SubsidyHalvingInterval * 2, want: 12},
+		{height: params.SubsidyHalvingInterval * 100, want: 0},
+	}
+	for _, test := range tests {
+		if got := params.Subsidy(test.height); got != test.want {
+			t.Errorf("subsidy at %d: got %d, want %d", test.height, got, test.want)
+		}
+	}
+}
+
+func TestNetworkAddressVersion(t *testing.T) {
+	defer func() {
+		_ = chaincfg.SelectNetwork(chaincfg.MainNet)
+	}()
+	pubKeyHash := utils.PublicKeyHash([]byte("public key"))
+	mainAddress := address.Encode(pubKeyHash)
+
+	if err := chaincfg.SelectNetwork(chaincfg.RegTest); err != nil {
+		t.Fatal(err)
+	}
+	if err := address.Validate(mainAddress); err == nil {
+		t.Errorf("mainnet address %s should be invalid on regtest", mainAddress)
+	}
+	if err := address.Validate(address.Encode(pubKeyHash)); err != nil {
+		t.Errorf("regtest address should be valid: %v", err)
+	}
+	if err := chaincfg.SelectNetwork("unknown"); err == nil {
+		t.Errorf("expect error for unknown network")
+	}
+}
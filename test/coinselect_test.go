@@ -0,0 +1,136 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func generateUTXOs(values ...int) []blockchain.UTXO {
+	utxos := make([]blockchain.UTXO, 0, len(values))
+	for i, v := range values {
+		txID := sha256.Sum256([]byte("utxo" + strconv.Itoa(i)))
+		utxos = append(utxos, blockchain.UTXO{
+			OutPoint: blockchain.OutPoint{TxID: txID[:], OutIdx: 0},
+			Output:   transaction.TxOutput{Value: v},
+		})
+	}
+	return utxos
+}
+
+func sumUTXOs(utxos []blockchain.UTXO) int {
+	sum := 0
+	for _, u := range utxos {
+		sum += u.Value()
+	}
+	return sum
+}
+
+func TestCoinSelect(t *testing.T) {
+	utxos := generateUTXOs(5, 50, 20, 1, 30)
+
+	selected, value, err := blockchain.SelectLargestFirst(utxos, 60)
+	if err != nil || len(selected) != 2 || value != 80 {
+		t.Errorf("largest first: got %d outputs value %d err %v", len(selected), value, err)
+	}
+
+	selected, value, err = blockchain.SelectSmallestFirst(utxos, 20)
+	if err != nil || len(selected) != 3 || value != 26 {
+		t.Errorf("smallest first: got %d outputs value %d err %v", len(selected), value, err)
+	}
+
+	selected, value, err = blockchain.SelectBranchAndBound(utxos, 56)
+	if err != nil || value != 56 || sumUTXOs(selected) != 56 {
+		t.Errorf("bnb exact match: got value %d err %v", value, err)
+	}
+
+	selected, value, err = blockchain.SelectBranchAndBound(utxos, 104)
+	if err != nil || value < 104 || sumUTXOs(selected) != value {
+		t.Errorf("bnb fallback: got value %d err %v", value, err)
+	}
+
+	selected, value, err = blockchain.SelectRandom(utxos, 100)
+	if err != nil || value < 100 || sumUTXOs(selected) != value {
+		t.Errorf("random: got value %d err %v", value, err)
+	}
+
+	if _, _, err = blockchain.SelectLargestFirst(utxos, 1000); err == nil {
+		t.Errorf("expect not enough funds error")
+	}
+}
+
+func TestParseOutPoints(t *testing.T) {
+	points, err := blockchain.ParseOutPoints("0a0b:1, ffee:0")
+	if err != nil || len(points) != 2 || points[0].OutIdx != 1 || points[1].String() != "ffee:0" {
+		t.Errorf("parse outpoints: got %v err %v", points, err)
+	}
+	for _, bad := range []string{"", "0a0b", "zz:1", "0a0b:-1"} {
+		if _, err = blockchain.ParseOutPoints(bad); err == nil {
+			t.Errorf("expect error for %q", bad)
+		}
+	}
+}
+
+// TestSendTwiceBeforeMine 交易池中的交易已经使用的输出不会再被选中，两笔交易都能打包进同一个区块
+func TestSendTwiceBeforeMine(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+	useParams(t, func(params *chaincfg.Params) {
+		params.CoinbaseMaturity = 1
+	})
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	mineBlock(t, chain, mock, pubKeyHash)
+
+	sent := make([]*transaction.Transaction, 0, 2)
+	for i := 0; i < 2; i++ {
+		tx, err := chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		if err = blockchain.AcceptTransaction(tx); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		sent = append(sent, tx)
+	}
+	if bytes.Equal(sent[0].Inputs[0].TxID, sent[1].Inputs[0].TxID) && sent[0].Inputs[0].OutIdx == sent[1].Inputs[0].OutIdx {
+		t.Fatal("two sends use the same output")
+	}
+	// 只有两个输出，第三笔交易没有可用的输出
+	if _, err = chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey); !errors.Is(err, blockchain.ErrInsufficientFunds) {
+		t.Fatalf("third send: got %v", err)
+	}
+
+	pool, err := blockchain.CreatePool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.Add(time.Minute)
+	block, invalid, err := chain.BuildBlockTemplate(pool.Txs, pubKeyHash)
+	if err != nil || len(invalid) != 0 || len(block.Transactions) != 3 {
+		t.Fatalf("template: %d invalid, %v", len(invalid), err)
+	}
+	block.FindNonce()
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+}
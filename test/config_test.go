@@ -0,0 +1,55 @@
+package test
+
+import (
+	"github.com/limitzhang87/goblockchain/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(config.EnvConfigFile, "")
+	t.Setenv(config.EnvNetwork, "")
+	t.Setenv(config.EnvDataDir, dir)
+
+	// 没有配置文件时使用默认值
+	cfg, err := config.Load(config.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Network != "mainnet" || cfg.DataDir != filepath.Join(dir, "mainnet") || cfg.ConfigFile != "" {
+		t.Errorf("default config: got network %s datadir %s config %s", cfg.Network, cfg.DataDir, cfg.ConfigFile)
+	}
+
+	// 根目录下的配置文件
+	content := "network = \"testnet\"\ndatadir = \"" + filepath.Join(dir, "fromfile") + "\"\n"
+	err = os.WriteFile(filepath.Join(dir, config.DefaultConfigFile), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = config.Load(config.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 环境变量中的根目录优先于配置文件
+	if cfg.Network != "testnet" || cfg.BaseDir != dir {
+		t.Errorf("config file: got network %s basedir %s", cfg.Network, cfg.BaseDir)
+	}
+
+	// 命令行参数优先级最高
+	t.Setenv(config.EnvNetwork, "testnet")
+	cfg, err = config.Load(config.Options{Network: "regtest", DataDir: filepath.Join(dir, "flag")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Network != "regtest" || cfg.PoolFile != filepath.Join(dir, "flag", "regtest", "transaction_pool.data") {
+		t.Errorf("flags: got network %s pool file %s", cfg.Network, cfg.PoolFile)
+	}
+
+	// 指定的配置文件不存在时报错
+	_, err = config.Load(config.Options{ConfigFile: filepath.Join(dir, "missing.toml")})
+	if err == nil {
+		t.Errorf("expect error for missing config file")
+	}
+}
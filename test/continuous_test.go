@@ -0,0 +1,266 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContinuousMiner(t *testing.T) {
+	useTempChain(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	minerWallet, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 交易池中放一笔带手续费的交易，手续费归矿工
+	tx, err := chain.BuildTransaction(owner.PublicKey, []blockchain.Payment{{PubKeyHash: utils.PublicKeyHash(minerWallet.PublicKey), Amount: 10}}, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Sign(owner.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	pool := &blockchain.TransactionPool{}
+	if err = pool.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err = pool.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	blocks := make([]*blockchain.Block, 0)
+	cm := &blockchain.ContinuousMiner{
+		Miner:            blockchain.NewMiner(2),
+		RewardPubKeyHash: utils.PublicKeyHash(minerWallet.PublicKey),
+		PollInterval:     10 * time.Millisecond,
+		OnBlock: func(block *blockchain.Block, stats *blockchain.MineStats) {
+			blocks = append(blocks, block)
+			if len(blocks) == 3 {
+				cancel()
+			}
+		},
+	}
+	mined, err := cm.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mined != 3 || len(blocks) != 3 {
+		t.Fatalf("mined %d blocks, want 3", mined)
+	}
+	if len(blocks[0].Transactions) != 2 || len(blocks[1].Transactions) != 1 {
+		t.Errorf("unexpected transaction count %d %d", len(blocks[0].Transactions), len(blocks[1].Transactions))
+	}
+	pool, err = blockchain.CreatePool()
+	if err != nil || len(pool.Txs) != 0 {
+		t.Errorf("pool not cleared: %v", err)
+	}
+
+	chain, err = blockchain.ContinueBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	height, err := chain.Height()
+	if err != nil || height != 3 {
+		t.Errorf("height: got %d err %v", height, err)
+	}
+	params := chaincfg.ActiveParams()
+	want := 10 + 3 + params.Subsidy(1) + params.Subsidy(2) + params.Subsidy(3)
+	balance, _, err := chain.FindUTXOs(minerWallet.PublicKey)
+	if err != nil || balance != want {
+		t.Errorf("miner balance: got %d want %d err %v", balance, want, err)
+	}
+}
+
+// closeSignal 区块链的数据库关闭时发出信号，持续挖矿在创建模板之后关闭数据库
+type closeSignal struct {
+	storage.ChainStore
+	closed chan struct{}
+}
+
+func (c *closeSignal) Close() error {
+	err := c.ChainStore.Close()
+	select {
+	case c.closed <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// TestContinuousMinerTipChanged 挖矿期间连接了其他区块时马上用新的tip重新开始
+func TestContinuousMinerTipChanged(t *testing.T) {
+	useTempChain(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = blockchain.AcceptTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+	// 第一轮的难度高到挖不出来，只能因为tip变化而重新开始
+	useParams(t, func(params *chaincfg.Params) {
+		params.Difficulty = 40
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	templates := make(chan struct{}, 1)
+	reasons := make([]string, 0)
+	var mined *blockchain.Block
+	cm := &blockchain.ContinuousMiner{
+		Miner:            blockchain.NewMiner(2),
+		RewardPubKeyHash: pubKeyHash,
+		PollInterval:     10 * time.Millisecond,
+		OpenChain: func() (*blockchain.BlockChain, error) {
+			bc, err := blockchain.ContinueBlockChain()
+			if err != nil {
+				return nil, err
+			}
+			bc.Database = &closeSignal{ChainStore: bc.Database, closed: templates}
+			return bc, nil
+		},
+		OnRestart: func(reason string) {
+			reasons = append(reasons, reason)
+		},
+		OnBlock: func(block *blockchain.Block, stats *blockchain.MineStats) {
+			mined = block
+			cancel()
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := cm.Run(ctx)
+		done <- err
+	}()
+
+	// 第一个模板创建之后降低难度，连接一个竞争区块
+	select {
+	case <-templates:
+	case <-ctx.Done():
+		t.Fatal("no template")
+	}
+	chaincfg.ActiveParams().Difficulty = 1
+	chain, err = blockchain.ContinueBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _, err := chain.BuildBlockTemplate(nil, pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.FindNonce()
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) == 0 || reasons[0] != "chain tip changed" {
+		t.Fatalf("restart reasons: %v", reasons)
+	}
+	if mined == nil || !bytes.Equal(mined.PrevHash, block.Hash) || len(mined.Transactions) != 2 {
+		t.Fatalf("mined block is not on the competing block: %+v", mined)
+	}
+}
+
+// TestContinuousMinerErrors 打开区块链的错误不是数据库被占用时马上返回，区块加入之后清理交易池失败不影响挖出的区块
+func TestContinuousMinerErrors(t *testing.T) {
+	useTempChain(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+
+	// 轮询间隔很长，重试的话测试会超时
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	errBroken := errors.New("broken database")
+	cm := &blockchain.ContinuousMiner{
+		Miner:            blockchain.NewMiner(1),
+		RewardPubKeyHash: pubKeyHash,
+		PollInterval:     time.Hour,
+		Blocks:           1,
+		OpenChain: func() (*blockchain.BlockChain, error) {
+			return nil, errBroken
+		},
+	}
+	if _, err = cm.Run(ctx); !errors.Is(err, errBroken) {
+		t.Fatalf("broken database: got %v", err)
+	}
+
+	// 提交区块时交易池文件已经损坏
+	var log bytes.Buffer
+	opened := 0
+	cm.OpenChain = func() (*blockchain.BlockChain, error) {
+		opened++
+		if opened == 2 {
+			if err := os.MkdirAll(config.Active().DataDir, 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(config.Active().PoolFile, []byte("broken"), 0644); err != nil {
+				return nil, err
+			}
+		}
+		bc, err := blockchain.ContinueBlockChain()
+		if err != nil {
+			return nil, err
+		}
+		bc.Log = &log
+		return bc, nil
+	}
+	reported := 0
+	cm.OnBlock = func(block *blockchain.Block, stats *blockchain.MineStats) {
+		reported++
+	}
+	mined, err := cm.Run(ctx)
+	if err != nil || mined != 1 || reported != 1 {
+		t.Fatalf("mined %d, reported %d, %v", mined, reported, err)
+	}
+	if !strings.Contains(log.String(), "Remove mined transactions from the pool") {
+		t.Fatalf("log: %q", log.String())
+	}
+}
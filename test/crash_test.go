@@ -0,0 +1,221 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+var errCrash = errors.New("injected crash")
+
+// faultStore 在第failAt次写入时返回errCrash，模拟写到一半时进程退出
+// crashAfterCommit为true时事务正常提交，但是返回errCrash，模拟提交之后进程马上退出
+type faultStore struct {
+	storage.ChainStore
+	failAt           int
+	writes           int
+	crashAfterCommit bool
+}
+
+func (s *faultStore) Update(fn func(tx storage.Tx) error) error {
+	err := s.ChainStore.Update(func(tx storage.Tx) error {
+		return fn(&faultTx{Tx: tx, store: s})
+	})
+	if err == nil && s.crashAfterCommit {
+		return errCrash
+	}
+	return err
+}
+
+type faultTx struct {
+	storage.Tx
+	store *faultStore
+}
+
+func (t *faultTx) write() error {
+	t.store.writes++
+	if t.store.writes == t.store.failAt {
+		return errCrash
+	}
+	return nil
+}
+
+func (t *faultTx) Set(key, value []byte) error {
+	if err := t.write(); err != nil {
+		return err
+	}
+	return t.Tx.Set(key, value)
+}
+
+func (t *faultTx) Delete(key []byte) error {
+	if err := t.write(); err != nil {
+		return err
+	}
+	return t.Tx.Delete(key)
+}
+
+// TestCrashSafeConnect 在连接区块的每一次写入处中断，重新打开后区块链都停在原来的最新区块，UTXO集合和区块一致
+func TestCrashSafeConnect(t *testing.T) {
+	for _, backend := range storage.Backends() {
+		t.Run(backend, func(t *testing.T) {
+			useTempChainWith(t, backend)
+			mock := useMockClock(t)
+
+			owner, err := wallet.NewWallet()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+			chain, err := blockchain.InitBlockChain(pubKeyHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mineBlock(t, chain, mock, pubKeyHash)
+			balance, _, err := chain.FindUTXOs(owner.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tip := chain.LastHash
+
+			// 包含一笔花费的区块，连接时要删除和写入UTXO
+			tx, err := chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mock.Add(time.Minute)
+			block, _, err := chain.BuildBlockTemplate([]*transaction.Transaction{tx}, pubKeyHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.FindNonce()
+
+			reopen := func() {
+				t.Helper()
+				_ = chain.Database.Close()
+				if chain, err = blockchain.ContinueBlockChain(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for failAt := 1; ; failAt++ {
+				store := &faultStore{ChainStore: chain.Database, failAt: failAt}
+				chain.Database = store
+				err = chain.AddBlock(block)
+				if err == nil {
+					// 所有写入都完成了，写入次数比failAt少
+					if failAt < 5 {
+						t.Fatalf("connected after %d writes", store.writes)
+					}
+					chain.Database = store.ChainStore
+					break
+				}
+				if !errors.Is(err, errCrash) {
+					t.Fatalf("crash at write %d: got %v", failAt, err)
+				}
+				reopen()
+				if !bytes.Equal(chain.LastHash, tip) {
+					t.Fatalf("crash at write %d: tip moved to %x", failAt, chain.LastHash)
+				}
+				if height, err := chain.Height(); err != nil || height != 1 {
+					t.Fatalf("crash at write %d: height %d, %v", failAt, height, err)
+				}
+				if got, _, err := chain.FindUTXOs(owner.PublicKey); err != nil || got != balance {
+					t.Fatalf("crash at write %d: balance %d, want %d, %v", failAt, got, balance, err)
+				}
+				if result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() {
+					t.Fatalf("crash at write %d: verify %+v, %v", failAt, result, err)
+				}
+			}
+			if height, err := chain.Height(); err != nil || height != 2 {
+				t.Fatalf("height %d after connect, %v", height, err)
+			}
+
+			// 提交之后退出，重新打开时区块已经连接
+			mock.Add(time.Minute)
+			next, _, err := chain.BuildBlockTemplate(nil, pubKeyHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next.FindNonce()
+			chain.Database = &faultStore{ChainStore: chain.Database, crashAfterCommit: true}
+			if err = chain.AddBlock(next); !errors.Is(err, errCrash) {
+				t.Fatalf("crash after commit: got %v", err)
+			}
+			chain.Database = chain.Database.(*faultStore).ChainStore
+			reopen()
+			defer func() {
+				_ = chain.Database.Close()
+			}()
+			if !bytes.Equal(chain.LastHash, next.Hash) {
+				t.Fatalf("crash after commit: tip %x, want %x", chain.LastHash, next.Hash)
+			}
+			if result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() {
+				t.Fatalf("crash after commit: verify %+v, %v", result, err)
+			}
+		})
+	}
+}
+
+// TestRecoverChainState 高度索引和最新区块不一致时，打开区块链会由区块体重建
+func TestRecoverChainState(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		mineBlock(t, chain, mock, pubKeyHash)
+	}
+
+	corruptions := map[string]func(txn storage.Tx) error{
+		"stale tip height": func(txn storage.Tx) error {
+			return txn.Set([]byte(constcoe.TipHeightKey), []byte{2})
+		},
+		"index beyond tip": func(txn storage.Tx) error {
+			return txn.Set(append([]byte(constcoe.HeightPrefix), 0, 0, 0, 0, 0, 0, 0, 4), []byte("next"))
+		},
+		"missing tip index": func(txn storage.Tx) error {
+			return txn.Delete(append([]byte(constcoe.HeightPrefix), 0, 0, 0, 0, 0, 0, 0, 3))
+		},
+	}
+	for name, corrupt := range corruptions {
+		if err = chain.Database.Update(corrupt); err != nil {
+			t.Fatal(err)
+		}
+		_ = chain.Database.Close()
+		if chain, err = blockchain.ContinueBlockChain(); err != nil || !chain.Repaired {
+			t.Fatalf("%s: repaired %v, %v", name, chain != nil && chain.Repaired, err)
+		}
+		if height, err := chain.Height(); err != nil || height != 3 {
+			t.Fatalf("%s: height %d after recovery, %v", name, height, err)
+		}
+		if result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() {
+			t.Fatalf("%s: verify %+v, %v", name, result, err)
+		}
+	}
+
+	// 最新区块缺失时无法修复
+	err = chain.Database.Update(func(txn storage.Tx) error {
+		return txn.Delete([]byte(constcoe.LHKey))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+	if _, err = blockchain.ContinueBlockChain(); !errors.Is(err, blockchain.ErrCorruptChainState) {
+		t.Fatalf("missing tip: got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/daemon"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDaemonSerializesCommands 多个客户端同时通过守护进程加入区块，每个命令都在锁中读到最新区块，不会互相冲突
+func TestDaemonSerializesCommands(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+
+	socket := filepath.Join(t.TempDir(), "daemon.sock")
+	if err = daemon.Run(context.Background(), socket, daemon.Request{Args: []string{"height"}}, io.Discard); !errors.Is(err, daemon.ErrNotRunning) {
+		t.Fatalf("run without daemon: got %v", err)
+	}
+
+	chain, err = blockchain.ContinueBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := daemon.NewServer(chain)
+	server.Exec = func(ctx context.Context, req daemon.Request, out io.Writer) error {
+		chain, err := server.OpenChain()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = chain.Database.Close()
+		}()
+		switch req.Args[0] {
+		case "mine":
+			mock.Add(time.Minute)
+			block, _, err := chain.BuildBlockTemplate(nil, pubKeyHash)
+			if err != nil {
+				return err
+			}
+			block.FindNonce()
+			if err = chain.AddBlock(block); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "mined %x\n", block.Hash)
+			return nil
+		case "height":
+			height, err := chain.Height()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, height)
+			return nil
+		}
+		fmt.Fprintln(out, "unknown command")
+		return fmt.Errorf("unknown command %q", req.Args[0])
+	}
+	listener, err := daemon.Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, listener)
+	}()
+	if _, err = daemon.Listen(socket); !errors.Is(err, daemon.ErrAlreadyRunning) {
+		t.Fatalf("listen twice: got %v", err)
+	}
+
+	const clients = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out bytes.Buffer
+			err := daemon.Run(context.Background(), socket, daemon.Request{Args: []string{"mine"}}, &out)
+			if err == nil && !bytes.HasPrefix(out.Bytes(), []byte("mined ")) {
+				err = fmt.Errorf("unexpected output %q", out.String())
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err = daemon.Run(context.Background(), socket, daemon.Request{Args: []string{"height"}}, &out); err != nil || out.String() != fmt.Sprintln(clients) {
+		t.Fatalf("height: got %q, %v", out.String(), err)
+	}
+	// 命令的输出和错误都返回给客户端
+	out.Reset()
+	err = daemon.Run(context.Background(), socket, daemon.Request{Args: []string{"other"}}, &out)
+	if err == nil || err.Error() != `unknown command "other"` || out.String() != "unknown command\n" {
+		t.Fatalf("failed command: got %q, %v", out.String(), err)
+	}
+
+	cancel()
+	if err = <-served; err != nil {
+		t.Fatal(err)
+	}
+	if err = daemon.Run(context.Background(), socket, daemon.Request{Args: []string{"height"}}, io.Discard); !errors.Is(err, daemon.ErrNotRunning) {
+		t.Fatalf("run after stop: got %v", err)
+	}
+
+	// 守护进程退出后可以直接打开区块链
+	chain, err = blockchain.ContinueBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	if result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() || result.Height != clients {
+		t.Fatalf("verify: %+v, %v", result, err)
+	}
+}
+
+// TestRunMineReleasesChain 挖矿期间不占用守护进程的区块链，其他命令可以执行
+func TestRunMineReleasesChain(t *testing.T) {
+	useTempChain(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	server := daemon.NewServer(chain)
+	// 难度高到挖不出来，只能被取消
+	useParams(t, func(params *chaincfg.Params) {
+		params.Difficulty = 40
+	})
+
+	templates := make(chan struct{}, 1)
+	openChain := func() (*blockchain.BlockChain, error) {
+		bc, err := server.OpenChain()
+		if err != nil {
+			return nil, err
+		}
+		bc.Database = &closeSignal{ChainStore: bc.Database, closed: templates}
+		return bc, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := blockchain.RunMine(ctx, openChain, blockchain.NewMiner(1), pubKeyHash)
+		done <- err
+	}()
+	select {
+	case <-templates:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no template")
+	}
+
+	opened := make(chan error, 1)
+	go func() {
+		bc, err := server.OpenChain()
+		if err == nil {
+			_ = bc.Database.Close()
+		}
+		opened <- err
+	}()
+	select {
+	case err = <-opened:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the chain is held while mining")
+	}
+	cancel()
+	if err = <-done; !errors.Is(err, blockchain.ErrMiningCanceled) {
+		t.Fatalf("mine: got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"bytes"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+func TestBlockHashFromHeader(t *testing.T) {
+	txs := []*transaction.Transaction{
+		GenerateTransaction(10, "LLL", "CCC", "prev1", 0),
+		GenerateTransaction(20, "EEE", "OOO", "prev2", 1),
+	}
+	block := blockchain.CreateBlock([]byte("prev"), txs)
+	header, err := blockchain.DeserializeBlockHeader(block.Header().Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(header.BlockHash(), block.Hash) || !header.ValidatePoW() {
+		t.Error("block hash is not determined by the header")
+	}
+	if !bytes.Equal(header.MerkleRoot, block.MerkleTree().Root()) {
+		t.Error("header merkle root differs from the tree")
+	}
+
+	// 修改交易而不更新区块头，区块不再有效
+	block.Transactions = txs[:1]
+	block.Nonce++
+	if block.ValidatePoW() {
+		t.Error("block with stale hash is still valid")
+	}
+}
+
+func TestHeaderIterator(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	for i := 1; i <= 3; i++ {
+		coinbase := transaction.CoinbaseTx(utils.PublicKeyHash(owner.PublicKey), 50, i)
+		mock.Add(time.Minute)
+		if err = chain.AddBlock(blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{coinbase})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ogPrevHash, err := chain.BackOgPrevHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	iter := chain.HeaderIterator()
+	for {
+		hash, header, err := iter.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if !bytes.Equal(header.BlockHash(), hash) {
+			t.Errorf("header %x hashes to %x", hash, header.BlockHash())
+		}
+		if bytes.Equal(header.PrevHash, ogPrevHash) {
+			break
+		}
+	}
+	if count != 4 {
+		t.Errorf("walked %d headers, want 4", count)
+	}
+
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil || len(block.Transactions) != 1 || !block.ValidatePoW() {
+		t.Errorf("get tip block: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"github.com/limitzhang87/goblockchain/wire"
+	"math"
+	"os"
+	"testing"
+)
+
+// useParams 修改当前网络的参数，测试结束后恢复
+func useParams(t *testing.T, modify func(params *chaincfg.Params)) {
+	t.Helper()
+	params := chaincfg.ActiveParams()
+	saved := *params
+	modify(params)
+	t.Cleanup(func() {
+		*params = saved
+	})
+}
+
+func TestTransactionSanity(t *testing.T) {
+	useTempChain(t)
+	useParams(t, func(params *chaincfg.Params) {
+		params.MaxTxOutputs = 2
+	})
+
+	tx := GenerateTransaction(10, "AAA", "BBB", "prev", 0)
+	if err := blockchain.CheckTransactionSanity(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(tx *transaction.Transaction){
+		"dust":      func(tx *transaction.Transaction) { tx.Outputs[0].Value = 0 },
+		"negative":  func(tx *transaction.Transaction) { tx.Outputs[0].Value = -1 },
+		"no output": func(tx *transaction.Transaction) { tx.Outputs = nil },
+		"too many outputs": func(tx *transaction.Transaction) {
+			tx.Outputs = append(tx.Outputs, tx.Outputs[0], tx.Outputs[0])
+		},
+		"overflow": func(tx *transaction.Transaction) {
+			tx.Outputs[0].Value = math.MaxInt
+			tx.Outputs = append(tx.Outputs, tx.Outputs[0])
+		},
+		"duplicate input": func(tx *transaction.Transaction) { tx.Inputs = append(tx.Inputs, tx.Inputs[0]) },
+	}
+	for name, modify := range tests {
+		tx := GenerateTransaction(10, "AAA", "BBB", "prev", 0)
+		modify(tx)
+		if err := blockchain.CheckTransactionSanity(tx); !errors.Is(err, blockchain.ErrInvalidTransaction) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	useTempChain(t)
+	tx := GenerateTransaction(10, "AAA", "BBB", "prev", 0)
+	tx.Outputs = append(tx.Outputs, tx.Outputs[0], tx.Outputs[0])
+	data := tx.Serialize()
+	if _, err := transaction.DeserializeTransaction(data); err != nil {
+		t.Fatal(err)
+	}
+
+	useParams(t, func(params *chaincfg.Params) {
+		params.MaxTxOutputs = 2
+	})
+	if _, err := transaction.DeserializeTransaction(data); !errors.Is(err, wire.ErrTooLarge) {
+		t.Fatalf("too many outputs: got %v", err)
+	}
+	useParams(t, func(params *chaincfg.Params) {
+		params.MaxTxSize = len(data) - 1
+	})
+	if _, err := transaction.DeserializeTransaction(data); !errors.Is(err, wire.ErrTooLarge) {
+		t.Fatalf("too large: got %v", err)
+	}
+}
+
+func TestBlockSanity(t *testing.T) {
+	useTempChain(t)
+	coinbase := transaction.CoinbaseTx([]byte("miner"), 50, 1)
+	tx := GenerateTransaction(10, "AAA", "BBB", "prev", 0)
+
+	block := blockchain.NewBlockTemplate([]byte("prev"), []*transaction.Transaction{coinbase, tx})
+	if err := blockchain.CheckBlockSanity(block); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]*transaction.Transaction{
+		"duplicate transaction": {coinbase, tx, tx},
+		"second coinbase":       {coinbase, tx, transaction.CoinbaseTx([]byte("miner"), 50, 2)},
+		"no transaction":        {},
+	}
+	for name, txs := range tests {
+		block := blockchain.NewBlockTemplate([]byte("prev"), txs)
+		if err := blockchain.CheckBlockSanity(block); !errors.Is(err, blockchain.ErrInvalidBlock) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	block.MerkleRoot = []byte("fake")
+	if err := blockchain.CheckBlockSanity(block); !errors.Is(err, blockchain.ErrInvalidMerkleRoot) {
+		t.Errorf("bad merkle root: got %v", err)
+	}
+
+	useParams(t, func(params *chaincfg.Params) {
+		params.MaxBlockSize = 100
+	})
+	block = blockchain.NewBlockTemplate([]byte("prev"), []*transaction.Transaction{coinbase, tx})
+	if err := blockchain.CheckBlockSanity(block); !errors.Is(err, blockchain.ErrInvalidBlock) {
+		t.Errorf("too large: got %v", err)
+	}
+}
+
+func TestPoolPolicy(t *testing.T) {
+	useTempChain(t)
+	config.Active().MaxPoolTxs = 2
+	if err := os.MkdirAll(config.Active().DataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := &blockchain.TransactionPool{}
+	txs := make([]*transaction.Transaction, 0, 3)
+	for i := 0; i < 3; i++ {
+		prevTxID := sha256.Sum256([]byte(fmt.Sprintf("prev%d", i)))
+		tx := &transaction.Transaction{
+			Version: transaction.TxVersion,
+			Inputs:  []transaction.TxInput{{TxID: prevTxID[:], PubKey: owner.PublicKey}},
+			Outputs: []transaction.TxOutput{{Value: 10, PubKeyHash: []byte("BBB")}},
+		}
+		tx.SetId()
+		if err = tx.Sign(owner.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	// 没有签名的交易不能进入交易池
+	if err = pool.AddTransaction(GenerateTransaction(10, "AAA", "BBB", "prev", 0)); !errors.Is(err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("unsigned: got %v", err)
+	}
+	if err := pool.AddTransaction(txs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddTransaction(txs[0]); !errors.Is(err, blockchain.ErrTransactionInPool) {
+		t.Fatalf("duplicate: got %v", err)
+	}
+	if err := pool.AddTransaction(txs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddTransaction(txs[2]); !errors.Is(err, blockchain.ErrPoolFull) {
+		t.Fatalf("pool full: got %v", err)
+	}
+	if err := pool.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := blockchain.CreatePool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Txs) != 2 || !bytes.Equal(loaded.Txs[1].ID, txs[1].ID) {
+		t.Fatalf("loaded %d transactions", len(loaded.Txs))
+	}
+
+	// 旧版本使用gob编码的交易池文件
+	var buffer bytes.Buffer
+	if err = gob.NewEncoder(&buffer).Encode(pool); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(config.Active().PoolFile, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = blockchain.CreatePool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Txs) != 2 {
+		t.Fatalf("loaded %d transactions from gob file", len(loaded.Txs))
+	}
+
+	config.Active().MaxPoolSize = 10
+	if _, err = blockchain.CreatePool(); !errors.Is(err, wire.ErrTooLarge) {
+		t.Fatalf("pool file too large: got %v", err)
+	}
+	if err = (&blockchain.TransactionPool{}).AddTransaction(txs[2]); !errors.Is(err, blockchain.ErrPoolFull) {
+		t.Fatalf("pool size: got %v", err)
+	}
+}
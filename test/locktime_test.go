@@ -0,0 +1,227 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/clock"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+func TestLockTime(t *testing.T) {
+	tx := GenerateTransaction(10, "LLL", "CCC", "prev1", 0)
+	tx.Version = transaction.TxVersion
+	tx.SetLockTime(100)
+	if tx.IsFinal(100, 0) || !tx.IsFinal(101, 0) {
+		t.Error("height lock time")
+	}
+	tx.SetLockTime(transaction.LockTimeThreshold + 100)
+	if tx.IsFinal(1000, transaction.LockTimeThreshold+100) || !tx.IsFinal(0, transaction.LockTimeThreshold+101) {
+		t.Error("time lock time")
+	}
+
+	// 所有交易输入都是SequenceFinal时锁定时间不生效
+	tx.Inputs[0].Sequence = transaction.SequenceFinal
+	if !tx.IsFinal(0, 0) {
+		t.Error("final sequence should disable lock time")
+	}
+	tx.SetLockTime(0)
+	if !tx.IsFinal(0, 0) {
+		t.Error("zero lock time should be final")
+	}
+
+	tx.SetLockTime(100)
+	data := tx.Serialize()
+	decoded, err := transaction.DeserializeTransaction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.LockTime != 100 || decoded.Inputs[0].Sequence != transaction.SequenceFinal-1 || !bytes.Equal(decoded.Serialize(), data) {
+		t.Errorf("unexpected decoded transaction %+v", decoded)
+	}
+
+	// 旧版本交易没有锁定时间，哈希保持不变
+	old := GenerateTransaction(10, "LLL", "CCC", "prev1", 0)
+	old.Version = transaction.WireVersion
+	hash := old.TxHash()
+	old.LockTime = 100
+	if !bytes.Equal(hash, old.TxHash()) || !old.IsFinal(0, 0) {
+		t.Error("wire version transaction should ignore lock time")
+	}
+}
+
+// mineBlock 在链的最后添加一个只有挖矿奖励交易的区块
+func mineBlock(t *testing.T, chain *blockchain.BlockChain, mock *clock.Mock, pubKeyHash []byte) {
+	t.Helper()
+	mock.Add(time.Minute)
+	block, _, err := chain.BuildBlockTemplate(nil, pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.FindNonce()
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+	useParams(t, func(params *chaincfg.Params) {
+		params.CoinbaseMaturity = 2
+	})
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+
+	// 高度为1的挖矿奖励，高度为3的区块才能使用
+	mineBlock(t, chain, mock, utils.PublicKeyHash(miner.PublicKey))
+	utxos, err := chain.FindUTXOList(miner.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || !utxos[0].Coinbase || utxos[0].Height != 1 || utxos[0].Mature(2) || !utxos[0].Mature(3) {
+		t.Fatalf("unexpected coinbase outputs %+v", utxos)
+	}
+
+	_, err = chain.CreateTransaction(miner.PublicKey, utils.PublicKeyHash(owner.PublicKey), 10, miner.PrivateKey)
+	if !errors.Is(err, blockchain.ErrInsufficientFunds) {
+		t.Fatalf("select immature coinbase: got %v", err)
+	}
+	control := &blockchain.CoinControl{UTXOs: []blockchain.OutPoint{utxos[0].OutPoint}}
+	_, err = chain.CreateTransactionWithControl(miner.PublicKey, utils.PublicKeyHash(owner.PublicKey), 10, miner.PrivateKey, control)
+	if !errors.Is(err, blockchain.ErrImmatureCoinbase) {
+		t.Fatalf("spend immature coinbase: got %v", err)
+	}
+	// 其他节点挖出的区块也不能花费没有成熟的挖矿奖励
+	immature := &transaction.Transaction{
+		Version: transaction.TxVersion,
+		Inputs: []transaction.TxInput{{
+			TxID: utxos[0].TxID, OutIdx: utxos[0].OutIdx, PubKey: miner.PublicKey, Sequence: transaction.SequenceFinal,
+		}},
+		Outputs: []transaction.TxOutput{{Value: 10, PubKeyHash: utils.PublicKeyHash(owner.PublicKey)}},
+	}
+	immature.SetId()
+	if err = immature.Sign(miner.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	mock.Add(time.Minute)
+	if err = chain.AddBlock(blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{immature})); !errors.Is(err, blockchain.ErrImmatureCoinbase) {
+		t.Fatalf("block spending immature coinbase: got %v", err)
+	}
+
+	// 创世区块的金额可以直接使用
+	if _, err = chain.CreateTransaction(owner.PublicKey, utils.PublicKeyHash(miner.PublicKey), 10, owner.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	mineBlock(t, chain, mock, utils.PublicKeyHash(owner.PublicKey))
+	tx, err := chain.CreateTransaction(miner.PublicKey, utils.PublicKeyHash(owner.PublicKey), 10, miner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = chain.VerityTransaction([]*transaction.Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 输入来自两个地址的交易，每个输入用自己的私钥签名
+	ownerUTXOs, err := chain.FindUTXOList(owner.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var genesisUTXO blockchain.UTXO
+	for _, utxo := range ownerUTXOs {
+		if utxo.Height == 0 {
+			genesisUTXO = utxo
+		}
+	}
+	joint := &transaction.Transaction{
+		Version: transaction.TxVersion,
+		Inputs: []transaction.TxInput{
+			{TxID: utxos[0].TxID, OutIdx: utxos[0].OutIdx, PubKey: miner.PublicKey, Sequence: transaction.SequenceFinal},
+			{TxID: genesisUTXO.TxID, OutIdx: genesisUTXO.OutIdx, PubKey: owner.PublicKey, Sequence: transaction.SequenceFinal},
+		},
+		Outputs: []transaction.TxOutput{{Value: 20, PubKeyHash: []byte("receiver")}},
+	}
+	joint.SetId()
+	for idx, key := range []*wallet.Wallet{miner, owner} {
+		if joint.Inputs[idx].Sig, err = utils.Sign(joint.PlainHash(idx, joint.Inputs[idx].PubKey), key.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = chain.VerityTransaction([]*transaction.Transaction{joint}); err != nil {
+		t.Fatalf("inputs of two owners: %v", err)
+	}
+	unsigned := *joint
+	unsigned.Inputs = append([]transaction.TxInput(nil), joint.Inputs...)
+	unsigned.Inputs[1].Sig = nil
+	if err = chain.VerityTransaction([]*transaction.Transaction{&unsigned}); !errors.Is(err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("unsigned input: got %v", err)
+	}
+}
+
+func TestLockTimeValidation(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+
+	// 锁定到高度2，只能打包到高度3的区块中
+	control := &blockchain.CoinControl{LockTime: 2}
+	tx, err := chain.CreateTransactionWithControl(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey, control)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.Verity() {
+		t.Fatal("lock time transaction signature")
+	}
+	if err = chain.VerityTransaction([]*transaction.Transaction{tx}); !errors.Is(err, blockchain.ErrNonFinalTransaction) {
+		t.Fatalf("non final transaction: got %v", err)
+	}
+	block, invalid, err := chain.BuildBlockTemplate([]*transaction.Transaction{tx}, utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) != 1 || len(invalid) != 0 {
+		t.Fatalf("template has %d transactions, %d invalid", len(block.Transactions), len(invalid))
+	}
+	mock.Add(time.Minute)
+	if err = chain.AddBlock(blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{tx})); !errors.Is(err, blockchain.ErrNonFinalTransaction) {
+		t.Fatalf("block with non final transaction: got %v", err)
+	}
+
+	mineBlock(t, chain, mock, utils.PublicKeyHash(owner.PublicKey))
+	mineBlock(t, chain, mock, utils.PublicKeyHash(owner.PublicKey))
+	if err = chain.VerityTransaction([]*transaction.Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+
+}
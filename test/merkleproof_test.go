@@ -0,0 +1,143 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/merkletree"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"testing"
+)
+
+func merkleTestTxs(n int) []*transaction.Transaction {
+	txs := make([]*transaction.Transaction, 0, n)
+	for i := 0; i < n; i++ {
+		txs = append(txs, GenerateTransaction(i+1, "AAA", "BBB", fmt.Sprintf("prev%d", i), 0))
+	}
+	return txs
+}
+
+func merkleTestLeaves(n int) [][]byte {
+	leaves := make([][]byte, 0, n)
+	for _, tx := range merkleTestTxs(n) {
+		leaves = append(leaves, tx.ID)
+	}
+	return leaves
+}
+
+func TestMerkleProof(t *testing.T) {
+	for _, version := range []int{merkletree.LegacyVersion, merkletree.Version} {
+		testMerkleProof(t, version)
+	}
+
+	tree := merkletree.CreateMerkleTree(merkleTestTxs(3))
+	if _, err := tree.Proof(3); err == nil {
+		t.Fatal("expect error for index out of range")
+	}
+	empty := merkletree.CreateMerkleTree(nil)
+	if !bytes.Equal(empty.Root(), merkletree.EmptyRoot()) {
+		t.Fatal("root of empty tree is not the empty root")
+	}
+	if _, err := empty.Proof(0); err == nil {
+		t.Fatal("expect error for proof of empty tree")
+	}
+}
+
+func testMerkleProof(t *testing.T, version int) {
+	for n := 1; n <= 17; n++ {
+		tree := merkletree.New(version, merkleTestLeaves(n))
+		root := tree.Root()
+		for idx := 0; idx < n; idx++ {
+			proof, err := tree.Proof(idx)
+			if err != nil {
+				t.Fatal(n, idx, err)
+			}
+			if !proof.Verify(root) {
+				t.Fatalf("proof of leaf %d/%d is invalid", idx, n)
+			}
+			if len(proof.Hashes) > 6 {
+				t.Fatalf("proof of leaf %d/%d has %d hashes", idx, n, len(proof.Hashes))
+			}
+
+			tampered := *proof
+			tampered.Leaves = [][]byte{[]byte("fake")}
+			if tampered.Verify(root) {
+				t.Fatalf("tampered leaf %d/%d is valid", idx, n)
+			}
+			if len(proof.Hashes) > 0 {
+				tampered = *proof
+				tampered.Hashes = append([][]byte{[]byte("fake")}, proof.Hashes[1:]...)
+				if tampered.Verify(root) {
+					t.Fatalf("tampered hash %d/%d is valid", idx, n)
+				}
+			}
+		}
+
+		// 多个叶子的证明
+		all := make([]int, 0, n)
+		for idx := n - 1; idx >= 0; idx -= 2 {
+			all = append(all, idx)
+		}
+		proof, err := tree.Proof(all...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.Verify(root) {
+			t.Fatalf("multi proof of %v/%d is invalid", all, n)
+		}
+		if n > 1 && proof.Verify(tree.Nodes[0]) {
+			t.Fatal("proof is valid for the wrong root")
+		}
+	}
+}
+
+// TestMerkleDuplicateLeaf 旧规则中复制最后一个叶子和原来的树有同样的merkle根
+func TestMerkleDuplicateLeaf(t *testing.T) {
+	leaves := merkleTestLeaves(3)
+	duplicated := append(merkleTestLeaves(3), leaves[2])
+	legacy := merkletree.New(merkletree.LegacyVersion, leaves)
+	if !bytes.Equal(legacy.Root(), merkletree.New(merkletree.LegacyVersion, duplicated).Root()) {
+		t.Fatal("legacy tree is expected to be ambiguous")
+	}
+	if bytes.Equal(merkletree.New(merkletree.Version, leaves).Root(), merkletree.New(merkletree.Version, duplicated).Root()) {
+		t.Fatal("duplicated leaf gives the same root")
+	}
+
+	// 中间结点不能被当成叶子
+	tree := merkletree.New(merkletree.Version, merkleTestLeaves(4))
+	inner := merkletree.New(merkletree.Version, [][]byte{tree.Nodes[4], tree.Nodes[5]})
+	if bytes.Equal(tree.Root(), inner.Root()) {
+		t.Fatal("inner nodes as leaves give the same root")
+	}
+}
+
+func TestMerkleProofEncoding(t *testing.T) {
+	tree := merkletree.CreateMerkleTree(merkleTestTxs(11))
+	proof, err := tree.Proof(2, 7, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := merkletree.DeserializeMerkleProof(proof.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Serialize(), proof.Serialize()) || !decoded.Verify(tree.Root()) {
+		t.Fatal("binary round trip changed the proof")
+	}
+	if _, err = merkletree.DeserializeMerkleProof(append(proof.Serialize(), 0)); err == nil {
+		t.Fatal("expect error for trailing data")
+	}
+
+	data, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON merkletree.MerkleProof
+	if err = json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fromJSON.Serialize(), proof.Serialize()) || !fromJSON.Verify(tree.Root()) {
+		t.Fatal("json round trip changed the proof")
+	}
+}
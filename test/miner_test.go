@@ -0,0 +1,63 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/merkletree"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"testing"
+	"time"
+)
+
+func TestMiner(t *testing.T) {
+	tx := GenerateTransaction(10, "LLL", "CCC", "prev1", 0)
+	block := blockchain.NewBlockTemplate([]byte("prev"), []*transaction.Transaction{tx})
+
+	stats, err := blockchain.NewMiner(4).Mine(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !block.ValidatePoW() || len(block.Hash) == 0 || stats.Hashes == 0 {
+		t.Errorf("mined block is not valid, stats %+v", stats)
+	}
+}
+
+func TestMinerRollsExtraNonce(t *testing.T) {
+	coinbase := transaction.CoinbaseTx([]byte("miner"), 50, 1)
+	coinbaseID := coinbase.ID
+	tx := GenerateTransaction(10, "LLL", "CCC", "prev1", 0)
+	block := blockchain.NewBlockTemplate([]byte("prev"), []*transaction.Transaction{coinbase, tx})
+	timestamp := block.Timestamp
+
+	// nonce空间很小并且时间不变，只能通过ExtraNonce找到结果
+	miner := &blockchain.Miner{Workers: 2, MaxNonce: 4, Now: func() int64 { return timestamp }}
+	stats, err := miner.Mine(context.Background(), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !block.ValidatePoW() || block.Nonce >= 4 || block.Timestamp != timestamp {
+		t.Errorf("unexpected block nonce %d timestamp %d", block.Nonce, block.Timestamp)
+	}
+	// ExtraNonce在挖矿奖励交易中，修改后交易ID和merkle根都会变化
+	if stats.Rounds > 1 && bytes.Equal(coinbase.ID, coinbaseID) {
+		t.Errorf("extra nonce not rolled after %d rounds", stats.Rounds)
+	}
+	if !bytes.Equal(block.MerkleRoot, merkletree.CreateMerkleTree(block.Transactions).Root()) {
+		t.Error("merkle root not updated")
+	}
+}
+
+func TestMinerCancel(t *testing.T) {
+	tx := GenerateTransaction(10, "LLL", "CCC", "prev1", 0)
+	block := blockchain.NewBlockTemplate([]byte("prev"), []*transaction.Transaction{tx})
+	block.Bits = 256 // 目标为1，不可能找到结果
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := blockchain.NewMiner(2).Mine(ctx, block)
+	if !errors.Is(err, blockchain.ErrMiningCanceled) {
+		t.Errorf("got %v, want ErrMiningCanceled", err)
+	}
+}
@@ -0,0 +1,236 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/notify"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"golang.org/x/net/websocket"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, sub *blockchain.Subscription) blockchain.Event {
+	t.Helper()
+	select {
+	case e := <-sub.C:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return blockchain.Event{}
+	}
+}
+
+func expectEvent(t *testing.T, sub *blockchain.Subscription, eventType blockchain.EventType, hash []byte) blockchain.Event {
+	t.Helper()
+	e := nextEvent(t, sub)
+	if e.Type != eventType || !bytes.Equal(e.Hash(), hash) {
+		t.Fatalf("got %s %x, want %s %x", e.Type, e.Hash(), eventType, hash)
+	}
+	return e
+}
+
+func TestEventBus(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	sub := blockchain.Subscribe(16)
+	defer sub.Unsubscribe()
+	blocks := blockchain.Subscribe(16, blockchain.BlockConnected)
+	defer blocks.Unsubscribe()
+
+	mineBlock(t, chain, mock, pubKeyHash)
+	if e := expectEvent(t, sub, blockchain.BlockConnected, chain.LastHash); e.Height != 1 {
+		t.Fatalf("connected height %d", e.Height)
+	}
+
+	tx, err := chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = blockchain.AcceptTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, sub, blockchain.TxAccepted, tx.ID)
+
+	mock.Add(time.Minute)
+	block, _, err := chain.BuildBlockTemplate([]*transaction.Transaction{tx}, pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.FindNonce()
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err = blockchain.RemoveTransactions(block.Transactions); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, sub, blockchain.BlockConnected, block.Hash)
+	expectEvent(t, sub, blockchain.TxRemoved, tx.ID)
+
+	// 断开的区块中的交易回到交易池
+	if _, err = chain.DisconnectTip(); err != nil {
+		t.Fatal(err)
+	}
+	if e := expectEvent(t, sub, blockchain.BlockDisconnected, block.Hash); e.Height != 2 {
+		t.Fatalf("disconnected height %d", e.Height)
+	}
+	expectEvent(t, sub, blockchain.TxAccepted, tx.ID)
+
+	// 只订阅了BlockConnected的订阅者收到两个区块，缓冲区满了的事件被丢弃
+	expectEvent(t, blocks, blockchain.BlockConnected, block.PrevHash)
+	expectEvent(t, blocks, blockchain.BlockConnected, block.Hash)
+	small := blockchain.Subscribe(1, blockchain.BlockConnected)
+	mineBlock(t, chain, mock, pubKeyHash)
+	mineBlock(t, chain, mock, pubKeyHash)
+	if small.Dropped() != 1 {
+		t.Fatalf("dropped %d events", small.Dropped())
+	}
+	small.Unsubscribe()
+	small.Unsubscribe()
+	if _, ok := <-small.C; !ok {
+		t.Fatal("buffered event lost after unsubscribe")
+	}
+	if _, ok := <-small.C; ok {
+		t.Fatal("channel is open after unsubscribe")
+	}
+}
+
+func TestNotifyServers(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := "ipc://" + filepath.Join(t.TempDir(), "notify.sock")
+	pubListener, err := notify.ListenPublisher(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := notify.NewPublisher()
+	published := make(chan error, 1)
+	go func() {
+		published <- publisher.Serve(ctx, pubListener)
+	}()
+	wsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- notify.ServeWebSocket(ctx, wsListener)
+	}()
+
+	if _, err = notify.Dial("udp://127.0.0.1:1"); err == nil {
+		t.Fatal("dial with a bad address")
+	}
+	subscriber, err := notify.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = subscriber.Close()
+	}()
+	if err = subscriber.Subscribe("hashblock"); err != nil {
+		t.Fatal(err)
+	}
+	if err = subscriber.Subscribe("rawblockconnected"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); publisher.Subscribers() != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription not received")
+		}
+	}
+
+	url := fmt.Sprintf("ws://%s%s?events=blockconnected&payload=raw", wsListener.Addr(), notify.WebSocketPath)
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ws.Close()
+	}()
+	if _, err = websocket.Dial(fmt.Sprintf("ws://%s%s?payload=full", wsListener.Addr(), notify.WebSocketPath), "", "http://localhost/"); err == nil {
+		t.Fatal("websocket with a bad payload option")
+	}
+
+	for i := 0; i < 2; i++ {
+		mineBlock(t, chain, mock, pubKeyHash)
+		raw, err := chainBlock(t, chain).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 同一个事件先发哈希再发编码，每个主题的序号单独递增
+		topic, body, seq, err := subscriber.Receive()
+		if err != nil || topic != "hashblockconnected" || !bytes.Equal(body, chain.LastHash) || seq != uint32(i) {
+			t.Fatalf("hash message %d: %s %x %d, %v", i, topic, body, seq, err)
+		}
+		topic, body, seq, err = subscriber.Receive()
+		if err != nil || topic != "rawblockconnected" || !bytes.Equal(body, raw) || seq != uint32(i) {
+			t.Fatalf("raw message %d: %s %d bytes %d, %v", i, topic, len(body), seq, err)
+		}
+
+		var msg notify.Message
+		if err = websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Event != "blockconnected" || msg.Hash != hex.EncodeToString(chain.LastHash) ||
+			msg.Height == nil || *msg.Height != i+1 || msg.Data != hex.EncodeToString(raw) {
+			t.Fatalf("websocket message %d: %+v", i, msg)
+		}
+	}
+
+	cancel()
+	if err = <-published; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = subscriber.Receive(); err == nil {
+		t.Fatal("subscriber still connected after stop")
+	}
+}
+
+func chainBlock(t *testing.T, chain *blockchain.BlockChain) *blockchain.Block {
+	t.Helper()
+	block, err := chain.Iterator().Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"github.com/limitzhang87/goblockchain/cmd"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var paymentFileTests = []struct {
+	name    string
+	content string
+	count   int
+	wantErr bool
+}{
+	{name: "pairs.json", content: `{"addrA": 10, "addrB": 20}`, count: 2},
+	{name: "list.json", content: `[{"address": "addrA", "amount": 10}]`, count: 1},
+	{name: "header.csv", content: "address,amount\naddrA,10\naddrB, 20\n", count: 2},
+	{name: "plain.csv", content: "addrA,10\n", count: 1},
+	{name: "dup.csv", content: "addrA,10\naddrA,5\n", wantErr: true},
+	{name: "zero.json", content: `{"addrA": 0}`, wantErr: true},
+	{name: "bad.csv", content: "addrA,10\naddrB,x\n", wantErr: true},
+	{name: "pay.txt", content: "addrA,10\n", wantErr: true},
+}
+
+func TestLoadPayments(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range paymentFileTests {
+		filename := filepath.Join(dir, test.name)
+		if err := os.WriteFile(filename, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := cmd.LoadPayments(filename)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got err %v, want err %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && len(entries) != test.count {
+			t.Errorf("%s: got %d entries, want %d", test.name, len(entries), test.count)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+func TestPruneAndSnapshot(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		mineBlock(t, chain, mock, pubKeyHash)
+	}
+
+	// 删除一个UTXO，区块都没有问题，但UTXO集合和区块不一致
+	var utxoKey []byte
+	err = chain.Database.Update(func(txn storage.Tx) error {
+		err := txn.ForEach([]byte(constcoe.UTXOPrefix), func(key, _ []byte) error {
+			if utxoKey == nil {
+				utxoKey = key
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return txn.Delete(utxoKey)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() || result.BadHash != nil || result.UTXOErr == nil {
+		t.Fatalf("missing utxo: got %+v", result)
+	}
+	if err = chain.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() {
+		t.Fatalf("after reindex: got %+v, %v", result, err)
+	}
+
+	// 裁剪模式只保留最新的区块体
+	config.Active().Prune = config.MinPruneBlocks
+	for i := 0; i < 10; i++ {
+		mineBlock(t, chain, mock, pubKeyHash)
+	}
+	if height, err := chain.Height(); err != nil || height != 13 {
+		t.Fatalf("height %d, %v", height, err)
+	}
+	if pruneHeight, err := chain.PruneHeight(); err != nil || pruneHeight != 4 {
+		t.Fatalf("prune height %d, %v", pruneHeight, err)
+	}
+	iter := chain.HeaderIterator()
+	for i := 0; i < 10; i++ {
+		if _, _, err = iter.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = chain.GetBlock(iter.CurrentHash); !errors.Is(err, blockchain.ErrBlockPruned) {
+		t.Fatalf("pruned block: got %v", err)
+	}
+	balance, _, err := chain.FindUTXOs(owner.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = chain.VerifyChain(0, blockchain.DefaultVerifyLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() || result.Level != blockchain.VerifyLevelSignature || result.Checked != 10 {
+		t.Fatalf("pruned chain: got %+v", result)
+	}
+	if err = chain.RollbackTo(iter.CurrentHash); !errors.Is(err, blockchain.ErrBlockPruned) {
+		t.Fatalf("rollback pruned chain: got %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	info, err := chain.DumpUTXOSet(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tip := chain.LastHash
+	_ = chain.Database.Close()
+	if info.Height != 13 || !bytes.Equal(info.TipHash, tip) {
+		t.Fatalf("dump: got %+v", info)
+	}
+
+	// 快照内容被修改或者哈希不是可信的哈希时不能加载，也不会留下数据库
+	useTempChain(t)
+	tampered := append([]byte(nil), snapshot.Bytes()...)
+	tampered[len(tampered)-40] ^= 0xff
+	if _, _, err = blockchain.LoadUTXOSnapshot(bytes.NewReader(tampered), nil); err == nil {
+		t.Fatal("loaded a tampered snapshot")
+	}
+	if _, _, err = blockchain.LoadUTXOSnapshot(bytes.NewReader(snapshot.Bytes()), tip); err == nil {
+		t.Fatal("loaded a snapshot with another hash")
+	}
+	if _, err = blockchain.ContinueBlockChain(); !errors.Is(err, blockchain.ErrChainNotFound) {
+		t.Fatalf("failed load left a chain: %v", err)
+	}
+
+	loaded, loadedInfo, err := blockchain.LoadUTXOSnapshot(bytes.NewReader(snapshot.Bytes()), info.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = loaded.Database.Close()
+	}()
+	if !bytes.Equal(loadedInfo.Hash, info.Hash) || loadedInfo.UTXOs != info.UTXOs {
+		t.Fatalf("load: got %+v, want %+v", loadedInfo, info)
+	}
+	if loadedBalance, _, err := loaded.FindUTXOs(owner.PublicKey); err != nil || loadedBalance != balance {
+		t.Fatalf("balance %d after load, want %d, %v", loadedBalance, balance, err)
+	}
+
+	// 从快照开始的链可以继续花费UTXO和加入区块
+	tx, err := loaded.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.Add(time.Minute)
+	block, _, err := loaded.BuildBlockTemplate([]*transaction.Transaction{tx}, pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.FindNonce()
+	if err = loaded.AddBlock(block); err != nil || len(block.Transactions) != 2 {
+		t.Fatalf("add block with %d transactions after load: %v", len(block.Transactions), err)
+	}
+	if height, err := loaded.Height(); err != nil || height != 14 {
+		t.Fatalf("height %d after load, %v", height, err)
+	}
+}
@@ -0,0 +1,244 @@
+package test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"github.com/limitzhang87/goblockchain/wire"
+	"testing"
+	"time"
+)
+
+func TestTransactionRoundTrip(t *testing.T) {
+	tx := GenerateTransaction(10, "LLL", "CCC", "prev1", 1)
+	tx.Version = transaction.TxVersion
+	tx.Inputs[0].Sig = []byte("signature")
+	tx.Outputs = append(tx.Outputs, transaction.TxOutput{Value: -1, PubKeyHash: nil})
+
+	data := tx.Serialize()
+	decoded, err := transaction.DeserializeTransaction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Serialize(), data) {
+		t.Error("re-encoded transaction differs")
+	}
+	if !bytes.Equal(decoded.ID, tx.ID) || !bytes.Equal(decoded.TxHash(), tx.TxHash()) {
+		t.Error("transaction id or hash changed")
+	}
+	if decoded.Inputs[0].OutIdx != 1 || decoded.Outputs[1].Value != -1 || decoded.Version != transaction.TxVersion {
+		t.Errorf("unexpected decoded transaction %+v", decoded)
+	}
+
+	// ID只和交易内容有关，每次计算都相同
+	if !bytes.Equal(GenerateTransaction(10, "LLL", "CCC", "prev1", 1).ID, GenerateTransaction(10, "LLL", "CCC", "prev1", 1).ID) {
+		t.Error("transaction id is not deterministic")
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	txs := []*transaction.Transaction{
+		GenerateTransaction(10, "LLL", "CCC", "prev1", 0),
+		GenerateTransaction(20, "EEE", "OOO", "prev2", 1),
+		GenerateTransaction(30, "OOO", "EEE", "prev3", 0),
+	}
+	block := blockchain.CreateBlock([]byte("prev"), txs)
+	data, err := block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := blockchain.DeSerializeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := decoded.Serialize()
+	if !bytes.Equal(again, data) {
+		t.Error("re-encoded block differs")
+	}
+	if !bytes.Equal(decoded.Hash, block.Hash) || !bytes.Equal(decoded.MerkleRoot, block.MerkleTree().Root()) {
+		t.Error("block hash or merkle root changed")
+	}
+	if !decoded.ValidatePoW() || len(decoded.Transactions) != len(txs) {
+		t.Error("decoded block is not valid")
+	}
+}
+
+func TestDecodeRejectsBadData(t *testing.T) {
+	tx := GenerateTransaction(10, "LLL", "CCC", "prev1", 0)
+	data := tx.Serialize()
+
+	_, err := transaction.DeserializeTransaction(data[:len(data)-1])
+	if !errors.Is(err, wire.ErrTruncated) {
+		t.Errorf("truncated: got %v", err)
+	}
+	_, err = transaction.DeserializeTransaction(append(data, 0))
+	if !errors.Is(err, wire.ErrTrailingData) {
+		t.Errorf("trailing data: got %v", err)
+	}
+	_, err = transaction.DeserializeTransaction(append([]byte{0x80, 0x00}, data[1:]...))
+	if !errors.Is(err, wire.ErrNonCanonical) {
+		t.Errorf("non-canonical varint: got %v", err)
+	}
+	_, err = transaction.DeserializeTransaction(append([]byte{transaction.TxVersion + 1}, data[1:]...))
+	if !errors.Is(err, wire.ErrUnknownVersion) {
+		t.Errorf("unknown version: got %v", err)
+	}
+	// 声明了大量交易输入但是没有数据
+	_, err = transaction.DeserializeTransaction([]byte{transaction.TxVersion, 0, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	if !errors.Is(err, wire.ErrTruncated) {
+		t.Errorf("huge count: got %v", err)
+	}
+}
+
+func TestMigrateLegacyChain(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.Add(time.Minute)
+	if err = chain.AddBlock(blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{tx})); err != nil {
+		t.Fatal(err)
+	}
+
+	// 把数据库改写成旧版本：最新的区块使用gob编码，创世区块使用第一版wire编码，没有数据库版本号
+	blocks := make([]*blockchain.Block, 0)
+	iter := chain.Iterator()
+	for i := 0; i < 2; i++ {
+		block, err := iter.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	err = chain.Database.Update(func(txn storage.Tx) error {
+		for i, block := range blocks {
+			for _, tx := range block.Transactions {
+				tx.Version = transaction.LegacyVersion
+			}
+			legacy := legacyBlock{
+				Timestamp:    block.Timestamp,
+				Hash:         block.Hash,
+				PrevHash:     block.PrevHash,
+				Target:       block.Target().Bytes(),
+				Nonce:        block.Nonce,
+				Transactions: block.Transactions,
+			}
+			data, err := legacy.encode(i == 0)
+			if err != nil {
+				return err
+			}
+			if err = txn.Set(block.Hash, data); err != nil {
+				return err
+			}
+			if err = txn.Delete(append([]byte(constcoe.HeaderPrefix), block.Hash...)); err != nil {
+				return err
+			}
+			if err = txn.Delete(append([]byte(constcoe.BodyPrefix), block.Hash...)); err != nil {
+				return err
+			}
+		}
+		return txn.Delete([]byte(constcoe.SchemaKey))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+
+	chain, err = blockchain.ContinueBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	iter = chain.Iterator()
+	for _, want := range blocks {
+		block, err := iter.Next()
+		if err != nil {
+			t.Fatalf("read migrated block: %v", err)
+		}
+		if !bytes.Equal(block.Hash, want.Hash) || !bytes.Equal(block.Transactions[0].ID, want.Transactions[0].ID) {
+			t.Errorf("migrated block %x changed", want.Hash)
+		}
+		if block.Transactions[0].Version != transaction.LegacyVersion || block.Version != blockchain.LegacyHeaderVersion {
+			t.Errorf("migrated versions: transaction %d header %d", block.Transactions[0].Version, block.Version)
+		}
+		if block.Bits != want.Bits {
+			t.Errorf("migrated block bits %d, want %d", block.Bits, want.Bits)
+		}
+		// 旧区块的工作量不能由区块头验证，只信任迁移时记录的区块
+		if block.ValidatePoW() || chain.CheckPoW(block) != nil {
+			t.Errorf("migrated block %x pow: header %v, chain %v", block.Hash, block.ValidatePoW(), chain.CheckPoW(block))
+		}
+	}
+	result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel)
+	if err != nil || !result.OK() {
+		t.Fatalf("verify migrated chain: %+v, %v", result, err)
+	}
+
+	// 其他来源的旧版本区块头没有经过验证，哈希可以是任意值
+	forged := blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{transaction.BaseTx(utils.PublicKeyHash(owner.PublicKey))})
+	forged.Version = blockchain.LegacyHeaderVersion
+	forged.Hash = forged.BlockHash()
+	if _, err = chain.ImportBlock(forged); !errors.Is(err, blockchain.ErrInvalidPoW) {
+		t.Fatalf("import forged legacy block: %v", err)
+	}
+	if err = chain.CheckPoW(forged); !errors.Is(err, blockchain.ErrInvalidPoW) {
+		t.Fatalf("check forged legacy block: %v", err)
+	}
+	balance, _, err := chain.FindUTXOs(owner.PublicKey)
+	if err != nil || balance != config.Active().Params.GenesisReward-10 {
+		t.Errorf("balance after migration: got %d err %v", balance, err)
+	}
+}
+
+// legacyBlock 旧版本的区块格式
+type legacyBlock struct {
+	Timestamp    int64
+	Hash         []byte
+	PrevHash     []byte
+	Target       []byte
+	Nonce        int64
+	ExtraNonce   int64
+	Transactions []*transaction.Transaction
+}
+
+// encode useGob为true时使用gob编码，否则使用第一版wire编码
+func (lb *legacyBlock) encode(useGob bool) ([]byte, error) {
+	if useGob {
+		var buf bytes.Buffer
+		err := gob.NewEncoder(&buf).Encode(lb)
+		return buf.Bytes(), err
+	}
+	w := wire.NewWriter()
+	w.WriteUvarint(1)
+	w.WriteVarint(lb.Timestamp)
+	w.WriteBytes(lb.Hash)
+	w.WriteBytes(lb.PrevHash)
+	w.WriteBytes(lb.Target)
+	w.WriteVarint(lb.Nonce)
+	w.WriteVarint(lb.ExtraNonce)
+	w.WriteUvarint(uint64(len(lb.Transactions)))
+	for _, tx := range lb.Transactions {
+		tx.Encode(w)
+	}
+	return w.Bytes(), nil
+}
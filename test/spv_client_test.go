@@ -0,0 +1,116 @@
+package test
+
+import (
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/spv"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// lyingNode 修改全节点返回的交易金额
+type lyingNode struct {
+	spv.FullNode
+}
+
+func (n *lyingNode) Proofs(pubKeyHashes [][]byte) ([]spv.TxProof, error) {
+	proofs, err := n.FullNode.Proofs(pubKeyHashes)
+	for _, proof := range proofs {
+		proof.Tx.Outputs[0].Value *= 100
+	}
+	return proofs, err
+}
+
+func TestSPVClient(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := chain.CreateTransaction(owner.PublicKey, utils.PublicKeyHash(receiver.PublicKey), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := transaction.CoinbaseTx(utils.PublicKeyHash(owner.PublicKey), 0, 1)
+	mock.Add(time.Minute)
+	if err = chain.AddBlock(blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{coinbase, tx})); err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+
+	server := httptest.NewServer(spv.NewHandler(&spv.ChainNode{}))
+	defer server.Close()
+	node := &spv.RemoteNode{URL: server.URL}
+
+	client, err := spv.NewClient(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := client.Sync()
+	if err != nil || added != 2 {
+		t.Fatalf("sync: added %d err %v", added, err)
+	}
+
+	balance, err := client.Balance(utils.PublicKeyHash(receiver.PublicKey))
+	if err != nil || balance.Confirmed != 10 || balance.Pending != 0 {
+		t.Errorf("receiver balance %+v err %v", balance, err)
+	}
+	balance, err = client.Balance(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil || balance.Confirmed != chaincfg.ActiveParams().GenesisReward-10 {
+		t.Errorf("owner balance %+v err %v", balance, err)
+	}
+
+	// 重新加载保存的区块头，不需要再次同步
+	reloaded, err := spv.NewClient(node)
+	if err != nil || reloaded.Store.Height() != 1 {
+		t.Fatalf("reload headers: height %d err %v", reloaded.Store.Height(), err)
+	}
+	if added, err = reloaded.Sync(); err != nil || added != 0 {
+		t.Errorf("second sync: added %d err %v", added, err)
+	}
+	reloaded.MinConf = 2
+	balance, err = reloaded.Balance(utils.PublicKeyHash(receiver.PublicKey))
+	if err != nil || balance.Confirmed != 0 || balance.Pending != 10 {
+		t.Errorf("receiver balance with minconf 2: %+v err %v", balance, err)
+	}
+
+	liar := &spv.Client{Node: &lyingNode{node}, Store: client.Store}
+	_, err = liar.Balance(utils.PublicKeyHash(receiver.PublicKey))
+	if !errors.Is(err, spv.ErrInvalidProof) {
+		t.Errorf("forged transaction: got %v", err)
+	}
+
+	entries, err := node.Headers(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[1].Header.Bits = 0
+	store := &spv.HeaderStore{}
+	if err = store.Append(entries); !errors.Is(err, spv.ErrInvalidHeader) || store.Height() != -1 {
+		t.Errorf("low difficulty header: got %v", err)
+	}
+	// 全节点可以把任何区块头标成旧版本，旧版本也要由区块头计算出区块哈希并满足工作量证明
+	if entries, err = node.Headers(nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	entries[1].Header.Version = blockchain.LegacyHeaderVersion
+	entries[1].Hash = entries[1].Header.BlockHash()
+	if err = store.Append(entries); !errors.Is(err, spv.ErrInvalidHeader) || store.Height() != -1 {
+		t.Errorf("legacy header: got %v", err)
+	}
+}
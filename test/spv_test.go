@@ -5,7 +5,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/limitzhang87/goblockchain/blockchain"
-	"github.com/limitzhang87/goblockchain/merkletree"
 	"github.com/limitzhang87/goblockchain/transaction"
 	"strconv"
 	"strings"
@@ -155,15 +154,18 @@ func TestSPV(t *testing.T) {
 		for num, findIdx := range test.findTX {
 			fmt.Println("Find transaction:", findIdx)
 			fmt.Printf("Transaction ID: %x\n", primeTXs[findIdx].ID)
-			route, hashRoute, ok := testBlock.MTree.BackValidationRoute(primeTXs[findIdx].ID)
-			fmt.Println(route, changeByteToStr(hashRoute))
-			if ok {
-				fmt.Println("Route is like:")
-				routePaint(route)
+			tree := testBlock.MerkleTree()
+			spvRes := false
+			if leafIdx := tree.IndexOf(primeTXs[findIdx].ID); leafIdx >= 0 {
+				proof, err := tree.Proof(leafIdx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fmt.Println("Leaf index:", leafIdx, "Proof hashes:", changeByteToStr(proof.Hashes))
+				spvRes = proof.Verify(testBlock.MerkleRoot)
 			} else {
 				fmt.Println("Has not found the referred transaction")
 			}
-			spvRes := merkletree.SimplePaymentValidation(primeTXs[findIdx].ID, testBlock.MTree.Root.Data, route, hashRoute)
 			fmt.Println("SPV result: ", spvRes, ", Want result: ", test.wants[num])
 			if spvRes != test.wants[num] {
 				t.Errorf("test %d find %d: SPV is not right", idx, findIdx)
@@ -174,78 +176,29 @@ func TestSPV(t *testing.T) {
 	}
 }
 
+// mtGraphPaint 从根到叶子逐层输出merkle树，结点个数为单数时最后一个结点直接放到上一层
 func mtGraphPaint(txContained []int) {
 	mtLayer := make([][]string, 0)
 	bottomLayer := make([]string, 0)
 	for i := 0; i < len(txContained); i++ {
 		bottomLayer = append(bottomLayer, strconv.Itoa(txContained[i]))
 	}
-	if len(bottomLayer)%2 == 1 {
-		bottomLayer = append(bottomLayer, bottomLayer[len(bottomLayer)-1])
-	}
 	mtLayer = append(mtLayer, bottomLayer)
 
-	for len(mtLayer[len(mtLayer)-1]) != 1 {
+	for len(mtLayer[len(mtLayer)-1]) > 1 {
+		lastLayer := mtLayer[len(mtLayer)-1]
 		tempLayer := make([]string, 0)
-		if len(mtLayer[len(mtLayer)-1])%2 == 1 {
-			tempLayer = append(tempLayer, mtLayer[len(mtLayer)-1][len(mtLayer[len(mtLayer)-1])-1])
-			mtLayer[len(mtLayer)-1][len(mtLayer[len(mtLayer)-1])-1] = "->"
+		for i := 0; i+1 < len(lastLayer); i += 2 {
+			tempLayer = append(tempLayer, lastLayer[i]+lastLayer[i+1])
 		}
-		for i := 0; i < len(mtLayer[len(mtLayer)-1])/2; i++ {
-			tempLayer = append(tempLayer, mtLayer[len(mtLayer)-1][2*i]+mtLayer[len(mtLayer)-1][2*i+1])
+		if len(lastLayer)%2 == 1 {
+			tempLayer = append(tempLayer, lastLayer[len(lastLayer)-1])
 		}
-
 		mtLayer = append(mtLayer, tempLayer)
 	}
 
-	layers := len(mtLayer)
-	fmt.Println(strings.Repeat(" ", layers-1), mtLayer[layers-1][0])
-	foreSpace := 0
-	for i := layers - 2; i >= 0; i-- {
-		var str1, str2 string
-		str1 += strings.Repeat(" ", foreSpace)
-		str2 += strings.Repeat(" ", foreSpace)
-
-		for j := 0; j < len(mtLayer[i]); j++ {
-			str1 += strings.Repeat(" ", i+1)
-			if j%2 == 0 {
-				if mtLayer[i][j] == "->" {
-					foreSpace += (i+1)*2 + 1
-					str1 = strings.Repeat(" ", foreSpace) + str1
-					str2 = strings.Repeat(" ", foreSpace) + str2
-				} else {
-					str1 += "/"
-				}
-
-			} else {
-				str1 += "\\"
-			}
-			str1 += strings.Repeat(" ", len(mtLayer[i][j])-1)
-			str2 += strings.Repeat(" ", i+1)
-			str2 += mtLayer[i][j]
-		}
-		fmt.Println(str1)
-		fmt.Println(str2)
-	}
-
-}
-
-func routePaint(route []int) {
-	probe := len(route)
-	fmt.Println(strings.Repeat(" ", probe) + "*")
-	for i := 0; i < len(route); i++ {
-		var str1, str2 string
-		str1 += strings.Repeat(" ", probe)
-		if route[i] == 0 {
-			str1 += "/"
-			probe -= 1
-		} else {
-			str1 += "\\"
-			probe += 1
-		}
-		str2 += strings.Repeat(" ", probe) + "*"
-		fmt.Println(str1)
-		fmt.Println(str2)
+	for i := len(mtLayer) - 1; i >= 0; i-- {
+		fmt.Println(strings.Repeat(" ", i), strings.Join(mtLayer[i], " "))
 	}
 }
 
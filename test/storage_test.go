@@ -0,0 +1,222 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"strings"
+	"testing"
+)
+
+// TestStorageBackends 每个存储后端的事务、遍历、批量写入和删除前缀的行为都要一致
+func TestStorageBackends(t *testing.T) {
+	for _, backend := range storage.Backends() {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := storage.Open(backend, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = storage.Remove(backend, dir)
+			}()
+			if !storage.Exists(backend, dir) {
+				t.Fatal("store does not exist after open")
+			}
+			if found, ok := storage.Detect(dir); !ok || found != backend {
+				t.Fatalf("detect: got %q, %v", found, ok)
+			}
+			// 已经打开的数据库不能再打开，内存存储在同一个进程中共享
+			if backend != storage.Memory {
+				if _, err = storage.Open(backend, dir); !errors.Is(err, storage.ErrLocked) {
+					t.Fatalf("open twice: got %v", err)
+				}
+			}
+
+			err = db.Update(func(tx storage.Tx) error {
+				for _, key := range []string{"a2", "b1", "a1", "a3"} {
+					if err := tx.Set([]byte(key), []byte("v"+key)); err != nil {
+						return err
+					}
+				}
+				return tx.Set([]byte("empty"), nil)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 返回error的事务中的修改全部丢弃
+			errAbort := errors.New("abort")
+			err = db.Update(func(tx storage.Tx) error {
+				if err := tx.Set([]byte("a4"), []byte("va4")); err != nil {
+					return err
+				}
+				if err := tx.Delete([]byte("a1")); err != nil {
+					return err
+				}
+				return errAbort
+			})
+			if !errors.Is(err, errAbort) {
+				t.Fatalf("aborted update: got %v", err)
+			}
+
+			err = db.View(func(tx storage.Tx) error {
+				if value, err := tx.Get([]byte("a1")); err != nil || string(value) != "va1" {
+					t.Fatalf("get a1: got %q, %v", value, err)
+				}
+				if value, err := tx.Get([]byte("empty")); err != nil || len(value) != 0 {
+					t.Fatalf("get empty value: got %q, %v", value, err)
+				}
+				if _, err := tx.Get([]byte("a4")); !errors.Is(err, storage.ErrNotFound) {
+					t.Fatalf("get aborted key: got %v", err)
+				}
+				keys := make([]string, 0)
+				err := tx.ForEach([]byte("a"), func(key, value []byte) error {
+					if string(value) != "v"+string(key) {
+						t.Fatalf("value of %s: got %q", key, value)
+					}
+					keys = append(keys, string(key))
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if got := strings.Join(keys, ","); got != "a1,a2,a3" {
+					t.Fatalf("for each a: got %s", got)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 同一个事务中可以读到还没有提交的修改
+			err = db.Update(func(tx storage.Tx) error {
+				if err := tx.Delete([]byte("a2")); err != nil {
+					return err
+				}
+				if _, err := tx.Get([]byte("a2")); !errors.Is(err, storage.ErrNotFound) {
+					t.Fatalf("get deleted key in the same tx: got %v", err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			batch := db.NewBatch()
+			for i := 0; i < 100; i++ {
+				if err = batch.Set([]byte{'c', byte(i)}, []byte{byte(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = batch.Delete([]byte("b1")); err != nil {
+				t.Fatal(err)
+			}
+			if err = batch.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err = db.DropPrefix([]byte("a")); err != nil {
+				t.Fatal(err)
+			}
+			if err = db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// 关闭后再次打开还能读到之前的数据
+			db, err = storage.Open(backend, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = db.Close()
+			}()
+			err = db.View(func(tx storage.Tx) error {
+				count := 0
+				err := tx.ForEach(nil, func(key, value []byte) error {
+					count++
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if count != 101 {
+					t.Fatalf("got %d keys, want 101", count)
+				}
+				for _, key := range []string{"a1", "a3", "b1"} {
+					if _, err := tx.Get([]byte(key)); !errors.Is(err, storage.ErrNotFound) {
+						t.Fatalf("get %s: got %v", key, err)
+					}
+				}
+				value, err := tx.Get([]byte{'c', 99})
+				if err != nil || !bytes.Equal(value, []byte{99}) {
+					t.Fatalf("get batch key: got %x, %v", value, err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestChainOnBackends 每个存储后端都能创建、重新打开和校验区块链，其他后端的数据不会被当作区块链打开
+func TestChainOnBackends(t *testing.T) {
+	for _, backend := range storage.Backends() {
+		t.Run(backend, func(t *testing.T) {
+			useTempChainWith(t, backend)
+			mock := useMockClock(t)
+
+			owner, err := wallet.NewWallet()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+			chain, err := blockchain.InitBlockChain(pubKeyHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				mineBlock(t, chain, mock, pubKeyHash)
+			}
+			balance, _, err := chain.FindUTXOs(owner.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = chain.Database.Close()
+
+			if _, err = blockchain.InitBlockChain(pubKeyHash); !errors.Is(err, blockchain.ErrChainExists) {
+				t.Fatalf("init existing chain: got %v", err)
+			}
+			if backend != storage.Memory {
+				config.Active().Backend = storage.Memory
+				if _, err = blockchain.ContinueBlockChain(); !errors.Is(err, blockchain.ErrChainNotFound) {
+					t.Fatalf("continue with another backend: got %v", err)
+				}
+				config.Active().Backend = backend
+			}
+			chain, err = blockchain.ContinueBlockChain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = chain.Database.Close()
+			}()
+			if height, err := chain.Height(); err != nil || height != 3 {
+				t.Fatalf("height %d, %v", height, err)
+			}
+			if got, _, err := chain.FindUTXOs(owner.PublicKey); err != nil || got != balance {
+				t.Fatalf("balance %d after reopen, want %d, %v", got, balance, err)
+			}
+			result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel)
+			if err != nil || !result.OK() {
+				t.Fatalf("verify: got %+v, %v", result, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/clock"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+// useMockClock 使用手动控制的时间，测试结束后恢复系统时间
+func useMockClock(t *testing.T) *clock.Mock {
+	t.Helper()
+	mock := clock.NewMock(time.Unix(1700000000, 0))
+	clock.Set(mock)
+	t.Cleanup(func() {
+		clock.Set(nil)
+	})
+	return mock
+}
+
+func TestBlockTimestamp(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+	useParams(t, func(params *chaincfg.Params) {
+		params.MedianTimeBlocks = 3
+		params.MaxFutureBlockTime = 60
+	})
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+
+	// 时间戳可以比上一个区块早，只要大于中位数：+100 +150 +120，最近3个区块的中位数是 +120
+	start := mock.Now().Unix()
+	mock.Set(time.Unix(start+200, 0))
+	for i, offset := range []int64{100, 150, 120} {
+		block := blockchain.NewBlockTemplate(chain.LastHash, []*transaction.Transaction{transaction.CoinbaseTx(pubKeyHash, 50, i+1)})
+		block.Timestamp = start + offset
+		block.FindNonce()
+		if err = chain.AddBlock(block); err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+	}
+	median, err := chain.MedianTimePast(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if median != start+120 {
+		t.Fatalf("median time past %d, want %d", median, start+120)
+	}
+
+	tests := map[string]int64{
+		"equal to median":   start + 120,
+		"before median":     start + 110,
+		"too far in future": mock.Now().Unix() + 61,
+	}
+	for name, timestamp := range tests {
+		block := blockchain.NewBlockTemplate(chain.LastHash, []*transaction.Transaction{transaction.CoinbaseTx(pubKeyHash, 50, 10)})
+		block.Timestamp = timestamp
+		block.FindNonce()
+		if err = chain.AddBlock(block); !errors.Is(err, blockchain.ErrInvalidTimestamp) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	// 当前时间早于中位数时，模板的时间戳使用中位数加1
+	mock.Set(time.Unix(start, 0))
+	block, _, err := chain.BuildBlockTemplate(nil, pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Timestamp != start+121 {
+		t.Fatalf("template timestamp %d, want %d", block.Timestamp, start+121)
+	}
+	block.FindNonce()
+	mock.Set(time.Unix(start+200, 0))
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+}
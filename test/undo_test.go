@@ -0,0 +1,151 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+func poolTxIDs(t *testing.T) [][]byte {
+	t.Helper()
+	pool, err := blockchain.CreatePool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([][]byte, 0, len(pool.Txs))
+	for _, tx := range pool.Txs {
+		ids = append(ids, tx.ID)
+	}
+	return ids
+}
+
+func TestDisconnectTip(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	if _, err = chain.DisconnectTip(); !errors.Is(err, blockchain.ErrInvalidBlock) {
+		t.Fatalf("disconnect genesis: got %v", err)
+	}
+	mineBlock(t, chain, mock, pubKeyHash)
+	tip := chain.LastHash
+	before, err := chain.FindUTXOList(owner.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.Add(time.Minute)
+	block, _, err := chain.BuildBlockTemplate([]*transaction.Transaction{tx}, pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.FindNonce()
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// 断开后UTXO集合和连接之前一样，花费的交易回到交易池
+	disconnected, err := chain.DisconnectTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(disconnected.Hash, block.Hash) || !bytes.Equal(chain.LastHash, tip) {
+		t.Fatalf("disconnected %x, tip %x", disconnected.Hash, chain.LastHash)
+	}
+	after, err := chain.FindUTXOList(owner.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("%d utxos after disconnect, want %d", len(after), len(before))
+	}
+	for i := range before {
+		if before[i].OutPoint.String() != after[i].OutPoint.String() || before[i].Height != after[i].Height ||
+			before[i].Coinbase != after[i].Coinbase || before[i].Value() != after[i].Value() {
+			t.Fatalf("utxo %d after disconnect: got %+v, want %+v", i, after[i], before[i])
+		}
+	}
+	if ids := poolTxIDs(t); len(ids) != 1 || !bytes.Equal(ids[0], tx.ID) {
+		t.Fatalf("pool after disconnect: got %x", ids)
+	}
+	if height, err := chain.Height(); err != nil || height != 1 {
+		t.Fatalf("height %d after disconnect, %v", height, err)
+	}
+	if result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() {
+		t.Fatalf("verify after disconnect: %+v, %v", result, err)
+	}
+
+	// 断开的区块可以重新连接
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err = blockchain.RemoveTransactions(block.Transactions); err != nil {
+		t.Fatal(err)
+	}
+	mineBlock(t, chain, mock, pubKeyHash)
+	newTip := chain.LastHash
+
+	// 标记为无效时断开区块和之后的区块，无效的区块不能再加入
+	count, err := chain.InvalidateBlock(block.Hash)
+	if err != nil || count != 2 {
+		t.Fatalf("invalidate: disconnected %d, %v", count, err)
+	}
+	if !bytes.Equal(chain.LastHash, tip) {
+		t.Fatalf("tip %x after invalidate, want %x", chain.LastHash, tip)
+	}
+	if err = chain.AddBlock(block); !errors.Is(err, blockchain.ErrInvalidBlock) {
+		t.Fatalf("add invalid block: got %v", err)
+	}
+	if ids := poolTxIDs(t); len(ids) != 1 || !bytes.Equal(ids[0], tx.ID) {
+		t.Fatalf("pool after invalidate: got %x", ids)
+	}
+
+	count, err = chain.ReconsiderBlock(block.Hash)
+	if err != nil || count != 2 {
+		t.Fatalf("reconsider: connected %d, %v", count, err)
+	}
+	if !bytes.Equal(chain.LastHash, newTip) {
+		t.Fatalf("tip %x after reconsider, want %x", chain.LastHash, newTip)
+	}
+	if ids := poolTxIDs(t); len(ids) != 0 {
+		t.Fatalf("pool after reconsider: got %x", ids)
+	}
+	if _, err = chain.ReconsiderBlock(block.Hash); !errors.Is(err, blockchain.ErrNotInvalidated) {
+		t.Fatalf("reconsider twice: got %v", err)
+	}
+
+	// 标记之后链上又加入了其他区块，重新考虑时只删除标记
+	if _, err = chain.InvalidateBlock(newTip); err != nil {
+		t.Fatal(err)
+	}
+	mineBlock(t, chain, mock, pubKeyHash)
+	if count, err = chain.ReconsiderBlock(newTip); err != nil || count != 0 {
+		t.Fatalf("reconsider after the chain moved on: connected %d, %v", count, err)
+	}
+	if height, err := chain.Height(); err != nil || height != 3 {
+		t.Fatalf("height %d, %v", height, err)
+	}
+	if result, err := chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() {
+		t.Fatalf("verify: %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"github.com/limitzhang87/goblockchain/wire"
+	"testing"
+	"time"
+)
+
+// putBody 直接改写数据库中的区块体，模拟损坏的数据
+func putBody(t *testing.T, chain *blockchain.BlockChain, hash []byte, txs []*transaction.Transaction) {
+	t.Helper()
+	w := wire.NewWriter()
+	w.WriteUvarint(uint64(len(txs)))
+	for _, tx := range txs {
+		tx.Encode(w)
+	}
+	err := chain.Database.Update(func(txn storage.Tx) error {
+		return txn.Set(append([]byte(constcoe.BodyPrefix), hash...), w.Bytes())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// forgeSignature 复制交易并改写第一个输入的签名，交易ID和merkle根不变
+func forgeSignature(tx *transaction.Transaction) *transaction.Transaction {
+	forged := *tx
+	forged.Inputs = append([]transaction.TxInput(nil), tx.Inputs...)
+	forged.Inputs[0].Sig = append([]byte(nil), tx.Inputs[0].Sig...)
+	forged.Inputs[0].Sig[0] ^= 0xff
+	return &forged
+}
+
+func TestVerifyChain(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.InitBlockChain(utils.PublicKeyHash(owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+
+	// 高度1：一笔签名的交易，高度2：挖矿奖励过多，高度3：只有挖矿奖励
+	tx, err := chain.CreateTransaction(owner.PublicKey, []byte("receiver"), 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := [][]byte{chain.LastHash}
+	subsidy := chaincfg.ActiveParams().BlockSubsidy
+	for i := 1; i <= 3; i++ {
+		reward := 50
+		if i == 2 {
+			reward = 1000
+		}
+		txs := []*transaction.Transaction{transaction.CoinbaseTx(utils.PublicKeyHash(owner.PublicKey), reward, i)}
+		if i == 1 {
+			txs = append(txs, tx)
+		}
+		mock.Add(time.Minute)
+		if i == 1 {
+			// 签名无效的交易不能进入交易池，也不能被打包进区块
+			forged := forgeSignature(tx)
+			if err = blockchain.AcceptTransaction(forged); !errors.Is(err, blockchain.ErrInvalidTransaction) {
+				t.Fatalf("accept forged signature: got %v", err)
+			}
+			bad := blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{txs[0], forged})
+			if err = chain.AddBlock(bad); !errors.Is(err, blockchain.ErrInvalidTransaction) {
+				t.Fatalf("mine forged signature: got %v", err)
+			}
+		}
+		if i == 2 {
+			// 加入区块时UTXO集合中已经没有被花费的输出
+			doubleSpend := blockchain.CreateBlock(chain.LastHash, []*transaction.Transaction{txs[0], tx})
+			if err = chain.AddBlock(doubleSpend); !errors.Is(err, blockchain.ErrInvalidTransaction) {
+				t.Fatalf("double spend: got %v", err)
+			}
+		}
+		block := blockchain.CreateBlock(chain.LastHash, txs)
+		if i == 2 {
+			if err = chain.AddBlock(block); !errors.Is(err, blockchain.ErrInvalidBlock) {
+				t.Fatalf("too much reward: got %v", err)
+			}
+			// 模拟出块奖励降低之前加入的区块
+			useParams(t, func(params *chaincfg.Params) {
+				params.BlockSubsidy = reward
+			})
+		}
+		if err = chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			chaincfg.ActiveParams().BlockSubsidy = subsidy
+		}
+		hashes = append(hashes, block.Hash)
+	}
+
+	// 挖矿奖励过多只有重放UTXO集合时才能发现
+	result, err := chain.VerifyChain(0, blockchain.VerifyLevelSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() || result.Height != 3 || result.Checked != 4 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	result, err = chain.VerifyChain(0, blockchain.VerifyLevelUTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BadHeight != 2 || !bytes.Equal(result.GoodHash, hashes[1]) || !errors.Is(result.Err, blockchain.ErrInvalidBlock) {
+		t.Fatalf("too much reward: got %+v", result)
+	}
+
+	// 改写签名不影响交易ID和merkle根，只有检查签名时才能发现
+	block, err := chain.GetBlock(hashes[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := forgeSignature(tx)
+	putBody(t, chain, hashes[1], []*transaction.Transaction{block.Transactions[0], forged})
+	putBody(t, chain, hashes[3], []*transaction.Transaction{transaction.CoinbaseTx([]byte("thief"), 50, 3)})
+	if result, err = chain.VerifyChain(1, blockchain.VerifyLevelRead); err != nil || !result.OK() || result.Checked != 1 {
+		t.Fatalf("read level: got %+v, %v", result, err)
+	}
+	result, err = chain.VerifyChain(0, blockchain.VerifyLevelBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BadHeight != 1 || !errors.Is(result.Err, blockchain.ErrInvalidMerkleRoot) {
+		t.Fatalf("merkle root: got %+v", result)
+	}
+
+	putBody(t, chain, hashes[1], []*transaction.Transaction{transaction.CoinbaseTx(utils.PublicKeyHash(owner.PublicKey), 50, 1), forged})
+	result, err = chain.VerifyChain(0, blockchain.VerifyLevelBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BadHeight != 3 || !errors.Is(result.Err, blockchain.ErrInvalidMerkleRoot) {
+		t.Fatalf("tampered body: got %+v", result)
+	}
+	result, err = chain.VerifyChain(0, blockchain.VerifyLevelSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BadHeight != 1 || !bytes.Equal(result.GoodHash, hashes[0]) || !errors.Is(result.Err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("bad signature: got %+v", result)
+	}
+
+	if err = chain.RollbackTo(result.GoodHash); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, hashes[0]) {
+		t.Fatalf("tip %x after rollback", chain.LastHash)
+	}
+	if _, err = chain.GetBlock(hashes[3]); !errors.Is(err, blockchain.ErrBlockNotFound) {
+		t.Fatalf("rolled back block: got %v", err)
+	}
+	if result, err = chain.VerifyChain(0, blockchain.DefaultVerifyLevel); err != nil || !result.OK() {
+		t.Fatalf("after rollback: got %+v, %v", result, err)
+	}
+}
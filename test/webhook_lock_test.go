@@ -0,0 +1,52 @@
+//go:build !windows
+
+package test
+
+import (
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/config"
+	"github.com/limitzhang87/goblockchain/webhook"
+	"golang.org/x/sys/unix"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestWebhookFileLock 其他进程拿着通知文件的锁时，修改监视要等锁释放
+func TestWebhookFileLock(t *testing.T) {
+	useTempChain(t)
+
+	// 单独打开的文件和其他进程一样，和本进程中的锁互斥
+	if err := os.MkdirAll(config.Active().DataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(config.Active().WebhooksFile+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err = unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	added := make(chan error, 1)
+	go func() {
+		_, err := webhook.AddWatch(string(address.Encode([]byte("watched-pubkey-hash!"))), "http://127.0.0.1:1/hook", 1, nil)
+		added <- err
+	}()
+	select {
+	case err = <-added:
+		t.Fatalf("added a watch while the file is locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err = unix.Flock(int(f.Fd()), unix.LOCK_UN); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-added; err != nil {
+		t.Fatal(err)
+	}
+	if watches, err := webhook.ListWatches(); err != nil || len(watches) != 1 {
+		t.Fatalf("watches: %+v, %v", watches, err)
+	}
+}
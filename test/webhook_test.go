@@ -0,0 +1,279 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/constcoe"
+	"github.com/limitzhang87/goblockchain/storage"
+	"github.com/limitzhang87/goblockchain/transaction"
+	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wallet"
+	"github.com/limitzhang87/goblockchain/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookStub 记录收到的通知，failures不为0时先拒绝这么多次请求
+type webhookStub struct {
+	mu       sync.Mutex
+	secret   string
+	failures int
+	requests int
+	received []webhook.Notification
+	badSigs  int
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if !webhook.Verify(s.secret, body, r.Header.Get(webhook.SignatureHeader)) {
+		s.badSigs++
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+		return
+	}
+	var n webhook.Notification
+	if err := json.Unmarshal(body, &n); err != nil || n.Event != r.Header.Get(webhook.EventHeader) || n.ID != r.Header.Get(webhook.DeliveryHeader) {
+		http.Error(w, "bad notification", http.StatusBadRequest)
+		return
+	}
+	s.received = append(s.received, n)
+}
+
+func (s *webhookStub) notifications() []webhook.Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]webhook.Notification(nil), s.received...)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+	}
+}
+
+func queued(t *testing.T) int {
+	t.Helper()
+	watches, err := webhook.ListWatches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, w := range watches {
+		count += w.Queued
+	}
+	return count
+}
+
+func TestWatchAddressWebhook(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	watched := []byte("watched-pubkey-hash!")
+	watchedAddr := string(address.Encode(watched))
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	mineBlock(t, chain, mock, pubKeyHash)
+
+	stub := &webhookStub{failures: 1}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	if _, err = webhook.AddWatch(watchedAddr, "ftp://example.com", 3, nil); err == nil {
+		t.Fatal("watch with a bad url")
+	}
+	watch, err := webhook.AddWatch(watchedAddr, server.URL, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.secret = watch.Secret
+	if _, err = webhook.AddWatch(watchedAddr, server.URL, 1, nil); err == nil {
+		t.Fatal("watch the same address and url twice")
+	}
+
+	notifier := webhook.NewNotifier()
+	notifier.Backoff = 10 * time.Millisecond
+	sub := blockchain.Subscribe(webhook.EventBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		notifier.Run(ctx, sub)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		sub.Unsubscribe()
+	}()
+
+	// 进入交易池、第一次确认、达到3个确认各通知一次，第一次被拒绝的通知重试后送达
+	tx, err := chain.CreateTransaction(owner.PublicKey, watched, 10, owner.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = blockchain.AcceptTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mock.Add(time.Minute)
+	block, _, err := chain.BuildBlockTemplate([]*transaction.Transaction{tx}, pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.FindNonce()
+	if err = chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err = blockchain.RemoveTransactions(block.Transactions); err != nil {
+		t.Fatal(err)
+	}
+	mineBlock(t, chain, mock, pubKeyHash)
+	mineBlock(t, chain, mock, pubKeyHash)
+	waitFor(t, "three notifications", func() bool { return len(stub.notifications()) == 3 })
+	waitFor(t, "empty queue", func() bool { return queued(t) == 0 })
+
+	// 重试的通知可能在之后的通知之后送达，接收方按confirmations判断
+	txID := hex.EncodeToString(tx.ID)
+	wants := map[string][2]int{
+		webhook.EventMempool:       {0, 0},
+		webhook.EventConfirmed:     {1, 2},
+		webhook.EventConfirmations: {3, 2},
+	}
+	for _, n := range stub.notifications() {
+		want, ok := wants[n.Event]
+		if !ok || n.Confirmations != want[0] || n.BlockHeight != want[1] ||
+			n.TxID != txID || n.OutIdx != 0 || n.Value != 10 || n.Address != watchedAddr || n.Watch != watch.ID {
+			t.Fatalf("notification: %+v", n)
+		}
+		delete(wants, n.Event)
+	}
+	stub.mu.Lock()
+	requests, badSigs := stub.requests, stub.badSigs
+	stub.mu.Unlock()
+	if requests != 4 || badSigs != 0 {
+		t.Fatalf("%d requests, %d bad signatures", requests, badSigs)
+	}
+
+	// 地址索引保存收到的输出，重建链状态后不变
+	outputs, err := chain.AddressOutputs(watched)
+	if err != nil || len(outputs) != 1 || !bytes.Equal(outputs[0].TxID, tx.ID) || outputs[0].Height != 2 || outputs[0].Value != 10 {
+		t.Fatalf("address outputs: %+v, %v", outputs, err)
+	}
+	if err = chain.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if outputs, err = chain.AddressOutputs(watched); err != nil || len(outputs) != 1 {
+		t.Fatalf("address outputs after reindex: %+v, %v", outputs, err)
+	}
+
+	// 接收方不可用时通知保存在文件中，之后重试
+	stub.mu.Lock()
+	stub.failures = 1 << 20
+	stub.mu.Unlock()
+	if _, err = chain.InvalidateBlock(block.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if outputs, err = chain.AddressOutputs(watched); err != nil || len(outputs) != 0 {
+		t.Fatalf("address outputs after invalidate: %+v, %v", outputs, err)
+	}
+	waitFor(t, "queued mempool notification", func() bool { return queued(t) == 1 })
+	cancel()
+	<-done
+	stub.mu.Lock()
+	stub.failures = 0
+	stub.mu.Unlock()
+	waitFor(t, "retried notification", func() bool {
+		if _, err := notifier.Deliver(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return queued(t) == 0
+	})
+	// 停止时正在发送的通知可能已经送达，之后会再发一次，接收方按通知ID去重
+	all := make([]webhook.Notification, 0)
+	for _, n := range stub.notifications() {
+		if len(all) == 0 || all[len(all)-1].ID != n.ID {
+			all = append(all, n)
+		}
+	}
+	if len(all) != 4 || all[3].Event != webhook.EventMempool || all[3].TxID != txID {
+		t.Fatalf("notifications after retry: %+v", all)
+	}
+
+	if err = webhook.RemoveWatch(watch.ID); err != nil {
+		t.Fatal(err)
+	}
+	if watching, err := webhook.Watching(); err != nil || watching {
+		t.Fatalf("watching after remove: %v, %v", watching, err)
+	}
+}
+
+// TestAddressIndexMigration 版本3的数据库打开时建立地址索引
+func TestAddressIndexMigration(t *testing.T) {
+	useTempChain(t)
+	mock := useMockClock(t)
+
+	owner, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := utils.PublicKeyHash(owner.PublicKey)
+	chain, err := blockchain.InitBlockChain(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mineBlock(t, chain, mock, pubKeyHash)
+	mineBlock(t, chain, mock, pubKeyHash)
+	if err = chain.Database.DropPrefix([]byte(constcoe.AddrIndexPrefix)); err != nil {
+		t.Fatal(err)
+	}
+	err = chain.Database.Update(func(txn storage.Tx) error {
+		return txn.Set([]byte(constcoe.SchemaKey), binary.AppendUvarint(nil, 3))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = chain.Database.Close()
+
+	chain, err = blockchain.ContinueBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = chain.Database.Close()
+	}()
+	outputs, err := chain.AddressOutputs(pubKeyHash)
+	if err != nil || len(outputs) != 3 {
+		t.Fatalf("address outputs after migration: %+v, %v", outputs, err)
+	}
+	for height, out := range outputs {
+		if out.Height != height {
+			t.Fatalf("output %d at height %d", height, out.Height)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package transaction
+
+import (
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
+	"github.com/limitzhang87/goblockchain/wire"
+)
+
+const (
+	// LegacyVersion 旧版本使用gob计算交易哈希，gob编码和进程内类型注册的顺序有关，无法稳定重现
+	// 从旧数据库迁移过来的交易保留原来的ID和签名
+	LegacyVersion = 0
+	// WireVersion 交易哈希使用wire编码，没有锁定时间和序列号
+	WireVersion = 1
+	// TxVersion 当前交易版本，增加交易的锁定时间和交易输入的序列号
+	TxVersion = 2
+)
+
+// Serialize 交易的二进制编码，包含交易ID
+func (tx *Transaction) Serialize() []byte {
+	w := wire.NewWriter()
+	tx.Encode(w)
+	return w.Bytes()
+}
+
+// Encode 按版本、ID、交易输入、交易输出的顺序写入交易
+func (tx *Transaction) Encode(w *wire.Writer) {
+	w.WriteUvarint(uint64(tx.Version))
+	w.WriteBytes(tx.ID)
+	tx.encodeBody(w)
+}
+
+// encodeBody 写入交易输入、交易输出和锁定时间，交易哈希只使用这一部分
+// WireVersion的交易没有序列号和锁定时间，保证旧交易的哈希不变
+func (tx *Transaction) encodeBody(w *wire.Writer) {
+	w.WriteUvarint(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		w.WriteBytes(in.TxID)
+		w.WriteVarint(int64(in.OutIdx))
+		w.WriteBytes(in.PubKey)
+		w.WriteBytes(in.Sig)
+		if tx.Version >= TxVersion {
+			w.WriteUvarint(uint64(in.Sequence))
+		}
+	}
+	w.WriteUvarint(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		w.WriteVarint(int64(out.Value))
+		w.WriteBytes(out.PubKeyHash)
+	}
+	if tx.Version >= TxVersion {
+		w.WriteUvarint(uint64(tx.LockTime))
+	}
+}
+
+// DeserializeTransaction 解码Serialize的结果
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	if err := wire.CheckSize(data, chaincfg.ActiveParams().MaxTxSize); err != nil {
+		return nil, fmt.Errorf("decode transaction: %w", err)
+	}
+	r := wire.NewReader(data)
+	tx := DecodeTransaction(r)
+	if err := r.Finish(); err != nil {
+		return nil, fmt.Errorf("decode transaction: %w", err)
+	}
+	return tx, nil
+}
+
+// DecodeTransaction 从r中读取一笔交易，错误通过r.Err返回
+// 交易输入和交易输出的个数超过共识限制时直接失败
+func DecodeTransaction(r *wire.Reader) *Transaction {
+	params := chaincfg.ActiveParams()
+	tx := &Transaction{}
+	tx.Version = int(r.ReadVersion(TxVersion))
+	tx.ID = r.ReadBytes()
+
+	// 交易输入至少4个字节，交易输出至少2个字节
+	inCount := r.ReadCountMax(4, params.MaxTxInputs)
+	tx.Inputs = make([]TxInput, 0, inCount)
+	for i := 0; i < inCount; i++ {
+		in := TxInput{
+			TxID:   r.ReadBytes(),
+			OutIdx: int(r.ReadVarint()),
+			PubKey: r.ReadBytes(),
+			Sig:    r.ReadBytes(),
+		}
+		if tx.Version >= TxVersion {
+			in.Sequence = r.ReadUint32()
+		}
+		tx.Inputs = append(tx.Inputs, in)
+	}
+	outCount := r.ReadCountMax(2, params.MaxTxOutputs)
+	tx.Outputs = make([]TxOutput, 0, outCount)
+	for i := 0; i < outCount; i++ {
+		tx.Outputs = append(tx.Outputs, TxOutput{
+			Value:      int(r.ReadVarint()),
+			PubKeyHash: r.ReadBytes(),
+		})
+	}
+	if tx.Version >= TxVersion {
+		tx.LockTime = r.ReadUint32()
+	}
+	return tx
+}
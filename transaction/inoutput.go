@@ -7,10 +7,11 @@ import (
 
 // TxInput 交易输入
 type TxInput struct {
-	TxID   []byte
-	OutIdx int
-	PubKey []byte // 公钥
-	Sig    []byte // 数字签名
+	TxID     []byte
+	OutIdx   int
+	PubKey   []byte // 公钥
+	Sig      []byte // 数字签名
+	Sequence uint32 // 序列号，所有交易输入都是SequenceFinal时交易的锁定时间不生效
 }
 
 // TxOutput 交易输出
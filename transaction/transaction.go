@@ -4,25 +4,34 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/sha256"
-	"encoding/gob"
-	"github.com/limitzhang87/goblockchain/constcoe"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/chaincfg"
 	"github.com/limitzhang87/goblockchain/utils"
+	"github.com/limitzhang87/goblockchain/wire"
+	"math"
 )
 
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	Version  int
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime uint32 // 小于LockTimeThreshold时为区块高度，否则为unix时间，交易只能打包到这之后的区块中
 }
 
-func (tx *Transaction) TxHash() []byte {
-	var encoded bytes.Buffer
-	var hash [32]byte
+const (
+	// SequenceFinal 交易输入的默认序列号
+	SequenceFinal uint32 = math.MaxUint32
+	// LockTimeThreshold 锁定时间小于这个值表示区块高度，否则表示unix时间
+	LockTimeThreshold = 500000000
+)
 
-	encoder := gob.NewEncoder(&encoded)
-	err := encoder.Encode(tx)
-	utils.Handle(err)
-	hash = sha256.Sum256(encoded.Bytes())
+// TxHash 交易哈希，包含版本、交易输入、交易输出和锁定时间，不包含交易ID
+func (tx *Transaction) TxHash() []byte {
+	w := wire.NewWriter()
+	w.WriteUvarint(uint64(tx.Version))
+	tx.encodeBody(w)
+	hash := sha256.Sum256(w.Bytes())
 	return hash[:]
 }
 
@@ -32,31 +41,120 @@ func (tx *Transaction) SetId() {
 
 func BaseTx(toAddress []byte) *Transaction {
 	input := TxInput{
-		TxID:   []byte{},
-		OutIdx: -1,
-		PubKey: []byte{},
+		TxID:     []byte{},
+		OutIdx:   -1,
+		PubKey:   []byte{},
+		Sequence: SequenceFinal,
 	}
 
 	output := TxOutput{
-		Value:      constcoe.InitCoin,
+		Value:      chaincfg.ActiveParams().GenesisReward,
 		PubKeyHash: toAddress,
 	}
 	tx := Transaction{
-		ID:      []byte("This is the Base Transaction!"),
+		Version: TxVersion,
 		Inputs:  []TxInput{input},
 		Outputs: []TxOutput{output},
 	}
+	tx.SetId()
 	return &tx
 }
 
+// CoinbaseTx 挖矿奖励交易，区块高度写入交易输入的签名字段，保证每个区块的奖励交易ID不同
+func CoinbaseTx(toPubKeyHash []byte, value int, height int) *Transaction {
+	input := TxInput{
+		TxID:     []byte{},
+		OutIdx:   -1,
+		PubKey:   []byte{},
+		Sig:      utils.ToHexInt(int64(height)),
+		Sequence: SequenceFinal,
+	}
+
+	output := TxOutput{
+		Value:      value,
+		PubKeyHash: toPubKeyHash,
+	}
+	tx := Transaction{
+		Version: TxVersion,
+		Inputs:  []TxInput{input},
+		Outputs: []TxOutput{output},
+	}
+	tx.SetId()
+	return &tx
+}
+
+// SetExtraNonce 修改挖矿奖励交易中的ExtraNonce并重新计算交易ID，区块的merkle根也会随之变化
+// 区块高度之后的8个字节为ExtraNonce，返回false表示不是可以修改的挖矿奖励交易
+func (tx *Transaction) SetExtraNonce(extraNonce int64) bool {
+	if !tx.IsBase() || len(tx.Inputs[0].Sig) < 8 {
+		return false
+	}
+	height := tx.Inputs[0].Sig[:8:8]
+	tx.Inputs[0].Sig = append(height, utils.ToHexInt(extraNonce)...)
+	tx.SetId()
+	return true
+}
+
+// VerifyID 验证交易ID和交易内容是否一致
+// 普通交易的ID在签名之前计算，验证时去掉签名；旧版本交易的ID无法重新计算，直接认为一致
+func (tx *Transaction) VerifyID() bool {
+	if tx.Version == LegacyVersion {
+		return true
+	}
+	if tx.IsBase() {
+		return bytes.Equal(tx.ID, tx.TxHash())
+	}
+	unsigned := *tx
+	unsigned.Inputs = make([]TxInput, 0, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		unsigned.Inputs = append(unsigned.Inputs, TxInput{TxID: in.TxID, OutIdx: in.OutIdx, PubKey: in.PubKey, Sequence: in.Sequence})
+	}
+	return bytes.Equal(tx.ID, unsigned.TxHash())
+}
+
 func (tx *Transaction) IsBase() bool {
 	return len(tx.Inputs) == 1 && tx.Inputs[0].OutIdx == -1
 }
 
+// SetLockTime 设置锁定时间并重新计算交易ID，锁定时间不为0时交易输入的序列号改为SequenceFinal-1，让锁定时间生效
+// 交易ID在签名之前计算，所以需要在签名之前调用
+func (tx *Transaction) SetLockTime(lockTime uint32) {
+	tx.LockTime = lockTime
+	sequence := SequenceFinal
+	if lockTime != 0 {
+		sequence = SequenceFinal - 1
+	}
+	for idx := range tx.Inputs {
+		tx.Inputs[idx].Sequence = sequence
+	}
+	tx.SetId()
+}
+
+// IsFinal 判断交易能否打包到高度为height、时间为blockTime的区块中
+// 锁定时间为0或者所有交易输入的序列号都是SequenceFinal时交易不受锁定时间限制
+func (tx *Transaction) IsFinal(height int, blockTime int64) bool {
+	if tx.Version < TxVersion || tx.LockTime == 0 {
+		return true
+	}
+	limit := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		limit = blockTime
+	}
+	if int64(tx.LockTime) < limit {
+		return true
+	}
+	for _, in := range tx.Inputs {
+		if in.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
 // Sign 对交易进行签名
-func (tx *Transaction) Sign(priKey ecdsa.PrivateKey) {
+func (tx *Transaction) Sign(priKey ecdsa.PrivateKey) error {
 	if tx.IsBase() {
-		return
+		return nil
 	}
 	/*
 		对一个交易进行签名时，需要分别针对每一笔交易输入进行签名，
@@ -65,9 +163,13 @@ func (tx *Transaction) Sign(priKey ecdsa.PrivateKey) {
 	*/
 	for idx, in := range tx.Inputs {
 		plainHash := tx.PlainHash(idx, in.PubKey)
-		signature := utils.Sign(plainHash, priKey)
+		signature, err := utils.Sign(plainHash, priKey)
+		if err != nil {
+			return fmt.Errorf("sign input %d: %w", idx, err)
+		}
 		tx.Inputs[idx].Sig = signature
 	}
+	return nil
 }
 
 // PlainHash 加密前数据hash
@@ -83,16 +185,18 @@ func (tx *Transaction) PlainCopy() *Transaction {
 	output := make([]TxOutput, 0, len(tx.Outputs))
 
 	for _, in := range tx.Inputs {
-		input = append(input, TxInput{TxID: in.TxID, OutIdx: in.OutIdx})
+		input = append(input, TxInput{TxID: in.TxID, OutIdx: in.OutIdx, Sequence: in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
 		output = append(output, TxOutput{Value: out.Value, PubKeyHash: out.PubKeyHash})
 	}
 	return &Transaction{
-		ID:      tx.ID,
-		Inputs:  input,
-		Outputs: output,
+		Version:  tx.Version,
+		ID:       tx.ID,
+		Inputs:   input,
+		Outputs:  output,
+		LockTime: tx.LockTime,
 	}
 }
 
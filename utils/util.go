@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -10,24 +9,14 @@ import (
 	"github.com/limitzhang87/goblockchain/constcoe"
 	"github.com/mr-tron/base58"
 	"golang.org/x/crypto/ripemd160"
-	"log"
 	"math/big"
 	"os"
 )
 
-func Handle(err error) {
-	if err != nil {
-		log.Panic(err)
-	}
-}
-
 func ToHexInt(num int64) []byte {
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.BigEndian, num)
-	if err != nil {
-		log.Panic(err)
-	}
-	return buff.Bytes()
+	buff := make([]byte, 8)
+	binary.BigEndian.PutUint64(buff, uint64(num))
+	return buff
 }
 
 func FileExists(fileAddr string) bool {
@@ -41,8 +30,7 @@ func FileExists(fileAddr string) bool {
 func PublicKeyHash(public []byte) []byte {
 	hashedPublicKey := sha256.Sum256(public)
 	hasher := ripemd160.New()
-	_, err := hasher.Write(hashedPublicKey[:])
-	Handle(err)
+	_, _ = hasher.Write(hashedPublicKey[:]) // hash.Hash 的 Write 不会返回错误
 	publicRipeMd := hasher.Sum(nil)
 	return publicRipeMd
 }
@@ -57,33 +45,22 @@ func Base58Encode(input []byte) []byte {
 	return []byte(base58.Encode(input))
 }
 
-func Base58Decode(input []byte) []byte {
-	decode, err := base58.Decode(string(input[:]))
-	Handle(err)
-	return decode
-}
-
-// PubHash2Address 公钥哈希转为签到地址
-func PubHash2Address(publicKeyHash []byte) []byte {
-	networkVersionHash := append([]byte{constcoe.NetworkVersion}, publicKeyHash...)
-	checksum := Checksum(networkVersionHash)
-	finalHash := append(networkVersionHash, checksum...)
-	return Base58Encode(finalHash)
-}
-
-// Address2PubHash 地址转为公钥哈希
-func Address2PubHash(address []byte) []byte {
-	decodeHash := Base58Decode(address)
-	pubKeyHash := decodeHash[1 : len(decodeHash)-constcoe.ChecksumLength]
-	return pubKeyHash
+func Base58Decode(input []byte) ([]byte, error) {
+	return base58.Decode(string(input[:]))
 }
 
 // Sign 根据私钥对数据进行签名
-func Sign(msg []byte, privateKey ecdsa.PrivateKey) []byte {
+// r和s补齐到曲线的字节数再拼接，验证时从中间分开，r或者s的高位为0时也能正确还原
+func Sign(msg []byte, privateKey ecdsa.PrivateKey) ([]byte, error) {
 	r, s, err := ecdsa.Sign(rand.Reader, &privateKey, msg)
-	Handle(err)
-	signature := append(r.Bytes(), s.Bytes()...)
-	return signature
+	if err != nil {
+		return nil, err
+	}
+	size := (privateKey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
 }
 
 // Verity 验证签名
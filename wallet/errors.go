@@ -0,0 +1,9 @@
+package wallet
+
+import "errors"
+
+var (
+	ErrWalletNotFound    = errors.New("wallet not found")
+	ErrInvalidWalletFile = errors.New("invalid wallet file")
+	ErrRefNameNotFound   = errors.New("refName not found")
+)
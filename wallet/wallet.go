@@ -6,18 +6,24 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
-	"github.com/limitzhang87/goblockchain/constcoe"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/config"
 	"github.com/limitzhang87/goblockchain/utils"
 	"os"
+	"path/filepath"
 )
 
-func NewKeyPair() (ecdsa.PrivateKey, []byte) {
+func NewKeyPair() (ecdsa.PrivateKey, []byte, error) {
 	curve := elliptic.P256()
 	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
-	utils.Handle(err)
+	if err != nil {
+		return ecdsa.PrivateKey{}, nil, fmt.Errorf("generate key: %w", err)
+	}
 	// 根据椭圆算法，公钥就是椭圆的两个点
 	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
-	return *privateKey, publicKey
+	return *privateKey, publicKey, nil
 }
 
 type Wallet struct {
@@ -25,9 +31,12 @@ type Wallet struct {
 	PublicKey  []byte
 }
 
-func NewWallet() *Wallet {
-	privateKey, publicKey := NewKeyPair()
-	return &Wallet{privateKey, publicKey}
+func NewWallet() (*Wallet, error) {
+	privateKey, publicKey, err := NewKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{privateKey, publicKey}, nil
 }
 
 // Address 公钥哈希
@@ -35,13 +44,18 @@ func (w *Wallet) Address() []byte {
 	// 1.公钥转为公钥哈希
 	pubKeyHash := utils.PublicKeyHash(w.PublicKey)
 	// 2. 公钥哈希转为签到地址
-	address := utils.PubHash2Address(pubKeyHash)
-	return address
+	addr := address.Encode(pubKeyHash)
+	return addr
 }
 
 // Save 保存钱包
-func (w *Wallet) Save() {
-	filename := constcoe.Wallets + string(w.Address()) + ".wlt"
+func (w *Wallet) Save() error {
+	dir := config.Active().WalletsDir
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, string(w.Address())+".wlt")
 	//var content bytes.Buffer
 	//gob.Register(elliptic.P256())
 	//encoder := gob.NewEncoder(&content)
@@ -50,32 +64,50 @@ func (w *Wallet) Save() {
 	// err = os.WriteFile(filename, jsonData, 0644)
 
 	privateKeyBytes, err := x509.MarshalECPrivateKey(&w.PrivateKey)
-	utils.Handle(err)
+	if err != nil {
+		return err
+	}
 	privateKeyFile, err := os.Create(filename)
-	utils.Handle(err)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = privateKeyFile.Close()
 	}()
-	err = pem.Encode(privateKeyFile, &pem.Block{
+	return pem.Encode(privateKeyFile, &pem.Block{
 		Bytes: privateKeyBytes,
 	})
-	utils.Handle(err)
 }
 
 // LoadWallet 根据地址返回钱包
-func LoadWallet(address string) *Wallet {
-	filename := constcoe.Wallets + address + ".wlt"
+func LoadWallet(addr string) (*Wallet, error) {
+	// 地址会拼接成文件路径，先校验地址
+	err := address.Validate([]byte(addr))
+	if err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(config.Active().WalletsDir, addr+".wlt")
 
 	privateKeyFile, err := os.ReadFile(filename)
-	utils.Handle(err)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
 	pemBlock, _ := pem.Decode(privateKeyFile)
+	if pemBlock == nil {
+		return nil, fmt.Errorf("%w: %s is not pem encoded", ErrInvalidWalletFile, filename)
+	}
 	privateKey, err := x509.ParseECPrivateKey(pemBlock.Bytes)
-	utils.Handle(err)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWalletFile, err)
+	}
 	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
 	return &Wallet{
 		PrivateKey: *privateKey,
 		PublicKey:  publicKey,
-	}
+	}, nil
 
 	//gob.Register(elliptic.P256())
 	//decoder := gob.NewDecoder(bytes.NewReader(content))
@@ -3,8 +3,8 @@ package wallet
 import (
 	"bytes"
 	"encoding/gob"
-	"errors"
-	"github.com/limitzhang87/goblockchain/constcoe"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/config"
 	"github.com/limitzhang87/goblockchain/utils"
 	"os"
 	"path/filepath"
@@ -13,20 +13,29 @@ import (
 
 type RefList map[string]string
 
-func (r *RefList) Save() {
-	filename := constcoe.WalletsRefList + "ref_list.data"
+func (r *RefList) Save() error {
+	filename := config.Active().RefListFile
 	var buffer bytes.Buffer
 
 	encoder := gob.NewEncoder(&buffer)
 	err := encoder.Encode(r)
-	utils.Handle(err)
-	err = os.WriteFile(filename, buffer.Bytes(), 0644)
-	utils.Handle(err)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, buffer.Bytes(), 0644)
 }
 
 // Update 用于遍历钱包存在refList
-func (r *RefList) Update() {
-	err := filepath.Walk(constcoe.Wallets, func(path string, f os.FileInfo, err error) error {
+func (r *RefList) Update() error {
+	dir := config.Active().WalletsDir
+	if !utils.FileExists(dir) {
+		return nil // 还没有创建过钱包
+	}
+	return filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
 		if f == nil {
 			return err
 		}
@@ -34,7 +43,7 @@ func (r *RefList) Update() {
 			return nil
 		}
 		filename := f.Name()
-		if strings.Compare(filename[len(filename)-4:], ".wlt") == 0 {
+		if strings.HasSuffix(filename, ".wlt") {
 			_, ok := (*r)[filename[:len(filename)-4]]
 			if !ok {
 				(*r)[filename[:len(filename)-4]] = ""
@@ -42,7 +51,6 @@ func (r *RefList) Update() {
 		}
 		return nil
 	})
-	utils.Handle(err)
 }
 
 // BindRef 别名绑定地址
@@ -59,25 +67,32 @@ func (r *RefList) FindRef(refName string) (string, error) {
 		}
 	}
 	if tmp == "" {
-		return "", errors.New("refName not found")
+		return "", fmt.Errorf("%w: %s", ErrRefNameNotFound, refName)
 	}
 	return tmp, nil
 
 }
 
 // LoadRefList 加载地址与别名
-func LoadRefList() *RefList {
-	filename := constcoe.WalletsRefList + "ref_list.data"
+func LoadRefList() (*RefList, error) {
+	filename := config.Active().RefListFile
 	var refList RefList
 	if utils.FileExists(filename) {
 		fileContent, err := os.ReadFile(filename)
-		utils.Handle(err)
+		if err != nil {
+			return nil, err
+		}
 		decoder := gob.NewDecoder(bytes.NewBuffer(fileContent))
 		err = decoder.Decode(&refList)
-		utils.Handle(err)
+		if err != nil {
+			return nil, fmt.Errorf("decode ref list: %w", err)
+		}
 	} else {
 		refList = make(RefList)
-		refList.Update()
+		err := refList.Update()
+		if err != nil {
+			return nil, err
+		}
 	}
-	return &refList
+	return &refList, nil
 }
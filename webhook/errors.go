@@ -0,0 +1,10 @@
+package webhook
+
+import "errors"
+
+var (
+	ErrWatchExists   = errors.New("address is already watched with this url")
+	ErrWatchNotFound = errors.New("watch not found")
+	ErrBadURL        = errors.New("bad webhook url")
+	ErrRejected      = errors.New("webhook rejected the notification")
+)
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package webhook
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// lockFile 等待拿到文件的排他锁，文件关闭时释放
+func lockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX)
+}
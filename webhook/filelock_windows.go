@@ -0,0 +1,13 @@
+//go:build windows
+
+package webhook
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFile 等待拿到文件的排他锁，文件关闭时释放
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,345 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/address"
+	"github.com/limitzhang87/goblockchain/blockchain"
+	"github.com/limitzhang87/goblockchain/config"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 通知的事件
+const (
+	EventMempool       = "mempool"       // 输出所在的交易进入交易池
+	EventConfirmed     = "confirmed"     // 输出所在的交易第一次被打包进区块
+	EventConfirmations = "confirmations" // 输出达到监视要求的确认数
+)
+
+// 通知请求的头部，签名是用监视的密钥对请求体计算的HMAC-SHA256
+const (
+	SignatureHeader = "Gbc-Signature"
+	EventHeader     = "Gbc-Event"
+	DeliveryHeader  = "Gbc-Delivery"
+	signaturePrefix = "sha256="
+)
+
+const (
+	DefaultBackoff     = time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultMaxAttempts = 10
+	// DefaultConfirmations 默认在输出达到6个确认时通知
+	DefaultConfirmations = 6
+	// EventBuffer 通知发送期间缓冲的事件个数
+	EventBuffer = 1024
+	// maxDeliveries 队列中最多保存的通知，超过时丢弃最早的通知
+	maxDeliveries = 10000
+	// idleInterval 没有待发送的通知时也定期检查文件，其他命令加入的通知不会等太久
+	idleInterval = time.Minute
+)
+
+// Notification POST给webhook的JSON
+type Notification struct {
+	ID      string `json:"id"`
+	Event   string `json:"event"`
+	Watch   string `json:"watch"`
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Output
+	Confirmations int   `json:"confirmations"`
+	Time          int64 `json:"time"`
+}
+
+// Sign 计算请求体的签名，放在SignatureHeader中
+func Sign(secret string, body []byte) string {
+	key, _ := hex.DecodeString(secret)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 接收方用监视的密钥验证签名
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Notifier 把支付给监视地址的输出变成通知，按队列发送，失败后等待的时间逐次翻倍
+type Notifier struct {
+	Client      *http.Client
+	Backoff     time.Duration // 第一次重试前等待的时间
+	MaxBackoff  time.Duration
+	MaxAttempts int       // 发送失败这么多次后丢弃通知
+	Log         io.Writer // 输出处理事件和发送的错误，为空时不输出
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Backoff:     DefaultBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		MaxAttempts: DefaultMaxAttempts,
+	}
+}
+
+func (n *Notifier) logf(format string, args ...any) {
+	if n.Log != nil {
+		fmt.Fprintf(n.Log, format+"\n", args...)
+	}
+}
+
+// Run 处理sub中的事件并发送通知，直到ctx被取消，返回前处理完已经收到的事件，没有发送的通知留在队列中
+// sub在产生事件的命令开始之前订阅，这样不会漏掉事件
+func (n *Notifier) Run(ctx context.Context, sub *blockchain.Subscription) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var dropped uint64
+	for {
+		if d := sub.Dropped(); d > dropped {
+			n.logf("Webhook missed %d events, the event buffer was full", d-dropped)
+			dropped = d
+		}
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case e := <-sub.C:
+					n.handle(e)
+				default:
+					return
+				}
+			}
+		case e := <-sub.C:
+			n.handle(e)
+		case <-timer.C:
+		}
+		next, err := n.Deliver(ctx)
+		if err != nil {
+			n.logf("Webhook delivery: %v", err)
+		}
+		wait := idleInterval
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer.Reset(wait)
+	}
+}
+
+func (n *Notifier) handle(e blockchain.Event) {
+	if err := n.Handle(e); err != nil {
+		n.logf("Webhook %s %x: %v", e.Type, e.Hash(), err)
+	}
+}
+
+// Handle 把事件中支付给监视地址的输出加入通知队列
+func (n *Notifier) Handle(e blockchain.Event) error {
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s, err := loadState()
+	if err != nil || len(s.Watches) == 0 {
+		return err
+	}
+	watches := make(map[string][]*Watch)
+	for _, w := range s.Watches {
+		pubKeyHash, err := address.Decode([]byte(w.Address))
+		if err != nil {
+			return fmt.Errorf("watch %s: %w", w.ID, err)
+		}
+		key := hex.EncodeToString(pubKeyHash)
+		watches[key] = append(watches[key], w)
+	}
+	now := time.Now()
+
+	switch e.Type {
+	case blockchain.TxAccepted:
+		for idx, out := range e.Tx.Outputs {
+			for _, w := range watches[hex.EncodeToString(out.PubKeyHash)] {
+				output := Output{TxID: hex.EncodeToString(e.Tx.ID), OutIdx: idx, Value: out.Value}
+				if err = s.enqueue(w, EventMempool, output, 0, now); err != nil {
+					return err
+				}
+			}
+		}
+	case blockchain.BlockConnected:
+		// 之前的区块中的输出多了一个确认
+		kept := s.Pending[:0]
+		for _, p := range s.Pending {
+			w := s.watch(p.WatchID)
+			if w == nil {
+				continue
+			}
+			confirmations := e.Height - p.BlockHeight + 1
+			if confirmations < w.Confirmations {
+				kept = append(kept, p)
+				continue
+			}
+			if err = s.enqueue(w, EventConfirmations, p.Output, confirmations, now); err != nil {
+				return err
+			}
+		}
+		s.Pending = kept
+		for _, tx := range e.Block.Transactions {
+			for idx, out := range tx.Outputs {
+				for _, w := range watches[hex.EncodeToString(out.PubKeyHash)] {
+					output := Output{
+						TxID:        hex.EncodeToString(tx.ID),
+						OutIdx:      idx,
+						Value:       out.Value,
+						BlockHash:   hex.EncodeToString(e.Block.Hash),
+						BlockHeight: e.Height,
+					}
+					if err = s.enqueue(w, EventConfirmed, output, 1, now); err != nil {
+						return err
+					}
+					if w.Confirmations > 1 {
+						s.Pending = append(s.Pending, &pending{WatchID: w.ID, Output: output})
+					}
+				}
+			}
+		}
+	case blockchain.BlockDisconnected:
+		// 断开的区块中的输出不再等待确认，交易回到交易池时会重新通知
+		kept := s.Pending[:0]
+		for _, p := range s.Pending {
+			if p.BlockHeight < e.Height {
+				kept = append(kept, p)
+			}
+		}
+		s.Pending = kept
+	default:
+		return nil
+	}
+	return s.save()
+}
+
+func (s *state) enqueue(w *Watch, event string, output Output, confirmations int, now time.Time) error {
+	id, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&Notification{
+		ID:            id,
+		Event:         event,
+		Watch:         w.ID,
+		Network:       config.Active().Network,
+		Address:       w.Address,
+		Output:        output,
+		Confirmations: confirmations,
+		Time:          now.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	s.Deliveries = append(s.Deliveries, &Delivery{ID: id, WatchID: w.ID, Event: event, Body: body, NextAttempt: now})
+	if over := len(s.Deliveries) - maxDeliveries; over > 0 {
+		s.Deliveries = append(s.Deliveries[:0], s.Deliveries[over:]...)
+	}
+	return nil
+}
+
+// backoff 第attempts次失败后等待的时间
+func (n *Notifier) backoff(attempts int) time.Duration {
+	wait := n.Backoff
+	for i := 1; i < attempts && wait < n.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > n.MaxBackoff {
+		wait = n.MaxBackoff
+	}
+	return wait
+}
+
+// Deliver 发送所有到期的通知，返回下一个通知的发送时间，队列为空时返回零值
+// 发送期间不持有锁，发送的结果写回时重新读取文件，发送期间加入的通知和取消的监视不会丢失
+func (n *Notifier) Deliver(ctx context.Context) (time.Time, error) {
+	unlock, err := lock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	s, err := loadState()
+	unlock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	results := make(map[string]error)
+	now := time.Now()
+	for _, d := range s.Deliveries {
+		w := s.watch(d.WatchID)
+		if w == nil || d.NextAttempt.After(now) {
+			continue
+		}
+		err := n.post(ctx, w, d)
+		if err != nil && ctx.Err() != nil {
+			// 停止时没有发完的通知不算一次失败，已经发出的通知仍然从队列中删除
+			break
+		}
+		results[d.ID] = err
+	}
+
+	var next time.Time
+	if len(results) == 0 {
+		for _, d := range s.Deliveries {
+			if next.IsZero() || d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+		}
+		return next, nil
+	}
+	err = update(func(s *state) error {
+		kept := s.Deliveries[:0]
+		for _, d := range s.Deliveries {
+			err, sent := results[d.ID]
+			if sent && err == nil {
+				continue
+			}
+			if sent {
+				d.Attempts++
+				d.LastError = err.Error()
+				if d.Attempts >= n.MaxAttempts {
+					n.logf("Webhook %s gave up on %s after %d attempts: %v", d.WatchID, d.ID, d.Attempts, err)
+					continue
+				}
+				d.NextAttempt = time.Now().Add(n.backoff(d.Attempts))
+			}
+			kept = append(kept, d)
+			if next.IsZero() || d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+		}
+		s.Deliveries = kept
+		return nil
+	})
+	return next, err
+}
+
+func (n *Notifier) post(ctx context.Context, w *Watch, d *Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(SignatureHeader, Sign(w.Secret, d.Body))
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrRejected, resp.Status)
+	}
+	return nil
+}
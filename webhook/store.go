@@ -0,0 +1,273 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/limitzhang87/goblockchain/config"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 监视的地址、等待更多确认的输出和待发送的通知保存在同一个JSON文件中，文件中有签名密钥，只有当前用户可以读写
+// 守护进程中的命令、发送通知的goroutine和其他进程中的命令会同时修改文件，所有读写都在lock返回的锁中完成
+
+// mu 同一个进程中的goroutine先拿mu再拿锁文件，文件锁在有的系统上不区分同一个进程中的多次加锁
+var mu sync.Mutex
+
+// lock 拿到读写通知文件的锁，其他进程读写同一个文件时等待，返回释放锁的函数
+func lock() (func(), error) {
+	mu.Lock()
+	filename := config.Active().WebhooksFile + ".lock"
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		_ = f.Close()
+		mu.Unlock()
+		return nil, fmt.Errorf("lock %s: %w", filename, err)
+	}
+	return func() {
+		_ = f.Close()
+		mu.Unlock()
+	}, nil
+}
+
+// Watch 一个监视的地址，支付给地址的输出进入交易池、第一次确认和达到Confirmations个确认时通知URL
+type Watch struct {
+	ID            string `json:"id"`
+	Address       string `json:"address"`
+	URL           string `json:"url"`
+	Secret        string `json:"secret"` // 签名密钥的十六进制
+	Confirmations int    `json:"confirmations"`
+}
+
+// Output 支付给监视地址的输出，进入交易池时没有区块
+type Output struct {
+	TxID        string `json:"txid"`
+	OutIdx      int    `json:"vout"`
+	Value       int    `json:"value"`
+	BlockHash   string `json:"blockhash,omitempty"`
+	BlockHeight int    `json:"blockheight,omitempty"`
+}
+
+// pending 已经确认但还没有达到监视要求的确认数的输出
+type pending struct {
+	WatchID string `json:"watch"`
+	Output
+}
+
+type state struct {
+	Watches    []*Watch    `json:"watches"`
+	Pending    []*pending  `json:"pending"`
+	Deliveries []*Delivery `json:"deliveries"`
+}
+
+func (s *state) watch(id string) *Watch {
+	for _, w := range s.Watches {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+func loadState() (*state, error) {
+	s := &state{}
+	data, err := os.ReadFile(config.Active().WebhooksFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", config.Active().WebhooksFile, err)
+	}
+	return s, nil
+}
+
+// save 先写临时文件再改名，进程退出时不会留下写了一半的文件
+func (s *state) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := config.Active().WebhooksFile
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	tmpFile := filename + ".tmp"
+	if err = os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filename)
+}
+
+// update 读取文件，fn没有返回错误时保存修改
+func update(fn func(s *state) error) error {
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	if err = fn(s); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AddWatch 监视地址，waiting是注册之前已经确认但还没有达到confirmations个确认的输出，达到时也会通知
+func AddWatch(address, rawURL string, confirmations int, waiting []Output) (*Watch, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q, use http:// or https://", ErrBadURL, rawURL)
+	}
+	if confirmations < 1 {
+		return nil, fmt.Errorf("confirmations must be at least 1, got %d", confirmations)
+	}
+	watch := &Watch{Address: address, URL: rawURL, Confirmations: confirmations}
+	if watch.ID, err = randomHex(8); err != nil {
+		return nil, err
+	}
+	if watch.Secret, err = randomHex(32); err != nil {
+		return nil, err
+	}
+	err = update(func(s *state) error {
+		for _, w := range s.Watches {
+			if w.Address == address && w.URL == rawURL {
+				return fmt.Errorf("%w: %s", ErrWatchExists, w.ID)
+			}
+		}
+		s.Watches = append(s.Watches, watch)
+		for _, out := range waiting {
+			s.Pending = append(s.Pending, &pending{WatchID: watch.ID, Output: out})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return watch, nil
+}
+
+// RemoveWatch 取消监视，还没有发送的通知也不再发送
+func RemoveWatch(id string) error {
+	return update(func(s *state) error {
+		if s.watch(id) == nil {
+			return fmt.Errorf("%w: %s", ErrWatchNotFound, id)
+		}
+		watches := s.Watches[:0]
+		for _, w := range s.Watches {
+			if w.ID != id {
+				watches = append(watches, w)
+			}
+		}
+		s.Watches = watches
+		s.dropWatch(id)
+		return nil
+	})
+}
+
+// dropWatch 删除属于已经取消的监视的输出和通知
+func (s *state) dropWatch(id string) {
+	kept := s.Pending[:0]
+	for _, p := range s.Pending {
+		if p.WatchID != id {
+			kept = append(kept, p)
+		}
+	}
+	s.Pending = kept
+	deliveries := s.Deliveries[:0]
+	for _, d := range s.Deliveries {
+		if d.WatchID != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.Deliveries = deliveries
+}
+
+// WatchInfo 监视的地址和它等待发送的通知个数
+type WatchInfo struct {
+	Watch
+	Waiting int // 等待更多确认的输出个数
+	Queued  int // 等待发送或重试的通知个数
+}
+
+// ListWatches 返回所有监视的地址
+func ListWatches() ([]WatchInfo, error) {
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	s, err := loadState()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]WatchInfo, 0, len(s.Watches))
+	for _, w := range s.Watches {
+		info := WatchInfo{Watch: *w}
+		for _, p := range s.Pending {
+			if p.WatchID == w.ID {
+				info.Waiting++
+			}
+		}
+		for _, d := range s.Deliveries {
+			if d.WatchID == w.ID {
+				info.Queued++
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Watching 是否有监视的地址，没有时不需要处理事件
+func Watching() (bool, error) {
+	unlock, err := lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	s, err := loadState()
+	if err != nil {
+		return false, err
+	}
+	return len(s.Watches) > 0, nil
+}
+
+// Delivery 待发送的通知，发送失败后在NextAttempt重试
+type Delivery struct {
+	ID          string          `json:"id"`
+	WatchID     string          `json:"watch"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
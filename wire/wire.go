@@ -0,0 +1,206 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// 区块、区块头和交易的二进制编码规则：
+// 字段按固定顺序写入，整数使用varint编码，字节数组前面写入长度
+// 同样的数据只有一种编码，解码时拒绝非最短的varint和多余的数据
+
+var (
+	ErrTruncated      = errors.New("wire: unexpected end of data")
+	ErrNonCanonical   = errors.New("wire: non-canonical varint")
+	ErrTrailingData   = errors.New("wire: trailing data")
+	ErrUnknownVersion = errors.New("wire: unknown version")
+	ErrTooLarge       = errors.New("wire: data exceeds limit")
+)
+
+// Writer 按顺序写入字段
+type Writer struct {
+	buf []byte
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+func (w *Writer) WriteUvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *Writer) WriteVarint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+// WriteUint64 固定8个字节的大端整数，区块头的nonce使用，挖矿时可以直接修改
+func (w *Writer) WriteUint64(v uint64) {
+	w.buf = binary.BigEndian.AppendUint64(w.buf, v)
+}
+
+// WriteBytes 先写入长度再写入数据
+func (w *Writer) WriteBytes(b []byte) {
+	w.WriteUvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// Reader 按顺序读取字段，出错后后面的读取都返回零值，通过Err获取第一个错误
+type Reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+func (r *Reader) ReadUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.fail(ErrTruncated)
+		return 0
+	}
+	// 非最短编码会让同样的数据有不同的字节，也就有不同的哈希
+	if n != len(binary.AppendUvarint(nil, v)) {
+		r.fail(ErrNonCanonical)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *Reader) ReadVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		r.fail(ErrTruncated)
+		return 0
+	}
+	if n != len(binary.AppendVarint(nil, v)) {
+		r.fail(ErrNonCanonical)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+// ReadUint32 读取WriteUvarint写入的uint32，超出范围时失败
+func (r *Reader) ReadUint32() uint32 {
+	v := r.ReadUvarint()
+	if r.err == nil && v > math.MaxUint32 {
+		r.fail(fmt.Errorf("%w: %d overflows uint32", ErrTooLarge, v))
+		return 0
+	}
+	return uint32(v)
+}
+
+func (r *Reader) ReadUint64() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.Remaining() < 8 {
+		r.fail(ErrTruncated)
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data[r.pos:])
+	r.pos += 8
+	return v
+}
+
+// ReadBytes 读取长度和数据，返回的数据是拷贝
+func (r *Reader) ReadBytes() []byte {
+	length := r.ReadUvarint()
+	if r.err != nil {
+		return nil
+	}
+	if length > uint64(r.Remaining()) {
+		r.fail(ErrTruncated)
+		return nil
+	}
+	b := make([]byte, length)
+	copy(b, r.data[r.pos:])
+	r.pos += int(length)
+	return b
+}
+
+// ReadCount 读取元素个数，每个元素至少占minSize个字节，个数超出剩余数据时直接失败，避免按个数分配过大的内存
+func (r *Reader) ReadCount(minSize int) int {
+	count := r.ReadUvarint()
+	if r.err != nil {
+		return 0
+	}
+	if minSize < 1 {
+		minSize = 1
+	}
+	if count > uint64(r.Remaining()/minSize) {
+		r.fail(ErrTruncated)
+		return 0
+	}
+	return int(count)
+}
+
+// ReadCountMax 和ReadCount一样，个数超过max时失败
+func (r *Reader) ReadCountMax(minSize, max int) int {
+	count := r.ReadCount(minSize)
+	if r.err == nil && count > max {
+		r.fail(fmt.Errorf("%w: %d elements, at most %d", ErrTooLarge, count, max))
+		return 0
+	}
+	return count
+}
+
+// CheckSize 数据长度超过max时返回ErrTooLarge，解码之前检查，不会按数据中的长度分配内存
+func CheckSize(data []byte, max int) error {
+	if len(data) > max {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrTooLarge, len(data), max)
+	}
+	return nil
+}
+
+// ReadVersion 读取版本号，大于maxVersion时失败
+func (r *Reader) ReadVersion(maxVersion uint64) uint64 {
+	version := r.ReadUvarint()
+	if r.err == nil && version > maxVersion {
+		r.fail(fmt.Errorf("%w %d", ErrUnknownVersion, version))
+		return 0
+	}
+	return version
+}
+
+func (r *Reader) Remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Finish 数据应该正好读完，返回读取过程中的错误或者多余数据的错误
+func (r *Reader) Finish() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.Remaining() != 0 {
+		return fmt.Errorf("%w: %d bytes", ErrTrailingData, r.Remaining())
+	}
+	return nil
+}
+
+func (r *Reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}